@@ -26,6 +26,7 @@ import (
 
 	"github.com/rancher/elemental-cli/cmd/config"
 	"github.com/rancher/elemental-cli/pkg/action"
+	"github.com/rancher/elemental-cli/pkg/constants"
 	elementalError "github.com/rancher/elemental-cli/pkg/error"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 	"github.com/rancher/elemental-cli/pkg/utils"
@@ -56,7 +57,7 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 			}
 			mounter := mount.New(path)
 
-			cfg, err := config.ReadConfigBuild(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			cfg, err := config.ReadConfigBuild(cmd.Context(), viper.GetString("config-dir"), cmd.Flags(), mounter)
 			if err != nil {
 				cfg.Logger.Errorf("Error reading config: %s\n", err)
 				return elementalError.NewFromError(err, elementalError.ReadingBuildConfig)
@@ -130,20 +131,30 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 	}
 
 	firmType := newEnumFlag([]string{v1.EFI, v1.BIOS}, v1.EFI)
+	bootloader := newEnumFlag([]string{constants.LiveBootloaderGrub, constants.LiveBootloaderSystemdBoot}, constants.LiveBootloaderGrub)
 
 	root.AddCommand(c)
 	c.Flags().StringP("name", "n", "", "Basename of the generated ISO file")
 	c.Flags().StringP("output", "o", "", "Output directory (defaults to current directory)")
 	c.Flags().Bool("date", false, "Adds a date suffix into the generated ISO file")
+	c.Flags().Bool("reproducible", false, "Fail if the build is not reproducible, requires SOURCE_DATE_EPOCH to be set")
 	c.Flags().String("overlay-rootfs", "", "Path of the overlayed rootfs data")
 	c.Flags().String("overlay-uefi", "", "Path of the overlayed uefi data")
 	c.Flags().String("overlay-iso", "", "Path of the overlayed iso data")
 	c.Flags().String("label", "", "Label of the ISO volume")
 	c.Flags().Bool("bootloader-in-rootfs", false, "Fetch ISO bootloader binaries from the rootfs")
+	c.Flags().Var(bootloader, "bootloader", "Live bootloader fetched from the rootfs: 'grub' or 'systemd-boot'. (defaults to 'grub')")
+	c.Flags().Bool("uki", false, "Create a unified kernel image of the live system in the EFI image")
+	c.Flags().String("cloud-config", "", "Cloud-config file or URL to embed in the ISO, executed at live boot")
+	c.Flags().String("install-config", "", "Install configuration file or URL to embed in the ISO")
+	c.Flags().Bool("auto-install", false, "Add a boot entry installing the system with the embedded install configuration")
+	c.Flags().Bool("netboot", false, "Write kernel, initrd, squashfs and network boot configurations next to the ISO")
+	c.Flags().String("netboot-url", "", "Base URL the netboot artifacts are published at, over http or tftp")
 	c.Flags().Var(firmType, "firmware", "Firmware to install for: 'efi' or 'bios'. (defaults to 'efi')")
 	_ = c.Flags().MarkDeprecated("firmware", "'firmware' is deprecated. 'bios' firmware support is deprecated.")
 	addPlatformFlags(c)
 	addCosignFlags(c)
+	addSecureBootFlags(c)
 	addSquashFsCompressionFlags(c)
 	addLocalImageFlag(c)
 	return c
@@ -39,7 +39,7 @@ func NewCloudInitCmd(root *cobra.Command) *cobra.Command {
 			_ = viper.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), &mount.FakeMounter{})
+			cfg, err := config.ReadConfigRun(cmd.Context(), viper.GetString("config-dir"), cmd.Flags(), &mount.FakeMounter{})
 			if err != nil {
 				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
 			}
@@ -0,0 +1,131 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/mount-utils"
+
+	"github.com/rancher/elemental-cli/cmd/config"
+	"github.com/rancher/elemental-cli/pkg/action"
+	elementalConfig "github.com/rancher/elemental-cli/pkg/config"
+	"github.com/rancher/elemental-cli/pkg/constants"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+)
+
+// NewCmdlineCmd returns a new instance of the cmdline subcommand and appends it to
+// the root command. requireRoot is to initiate it with or without the CheckRoot
+// pre-run check. This method is mostly used for testing purposes.
+func NewCmdlineCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "cmdline",
+		Short: "Manage the extra kernel command line of the installed images",
+		Long: "Manage the extra kernel command line of the installed images\n\n" +
+			"The extra kernel command line of each image is stored in the grub OEM environment file\n" +
+			"of the state partition with the following variable names:\n" +
+			"    * " + constants.ActiveImgName + " - " + constants.GrubActiveCmdlineVar + "\n" +
+			"    * " + constants.PassiveImgName + " - " + constants.GrubPassiveCmdlineVar + "\n" +
+			"    * " + constants.RecoveryImgName + " - " + constants.GrubRecoveryCmdlineVar,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+	}
+
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Show the extra kernel command line of each image",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmdline, err := newCmdlineAction(cmd)
+			if err != nil {
+				return err
+			}
+			extra, err := cmdline.Show()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s\n", constants.ActiveImgName, extra.Active)
+			fmt.Printf("%s: %s\n", constants.PassiveImgName, extra.Passive)
+			fmt.Printf("%s: %s\n", constants.RecoveryImgName, extra.Recovery)
+			return nil
+		},
+	}
+
+	set := &cobra.Command{
+		Use:   "set IMAGE ARGS...",
+		Short: "Set the extra kernel command line of an image: 'active', 'passive' or 'recovery'",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmdline, err := newCmdlineAction(cmd)
+			if err != nil {
+				return err
+			}
+			return cmdline.Set(args[0], strings.Join(args[1:], " "))
+		},
+	}
+
+	reset := &cobra.Command{
+		Use:   "reset [IMAGE...]",
+		Short: "Remove the extra kernel command line of the given images, of all images if none is given",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmdline, err := newCmdlineAction(cmd)
+			if err != nil {
+				return err
+			}
+			return cmdline.Reset(args...)
+		},
+	}
+
+	root.AddCommand(c)
+	c.AddCommand(show, set, reset)
+	return c
+}
+
+// newCmdlineAction reads the run configuration and looks for the state partition of the host
+func newCmdlineAction(cmd *cobra.Command) (*action.CmdlineAction, error) {
+	path, err := exec.LookPath("mount")
+	if err != nil {
+		return nil, err
+	}
+	mounter := mount.New(path)
+
+	cfg, err := config.ReadConfigRun(cmd.Context(), viper.GetString("config-dir"), cmd.Flags(), mounter)
+	if err != nil {
+		cfg.Logger.Errorf("Error reading config: %s\n", err)
+		return nil, elementalError.NewFromError(err, elementalError.ReadingRunConfig)
+	}
+
+	cmd.SilenceUsage = true
+	state, err := elementalConfig.NewStatePartition(cfg.Config)
+	if err != nil {
+		cfg.Logger.Errorf("Could not find the state partition: %v", err)
+		return nil, elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+	}
+	return action.NewCmdlineAction(cfg, state), nil
+}
+
+// register the subcommand into rootCmd
+var _ = NewCmdlineCmd(rootCmd, true)
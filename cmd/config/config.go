@@ -17,13 +17,18 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/sanity-io/litter"
@@ -86,6 +91,23 @@ func setDecoder(config *mapstructure.DecoderConfig) {
 	config.ZeroFields = true
 }
 
+// readSourceDateEpoch sets the timestamp of generated artifacts from the
+// SOURCE_DATE_EPOCH environment variable, if defined
+func readSourceDateEpoch(cfg *v1.Config) error {
+	epoch, ok := os.LookupEnv(constants.SourceDateEpochEnv)
+	if !ok || epoch == "" {
+		return nil
+	}
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s value '%s': %w", constants.SourceDateEpochEnv, epoch, err)
+	}
+	date := time.Unix(secs, 0).UTC()
+	cfg.SourceDateEpoch = &date
+	cfg.Logger.Debugf("Using %s timestamp for generated artifacts", date.Format(time.RFC3339))
+	return nil
+}
+
 // BindGivenFlags binds to viper only passed flags, ignoring any non provided flag
 func bindGivenFlags(vp *viper.Viper, flagSet *pflag.FlagSet) {
 	if flagSet != nil {
@@ -97,7 +119,7 @@ func bindGivenFlags(vp *viper.Viper, flagSet *pflag.FlagSet) {
 	}
 }
 
-func ReadConfigBuild(configDir string, flags *pflag.FlagSet, mounter mount.Interface) (*v1.BuildConfig, error) {
+func ReadConfigBuild(ctx context.Context, configDir string, flags *pflag.FlagSet, mounter mount.Interface) (*v1.BuildConfig, error) {
 	logger := v1.NewLogger()
 
 	cfg := config.NewBuildConfig(
@@ -105,6 +127,7 @@ func ReadConfigBuild(configDir string, flags *pflag.FlagSet, mounter mount.Inter
 		config.WithMounter(mounter),
 		config.WithOCIImageExtractor(),
 	)
+	cfg.Runner.SetContext(ctx)
 
 	configLogger(cfg.Logger, cfg.Fs)
 	if configDir == "" {
@@ -138,17 +161,23 @@ func ReadConfigBuild(configDir string, flags *pflag.FlagSet, mounter mount.Inter
 		cfg.Logger.Warnf("error unmarshalling config: %s", err)
 	}
 
+	err = readSourceDateEpoch(&cfg.Config)
+	if err != nil {
+		return cfg, err
+	}
+
 	err = cfg.Sanitize()
 	cfg.Logger.Debugf("Full config loaded: %s", litter.Sdump(cfg))
 	return cfg, err
 }
 
-func ReadConfigRun(configDir string, flags *pflag.FlagSet, mounter mount.Interface) (*v1.RunConfig, error) {
+func ReadConfigRun(ctx context.Context, configDir string, flags *pflag.FlagSet, mounter mount.Interface) (*v1.RunConfig, error) {
 	cfg := config.NewRunConfig(
 		config.WithLogger(v1.NewLogger()),
 		config.WithMounter(mounter),
 		config.WithOCIImageExtractor(),
 	)
+	cfg.Runner.SetContext(ctx)
 	configLogger(cfg.Logger, cfg.Fs)
 	if configDir == "" {
 		configDir = constants.ConfigDir
@@ -210,11 +239,76 @@ func ReadConfigRun(configDir string, flags *pflag.FlagSet, mounter mount.Interfa
 		cfg.Logger.Warnf("error unmarshalling RunConfig: %s", err)
 	}
 
+	err = readSourceDateEpoch(&cfg.Config)
+	if err != nil {
+		return cfg, err
+	}
+
+	// Load custom bootloader profiles on configdir/bootloader.d/
+	profiles, err := utils.LoadBootloaderProfiles(cfg.Fs, filepath.Join(configDir, constants.BootloaderProfilesDir))
+	if err != nil {
+		cfg.Logger.Errorf("error loading bootloader profiles: %s", err)
+		return cfg, err
+	}
+	cfg.BootloaderProfiles = append(cfg.BootloaderProfiles, profiles...)
+
 	err = cfg.Sanitize()
 	cfg.Logger.Debugf("Full config loaded: %s", litter.Sdump(cfg))
 	return cfg, err
 }
 
+// ReadInstallConfigFromCmdline fetches the install configuration referenced by the
+// elemental.install.config kernel command line argument and merges it into the install
+// configuration tree, so it is considered by ReadInstallSpec
+func ReadInstallConfigFromCmdline(r *v1.RunConfig) error {
+	cmdline, err := r.Fs.ReadFile(constants.KernelCmdline)
+	if err != nil {
+		return err
+	}
+
+	var uri string
+	for _, arg := range strings.Fields(string(cmdline)) {
+		key, value, found := strings.Cut(arg, "=")
+		if found && key == constants.InstallConfigCmdlineArg {
+			uri = value
+		}
+	}
+	if uri == "" {
+		return fmt.Errorf("no '%s' argument found in kernel command line", constants.InstallConfigCmdlineArg)
+	}
+
+	tmpDir, err := utils.TempDir(r.Fs, "", "elemental-install")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Fs.RemoveAll(tmpDir)
+	}()
+
+	r.Logger.Infof("fetching install configuration from '%s'", uri)
+	cfgFile := filepath.Join(tmpDir, "install.yaml")
+	err = utils.GetSource(&r.Config, uri, cfgFile)
+	if err != nil {
+		r.Logger.Errorf("failed fetching install configuration: %s", err)
+		return err
+	}
+
+	data, err := r.Fs.ReadFile(cfgFile)
+	if err != nil {
+		return err
+	}
+
+	vp := viper.New()
+	vp.SetConfigType("yaml")
+	err = vp.ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		r.Logger.Errorf("error parsing install configuration: %s", err)
+		return err
+	}
+
+	return viper.MergeConfigMap(map[string]interface{}{"install": vp.AllSettings()})
+}
+
 func ReadInstallSpec(r *v1.RunConfig, flags *pflag.FlagSet) (*v1.InstallSpec, error) {
 	install := config.NewInstallSpec(r.Config)
 	vp := viper.Sub("install")
@@ -301,6 +395,40 @@ func ReadBuildISO(b *v1.BuildConfig, flags *pflag.FlagSet) (*v1.LiveISO, error)
 	return iso, err
 }
 
+// eventsWriter is the destination of the progress events opened by configLogger, if any
+var eventsWriter io.Writer
+
+// CloseEvents flushes and closes the destination of the progress events, stdout is left open.
+// It is a no-op if no destination was opened.
+func CloseEvents() error {
+	w := eventsWriter
+	eventsWriter = nil
+	if w == nil || w == os.Stdout {
+		return nil
+	}
+	// Syncing fails on pipes and character devices, which have nothing to flush anyway
+	if f, ok := w.(interface{ Sync() error }); ok {
+		_ = f.Sync()
+	}
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// openEventsWriter returns the writer for the given events destination, which is stdout
+// for '-', a unix socket for 'unix:PATH' or a file otherwise
+func openEventsWriter(vfs v1.FS, dest string) (io.Writer, error) {
+	switch {
+	case dest == "-":
+		return os.Stdout, nil
+	case strings.HasPrefix(dest, "unix:"):
+		return net.Dial("unix", strings.TrimPrefix(dest, "unix:"))
+	default:
+		return vfs.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, constants.FilePerm)
+	}
+}
+
 func configLogger(log v1.Logger, vfs v1.FS) {
 	// Set debug level
 	if viper.GetBool("debug") {
@@ -308,12 +436,23 @@ func configLogger(log v1.Logger, vfs v1.FS) {
 	}
 
 	// Set formatter so both file and stdout format are equal
-	log.SetFormatter(&logrus.TextFormatter{
-		ForceColors:      true,
-		DisableColors:    false,
-		DisableTimestamp: false,
-		FullTimestamp:    true,
-	})
+	if viper.GetString("log-format") == constants.LogFormatJSON {
+		log.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		log.SetFormatter(&logrus.TextFormatter{
+			ForceColors:      true,
+			DisableColors:    false,
+			DisableTimestamp: false,
+			FullTimestamp:    true,
+		})
+	}
+
+	// Logs go to stderr if stdout is taken by the progress events
+	events := viper.GetString("events")
+	var stdout io.Writer = os.Stdout
+	if events == "-" {
+		stdout = os.Stderr
+	}
 
 	// Logfile
 	logfile := viper.GetString("logfile")
@@ -327,14 +466,26 @@ func configLogger(log v1.Logger, vfs v1.FS) {
 		if viper.GetBool("quiet") { // if quiet is set, only set the log to the file
 			log.SetOutput(o)
 		} else { // else set it to both stdout and the file
-			mw := io.MultiWriter(os.Stdout, o)
+			mw := io.MultiWriter(stdout, o)
 			log.SetOutput(mw)
 		}
 	} else { // no logfile
 		if viper.GetBool("quiet") { // quiet is enabled so discard all logging
 			log.SetOutput(io.Discard)
 		} else { // default to stdout
-			log.SetOutput(os.Stdout)
+			log.SetOutput(stdout)
+		}
+	}
+
+	// Progress events
+	if events != "" {
+		_ = CloseEvents()
+		w, err := openEventsWriter(vfs, events)
+		if err != nil {
+			log.Errorf("Could not open %s for progress events: %s", events, err.Error())
+		} else {
+			eventsWriter = w
+			log.SetEventEmitter(v1.NewJSONEventEmitter(w))
 		}
 	}
 
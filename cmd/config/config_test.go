@@ -18,10 +18,12 @@ package config_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/sanity-io/litter"
 
@@ -54,7 +56,7 @@ var _ = Describe("Config", Label("config"), func() {
 	Context("From fixtures", func() {
 		Describe("read all specs", Label("install"), func() {
 			It("reads values correctly", func() {
-				cfg, err := ReadConfigRun("../../tests/fixtures/simple/", nil, mounter)
+				cfg, err := ReadConfigRun(context.Background(), "../../tests/fixtures/simple/", nil, mounter)
 				Expect(err).ShouldNot(HaveOccurred())
 
 				Expect(cfg.Config.Cosign).To(BeTrue(), litter.Sdump(cfg))
@@ -82,7 +84,7 @@ var _ = Describe("Config", Label("config"), func() {
 			flags.Set("arch", "arm64")
 		})
 		It("values filled if config path valid", Label("path", "values"), func() {
-			cfg, err := ReadConfigBuild("../../tests/fixtures/config/", flags, mounter)
+			cfg, err := ReadConfigBuild(context.Background(), "../../tests/fixtures/config/", flags, mounter)
 			Expect(err).To(BeNil())
 			Expect(viper.GetString("name")).To(Equal("cOS-0"))
 			Expect(cfg.Name).To(Equal("cOS-0"))
@@ -100,14 +102,14 @@ var _ = Describe("Config", Label("config"), func() {
 			flags.Set("platform", "linux/arm64")
 		})
 		It("values empty if config path not valid", Label("path", "values"), func() {
-			cfg, err := ReadConfigBuild("/none/", flags, mounter)
+			cfg, err := ReadConfigBuild(context.Background(), "/none/", flags, mounter)
 			Expect(err).To(BeNil())
 			Expect(viper.GetString("name")).To(Equal(""))
 			Expect(cfg.Name).To(Equal("elemental"))
 			Expect(cfg.Platform.String()).To(Equal("linux/arm64"))
 		})
 		It("values filled if config path valid", Label("path", "values"), func() {
-			cfg, err := ReadConfigBuild("../../tests/fixtures/config/", flags, mounter)
+			cfg, err := ReadConfigBuild(context.Background(), "../../tests/fixtures/config/", flags, mounter)
 			Expect(err).To(BeNil())
 			Expect(viper.GetString("name")).To(Equal("cOS-0"))
 			Expect(cfg.Name).To(Equal("cOS-0"))
@@ -118,12 +120,34 @@ var _ = Describe("Config", Label("config"), func() {
 
 		It("overrides values with env values", Label("env", "values"), func() {
 			_ = os.Setenv("ELEMENTAL_BUILD_NAME", "randomname")
-			cfg, err := ReadConfigBuild("../../tests/fixtures/config/", flags, mounter)
+			cfg, err := ReadConfigBuild(context.Background(), "../../tests/fixtures/config/", flags, mounter)
 			Expect(err).To(BeNil())
 			Expect(cfg.Name).To(Equal("randomname"))
 		})
 		It("fails on bad yaml manifest file", func() {
-			_, err := ReadConfigBuild("../../tests/fixtures/badconfig/", nil, mounter)
+			_, err := ReadConfigBuild(context.Background(), "../../tests/fixtures/badconfig/", nil, mounter)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("sets the build timestamp from SOURCE_DATE_EPOCH", Label("env", "reproducible"), func() {
+			_ = os.Setenv("SOURCE_DATE_EPOCH", "1672531200")
+			defer os.Unsetenv("SOURCE_DATE_EPOCH")
+			flags.Bool("reproducible", false, "testing flag")
+			flags.Set("reproducible", "true")
+			cfg, err := ReadConfigBuild(context.Background(), "/none/", flags, mounter)
+			Expect(err).To(BeNil())
+			Expect(cfg.Reproducible).To(BeTrue())
+			Expect(cfg.Now().Unix()).To(Equal(int64(1672531200)))
+		})
+		It("fails on reproducible builds without SOURCE_DATE_EPOCH", Label("reproducible"), func() {
+			flags.Bool("reproducible", false, "testing flag")
+			flags.Set("reproducible", "true")
+			_, err := ReadConfigBuild(context.Background(), "/none/", flags, mounter)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("fails on invalid SOURCE_DATE_EPOCH values", Label("env", "reproducible"), func() {
+			_ = os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+			defer os.Unsetenv("SOURCE_DATE_EPOCH")
+			_, err := ReadConfigBuild(context.Background(), "/none/", flags, mounter)
 			Expect(err).Should(HaveOccurred())
 		})
 	})
@@ -153,7 +177,7 @@ var _ = Describe("Config", Label("config"), func() {
 			fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
 			Expect(err).Should(BeNil())
 
-			cfg, err = ReadConfigBuild("../../tests/fixtures/config/", nil, mounter)
+			cfg, err = ReadConfigBuild(context.Background(), "../../tests/fixtures/config/", nil, mounter)
 			Expect(err).Should(BeNil())
 			// From defaults
 			Expect(cfg.Platform.String()).To(Equal("linux/amd64"))
@@ -177,6 +201,12 @@ var _ = Describe("Config", Label("config"), func() {
 				// From config file
 				Expect(iso.Image[0].Value()).To(Equal("recovery/cos-img:latest"))
 				Expect(iso.Label).To(Equal("LIVE_LABEL"))
+				Expect(iso.BootMenu.Default).To(Equal(1))
+				Expect(iso.BootMenu.Entries).To(HaveLen(2))
+				Expect(iso.BootMenu.Entries[1].Cmdline).To(Equal("console=ttyS0,115200 rd.debug"))
+
+				// From defaults
+				Expect(iso.BootMenu.Timeout).To(Equal(constants.ISOGrubTimeout))
 			})
 		})
 	})
@@ -190,14 +220,14 @@ var _ = Describe("Config", Label("config"), func() {
 			flags.Set("cosign-key", "someOtherKey")
 		})
 		It("fails on bad yaml config file", func() {
-			_, err := ReadConfigRun("../../tests/fixtures/badconfig/", nil, mounter)
+			_, err := ReadConfigRun(context.Background(), "../../tests/fixtures/badconfig/", nil, mounter)
 			Expect(err).Should(HaveOccurred())
 
-			_, err = ReadConfigRun("../../tests/fixtures/badextraconfig/", nil, mounter)
+			_, err = ReadConfigRun(context.Background(), "../../tests/fixtures/badextraconfig/", nil, mounter)
 			Expect(err).Should(HaveOccurred())
 		})
 		It("uses defaults if no configs are provided", func() {
-			cfg, err := ReadConfigRun("", nil, mounter)
+			cfg, err := ReadConfigRun(context.Background(), "", nil, mounter)
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(cfg.Platform.String()).To(Equal(fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)))
 			// Uses given mounter
@@ -208,7 +238,7 @@ var _ = Describe("Config", Label("config"), func() {
 			Expect(ok).To(BeTrue())
 		})
 		It("uses provided configs and flags, flags have priority", func() {
-			cfg, err := ReadConfigRun("../../tests/fixtures/config/", flags, mounter)
+			cfg, err := ReadConfigRun(context.Background(), "../../tests/fixtures/config/", flags, mounter)
 			Expect(err).To(BeNil())
 			Expect(cfg.Cosign).To(BeTrue())
 			// Flags overwrite the cosign-key set in config
@@ -216,9 +246,26 @@ var _ = Describe("Config", Label("config"), func() {
 			// Config.d overwrites the main config.yaml
 			Expect(cfg.CloudInitPaths).To(Equal(append(constants.GetCloudInitPaths(), "some/other/path")))
 		})
+		It("reads secure boot signing keys from flags", func() {
+			flags.String("secure-boot.key", "", "testing flag")
+			flags.String("secure-boot.cert", "", "testing flag")
+			flags.Bool("secure-boot.no-shim", false, "testing flag")
+			flags.Set("secure-boot.key", "/keys/db.key")
+			flags.Set("secure-boot.cert", "/keys/db.crt")
+			flags.Set("secure-boot.no-shim", "true")
+			cfg, err := ReadConfigRun(context.Background(), "", flags, mounter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(cfg.SecureBoot).To(Equal(v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt", NoShim: true}))
+		})
+		It("fails if the secure boot certificate is missing", func() {
+			flags.String("secure-boot.key", "", "testing flag")
+			flags.Set("secure-boot.key", "/keys/db.key")
+			_, err := ReadConfigRun(context.Background(), "", flags, mounter)
+			Expect(err).Should(HaveOccurred())
+		})
 		It("sets log level debug based on debug flag", func() {
 			// Default value
-			cfg, err := ReadConfigRun("../../tests/fixtures/config/", nil, mounter)
+			cfg, err := ReadConfigRun(context.Background(), "../../tests/fixtures/config/", nil, mounter)
 			Expect(err).To(BeNil())
 			debug := viper.GetBool("debug")
 			Expect(cfg.Logger.GetLevel()).ToNot(Equal(logrus.DebugLevel))
@@ -226,12 +273,32 @@ var _ = Describe("Config", Label("config"), func() {
 
 			// Set it via viper, like the flag
 			viper.Set("debug", true)
-			cfg, err = ReadConfigRun("../../tests/fixtures/config/", nil, mounter)
+			cfg, err = ReadConfigRun(context.Background(), "../../tests/fixtures/config/", nil, mounter)
 			Expect(err).To(BeNil())
 			debug = viper.GetBool("debug")
 			Expect(debug).To(BeTrue())
 			Expect(cfg.Logger.GetLevel()).To(Equal(logrus.DebugLevel))
 		})
+		It("merges the configured command timeouts over the defaults", func() {
+			cfg, err := ReadConfigRun(context.Background(), "../../tests/fixtures/config/", nil, mounter)
+			Expect(err).To(BeNil())
+			runner, ok := cfg.Runner.(*v1.RealRunner)
+			Expect(ok).To(BeTrue())
+			Expect(runner.Timeouts["xorriso"]).To(Equal(2 * time.Hour))
+			Expect(runner.Timeouts["udevadm"]).To(Equal(time.Duration(0)))
+			Expect(runner.Timeouts["mksquashfs"]).To(Equal(constants.GetCommandTimeouts()["mksquashfs"]))
+		})
+		It("loads custom bootloader profiles from the config dir", func() {
+			cfg, err := ReadConfigRun(context.Background(), "../../tests/fixtures/config/", nil, mounter)
+			Expect(err).To(BeNil())
+			Expect(len(cfg.BootloaderProfiles)).To(Equal(1))
+			profile := cfg.BootloaderProfiles[0]
+			Expect(profile.Name).To(Equal("custom"))
+			Expect(profile.Matches("customos")).To(BeTrue())
+			Expect(profile.Modules).To(ContainElement("zstd"))
+			Expect(profile.EFI["x86_64"].Grub.Candidates()).To(Equal([]string{"grubx64.efi.signed", "grubx64.efi"}))
+			Expect(profile.EFI["x86_64"].Shim.Candidates()).To(Equal([]string{"shimx64.efi"}))
+		})
 	})
 	Describe("Read runtime specs", Label("spec"), func() {
 		var cfg *v1.RunConfig
@@ -258,7 +325,7 @@ var _ = Describe("Config", Label("config"), func() {
 			fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
 			Expect(err).Should(BeNil())
 
-			cfg, err = ReadConfigRun("../../tests/fixtures/config/", nil, mounter)
+			cfg, err = ReadConfigRun(context.Background(), "../../tests/fixtures/config/", nil, mounter)
 			Expect(err).Should(BeNil())
 
 			cfg.Fs = fs
@@ -288,6 +355,19 @@ var _ = Describe("Config", Label("config"), func() {
 				Expect(spec.Firmware == v1.BIOS)
 				Expect(spec.NoFormat == false)
 			})
+			It("reads the extra kernel command line of each image from flags", func() {
+				flags.String("extra-cmdline.active", "", "testing flag")
+				flags.String("extra-cmdline.recovery", "", "testing flag")
+				flags.Set("extra-cmdline.active", "console=ttyS0 quiet")
+				flags.Set("extra-cmdline.recovery", "rd.break")
+				spec, err := ReadInstallSpec(cfg, flags)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(spec.ExtraCmdline).To(Equal(v1.ExtraCmdline{Active: "console=ttyS0 quiet", Recovery: "rd.break"}))
+				Expect(spec.ExtraCmdline.GetGrubVars()).To(Equal(map[string]string{
+					constants.GrubActiveCmdlineVar:   "console=ttyS0 quiet",
+					constants.GrubRecoveryCmdlineVar: "rd.break",
+				}))
+			})
 			It("inits an install spec according to given configs", func() {
 				err := os.Setenv("ELEMENTAL_INSTALL_TARGET", "/env/disk")
 				Expect(err).ShouldNot(HaveOccurred())
@@ -315,6 +395,43 @@ var _ = Describe("Config", Label("config"), func() {
 				Expect(spec.CloudInit[1]).To(Equal("/absolute/path/to/file2.yaml"))
 			})
 		})
+		Describe("Read install config from kernel command line", Label("install", "cmdline"), func() {
+			BeforeEach(func() {
+				err := vfs.MkdirAll(fs, "/proc", constants.DirPerm)
+				Expect(err).ShouldNot(HaveOccurred())
+				err = vfs.MkdirAll(fs, "/tmp", constants.DirPerm)
+				Expect(err).ShouldNot(HaveOccurred())
+				err = fs.WriteFile("/install.yaml", []byte("target: /cmdline/disk\nforce: true\n"), constants.FilePerm)
+				Expect(err).ShouldNot(HaveOccurred())
+				// Environment variables have priority over configuration files
+				Expect(os.Unsetenv("ELEMENTAL_INSTALL_TARGET")).To(Succeed())
+			})
+			It("merges the install configuration set in the kernel command line", func() {
+				err := fs.WriteFile("/proc/cmdline", []byte("quiet elemental.install.config=file:///install.yaml rd.neednet=1\n"), constants.FilePerm)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				Expect(ReadInstallConfigFromCmdline(cfg)).To(Succeed())
+				spec, err := ReadInstallSpec(cfg, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				// Overwrites target from config.yaml
+				Expect(spec.Target).To(Equal("/cmdline/disk"))
+				Expect(spec.Force).To(BeTrue())
+				// Keeps values from config.yaml not included in the command line config
+				Expect(spec.NoFormat).To(BeTrue())
+			})
+			It("fails if the kernel command line argument is not set", func() {
+				err := fs.WriteFile("/proc/cmdline", []byte("quiet rd.neednet=1\n"), constants.FilePerm)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ReadInstallConfigFromCmdline(cfg)).NotTo(Succeed())
+			})
+			It("fails if the install configuration can't be fetched", func() {
+				err := fs.WriteFile("/proc/cmdline", []byte("elemental.install.config=https://example.org/install.yaml\n"), constants.FilePerm)
+				Expect(err).ShouldNot(HaveOccurred())
+				client.Error = true
+				Expect(ReadInstallConfigFromCmdline(cfg)).NotTo(Succeed())
+				Expect(client.WasGetCalledWith("https://example.org/install.yaml")).To(BeTrue())
+			})
+		})
 		Describe("Read ResetSpec", Label("install"), func() {
 			var flags *pflag.FlagSet
 			var bootedFrom string
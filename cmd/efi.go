@@ -0,0 +1,222 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	eleefi "github.com/rancher/elemental-cli/pkg/efi"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+)
+
+// NewEFICmd returns a new instance of the efi subcommand and appends it to
+// the root command. efivars is the EFI variables store to operate on and
+// requireRoot is to initiate it with or without the CheckRoot pre-run check.
+// This method is mostly used for testing purposes.
+func NewEFICmd(root *cobra.Command, efivars eleefi.Variables, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "efi",
+		Short: "Manage the UEFI boot entries",
+		Long: "Manage the UEFI boot entries\n\n" +
+			"Boot entries are referenced by their hexadecimal boot number, e.g. '0003' or 'Boot0003'",
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the boot entries, the boot order and the next boot entry",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			bm, err := eleefi.NewBootManagerForVariables(efivars)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			asJSON, _ := cmd.Flags().GetBool("json")
+			if asJSON {
+				return printEFIJSON(&bm)
+			}
+			printEFI(&bm)
+			return nil
+		},
+	}
+	list.Flags().Bool("json", false, "Print the boot entries in JSON format")
+
+	add := &cobra.Command{
+		Use:   "add FILE",
+		Short: "Add a boot entry for the given EFI binary and prepend it to the boot order",
+		Long: "Add a boot entry for the given EFI binary and prepend it to the boot order\n\n" +
+			"FILE - path of the EFI binary within the mounted EFI partition, e.g. /boot/efi/EFI/elemental/shim.efi",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			label, _ := cmd.Flags().GetString("label")
+			options, _ := cmd.Flags().GetString("options")
+
+			bm, err := eleefi.NewBootManagerForVariables(efivars)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			num, err := bm.FindOrCreateEntry(eleefi.BootEntry{
+				Filename:    filepath.Base(args[0]),
+				Label:       label,
+				Options:     options,
+				Description: label,
+			}, filepath.Dir(args[0]))
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			err = bm.PrependAndSetBootOrder([]int{num})
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			fmt.Printf("Boot%04X\n", num)
+			return nil
+		},
+	}
+	add.Flags().String("label", "elemental", "Description of the boot entry")
+	add.Flags().String("options", "", "Optional data passed to the EFI binary")
+
+	remove := &cobra.Command{
+		Use:   "remove NUM",
+		Short: "Remove a boot entry and drop it from the boot order",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			num, err := parseBootNumber(args[0])
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			bm, err := eleefi.NewBootManagerForVariables(efivars)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			return elementalError.NewFromError(bm.DeleteEntry(num), elementalError.EFIBootEntries)
+		},
+	}
+
+	setOrder := &cobra.Command{
+		Use:   "set-order NUM[,NUM...]",
+		Short: "Set the boot order, entries not listed are dropped from it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var order []int
+			for _, arg := range strings.Split(args[0], ",") {
+				num, err := parseBootNumber(arg)
+				if err != nil {
+					return err
+				}
+				order = append(order, num)
+			}
+			cmd.SilenceUsage = true
+			bm, err := eleefi.NewBootManagerForVariables(efivars)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			return elementalError.NewFromError(bm.SetBootOrder(order), elementalError.EFIBootEntries)
+		},
+	}
+
+	setNext := &cobra.Command{
+		Use:   "set-next NUM",
+		Short: "Set the boot entry to use on next boot only",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			num, err := parseBootNumber(args[0])
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			bm, err := eleefi.NewBootManagerForVariables(efivars)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+			}
+			return elementalError.NewFromError(bm.SetBootNext(num), elementalError.EFIBootEntries)
+		},
+	}
+
+	root.AddCommand(c)
+	c.AddCommand(list, add, remove, setOrder, setNext)
+	return c
+}
+
+// parseBootNumber parses hexadecimal boot numbers with or without the 'Boot' prefix
+func parseBootNumber(arg string) (int, error) {
+	num, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(arg), "Boot"), 16, 16)
+	if err != nil {
+		return -1, fmt.Errorf("invalid boot entry number '%s'", arg)
+	}
+	return int(num), nil
+}
+
+// formatBootOrder renders boot numbers as a comma separated list
+func formatBootOrder(order []int) string {
+	var nums []string
+	for _, num := range order {
+		nums = append(nums, fmt.Sprintf("%04X", num))
+	}
+	return strings.Join(nums, ",")
+}
+
+func printEFI(bm *eleefi.BootManager) {
+	if next := bm.BootNext(); next >= 0 {
+		fmt.Printf("BootNext: %04X\n", next)
+	}
+	fmt.Printf("BootOrder: %s\n", formatBootOrder(bm.BootOrder()))
+	for _, entry := range bm.Entries() {
+		active := " "
+		if entry.Active {
+			active = "*"
+		}
+		fmt.Printf("%s%s %s\t%s\n", entry.Name, active, entry.Description, entry.DevicePath)
+	}
+}
+
+func printEFIJSON(bm *eleefi.BootManager) error {
+	out := struct {
+		BootNext  *int                   `json:"bootNext,omitempty"`
+		BootOrder []int                  `json:"bootOrder"`
+		Entries   []eleefi.BootEntryInfo `json:"entries"`
+	}{
+		BootOrder: bm.BootOrder(),
+		Entries:   bm.Entries(),
+	}
+	if next := bm.BootNext(); next >= 0 {
+		out.BootNext = &next
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.EFIBootEntries)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// register the subcommand into rootCmd
+var _ = NewEFICmd(rootCmd, eleefi.RealEFIVariables{}, true)
@@ -0,0 +1,130 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	efi "github.com/canonical/go-efilib"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/elemental-cli/pkg/constants"
+	eleefi "github.com/rancher/elemental-cli/pkg/efi"
+)
+
+var _ = Describe("efi", Label("efi", "cmd"), func() {
+	var efivars *eleefi.MockEFIVariables
+	var efiDir string
+	BeforeEach(func() {
+		efivars = &eleefi.MockEFIVariables{}
+		efiDir = GinkgoT().TempDir()
+		for _, f := range []string{"shim.efi", "grub.efi"} {
+			Expect(os.WriteFile(filepath.Join(efiDir, f), []byte(""), constants.FilePerm)).To(Succeed())
+		}
+		rootCmd = NewRootCmd()
+		_ = NewEFICmd(rootCmd, efivars, false)
+
+		// Boot0000 and Boot0001 entries
+		_, _, err := executeCommandC(rootCmd, "efi", "add", filepath.Join(efiDir, "grub.efi"), "--label", "grub")
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = executeCommandC(rootCmd, "efi", "add", filepath.Join(efiDir, "shim.efi"), "--label", "shim")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	bootOrder := func() []byte {
+		data, _, err := efivars.GetVariable(efi.GlobalVariable, "BootOrder")
+		Expect(err).ToNot(HaveOccurred())
+		return data
+	}
+	It("adds entries prepending them to the boot order", func() {
+		Expect(bootOrder()).To(Equal([]byte{1, 0, 0, 0}))
+
+		// Adding the same entry again does not duplicate it
+		_, out, err := executeCommandC(rootCmd, "efi", "add", filepath.Join(efiDir, "grub.efi"), "--label", "grub")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out).To(Equal("Boot0000\n"))
+		Expect(bootOrder()).To(Equal([]byte{0, 0, 1, 0}))
+	})
+	It("fails to add an entry for a missing file", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "add", filepath.Join(efiDir, "missing.efi"))
+		Expect(err).To(HaveOccurred())
+	})
+	It("lists entries with human readable device paths", func() {
+		_, out, err := executeCommandC(rootCmd, "efi", "list")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out).To(ContainSubstring("BootOrder: 0001,0000\n"))
+		Expect(out).To(MatchRegexp(`Boot0000\* grub\t.*\\grub\.efi`))
+		Expect(out).To(MatchRegexp(`Boot0001\* shim\t.*\\shim\.efi`))
+	})
+	It("lists entries in JSON format", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "set-next", "Boot0000")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, out, err := executeCommandC(rootCmd, "efi", "list", "--json")
+		Expect(err).ToNot(HaveOccurred())
+		var list struct {
+			BootNext  *int                   `json:"bootNext"`
+			BootOrder []int                  `json:"bootOrder"`
+			Entries   []eleefi.BootEntryInfo `json:"entries"`
+		}
+		Expect(json.Unmarshal([]byte(out), &list)).To(Succeed())
+		Expect(list.BootNext).ToNot(BeNil())
+		Expect(*list.BootNext).To(Equal(0))
+		Expect(list.BootOrder).To(Equal([]int{1, 0}))
+		Expect(list.Entries).To(HaveLen(2))
+		Expect(list.Entries[1].Name).To(Equal("Boot0001"))
+		Expect(list.Entries[1].Description).To(Equal("shim"))
+		Expect(list.Entries[1].DevicePath).To(ContainSubstring(`\shim.efi`))
+		Expect(list.Entries[1].Active).To(BeTrue())
+	})
+	It("removes entries and drops them from the boot order", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "remove", "1")
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = efivars.GetVariable(efi.GlobalVariable, "Boot0001")
+		Expect(err).To(HaveOccurred())
+		Expect(bootOrder()).To(Equal([]byte{0, 0}))
+
+		_, _, err = executeCommandC(rootCmd, "efi", "remove", "1")
+		Expect(err).To(HaveOccurred())
+	})
+	It("sets the boot order", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "set-order", "0000,0001")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bootOrder()).To(Equal([]byte{0, 0, 1, 0}))
+	})
+	It("fails to set a boot order with unknown or duplicated entries", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "set-order", "0000,0002")
+		Expect(err).To(HaveOccurred())
+		_, _, err = executeCommandC(rootCmd, "efi", "set-order", "0000,0000")
+		Expect(err).To(HaveOccurred())
+		_, _, err = executeCommandC(rootCmd, "efi", "set-order", "0000,wrong")
+		Expect(err).To(HaveOccurred())
+		Expect(bootOrder()).To(Equal([]byte{1, 0, 0, 0}))
+	})
+	It("sets the next boot entry", func() {
+		_, _, err := executeCommandC(rootCmd, "efi", "set-next", "0001")
+		Expect(err).ToNot(HaveOccurred())
+		data, _, err := efivars.GetVariable(efi.GlobalVariable, "BootNext")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte{1, 0}))
+
+		_, _, err = executeCommandC(rootCmd, "efi", "set-next", "0005")
+		Expect(err).To(HaveOccurred())
+	})
+})
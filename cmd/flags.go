@@ -44,6 +44,8 @@ func addPowerFlags(cmd *cobra.Command) {
 func addSharedInstallUpgradeFlags(cmd *cobra.Command) {
 	addResetFlags(cmd)
 	cmd.Flags().String("recovery-system.uri", "", "Sets the recovery image source and its type (e.g. 'docker:registry.org/image:tag')")
+	cmd.Flags().Bool("uki", false, "Create unified kernel images of the system images in the EFI partition")
+	cmd.Flags().String("uki-cmdline", "", "Extra kernel command line arguments embedded in the unified kernel images")
 	addSquashFsCompressionFlags(cmd)
 }
 
@@ -60,9 +62,25 @@ func addResetFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool("strict", false, "Enable strict check of hooks (They need to exit with 0)")
 
 	addCosignFlags(cmd)
+	addSecureBootFlags(cmd)
+	addExtraCmdlineFlags(cmd)
 	addPowerFlags(cmd)
 }
 
+// addExtraCmdlineFlags adds flags setting the extra kernel command line of each image
+func addExtraCmdlineFlags(cmd *cobra.Command) {
+	cmd.Flags().String("extra-cmdline.active", "", "Extra kernel command line arguments of the active image")
+	cmd.Flags().String("extra-cmdline.passive", "", "Extra kernel command line arguments of the passive image")
+	cmd.Flags().String("extra-cmdline.recovery", "", "Extra kernel command line arguments of the recovery image")
+}
+
+// addSecureBootFlags adds flags related to Secure Boot signing
+func addSecureBootFlags(cmd *cobra.Command) {
+	cmd.Flags().String("secure-boot.key", "", "Secure Boot db private key to sign bootloaders, kernels and unified kernel images")
+	cmd.Flags().String("secure-boot.cert", "", "Secure Boot db certificate matching the signing key")
+	cmd.Flags().Bool("secure-boot.no-shim", false, "Boot the signed bootloader directly, without shim and MokManager")
+}
+
 // addLocalImageFlag add local image flag shared between install, pull-image, upgrade
 func addLocalImageFlag(cmd *cobra.Command) {
 	cmd.Flags().Bool("local", false, "Use an image from local cache")
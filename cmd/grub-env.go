@@ -0,0 +1,124 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/go-vfs"
+
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+	"github.com/rancher/elemental-cli/pkg/utils"
+)
+
+func NewGrubEnvCmd(root *cobra.Command) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "grub-env",
+		Short: "Manage grub environment block files",
+		Args:  cobra.NoArgs,
+	}
+
+	list := &cobra.Command{
+		Use:   "list FILE",
+		Short: "List the variables of the grub environment block",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			env, err := utils.ReadGrubEnv(vfs.OSFS, args[0])
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.ReadFile)
+			}
+			for _, key := range env.Keys() {
+				value, _ := env.Get(key)
+				fmt.Printf("%s=%s\n", key, value)
+			}
+			return nil
+		},
+	}
+
+	get := &cobra.Command{
+		Use:   "get FILE KEY",
+		Short: "Print the value of a variable of the grub environment block",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			env, err := utils.ReadGrubEnv(vfs.OSFS, args[0])
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.ReadFile)
+			}
+			value, ok := env.Get(args[1])
+			if !ok {
+				return fmt.Errorf("variable '%s' is not set in %s", args[1], args[0])
+			}
+			fmt.Println(value)
+			return nil
+		},
+	}
+
+	set := &cobra.Command{
+		Use:   "set FILE KEY=VALUE [KEY=VALUE...]",
+		Short: "Set variables in the grub environment block, the file is created if missing",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, arg := range args[1:] {
+				if key, _, found := strings.Cut(arg, "="); !found || key == "" {
+					return fmt.Errorf("invalid variable '%s', expected KEY=VALUE", arg)
+				}
+			}
+			cmd.SilenceUsage = true
+			return updateGrubEnv(args[0], func(env *utils.GrubEnv) {
+				for _, arg := range args[1:] {
+					key, value, _ := strings.Cut(arg, "=")
+					env.Set(key, value)
+				}
+			})
+		},
+	}
+
+	unset := &cobra.Command{
+		Use:   "unset FILE KEY [KEY...]",
+		Short: "Remove variables from the grub environment block",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return updateGrubEnv(args[0], func(env *utils.GrubEnv) {
+				for _, key := range args[1:] {
+					env.Unset(key)
+				}
+			})
+		},
+	}
+
+	root.AddCommand(c)
+	c.AddCommand(list, get, set, unset)
+	return c
+}
+
+// updateGrubEnv reads the given grub environment block, applies the update and writes it back
+func updateGrubEnv(file string, update func(env *utils.GrubEnv)) error {
+	env, err := utils.ReadGrubEnv(vfs.OSFS, file)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.ReadFile)
+	}
+	update(env)
+	return elementalError.NewFromError(utils.WriteGrubEnv(vfs.OSFS, file, env), elementalError.SetGrubVariables)
+}
+
+// register the subcommand into rootCmd
+var _ = NewGrubEnvCmd(rootCmd)
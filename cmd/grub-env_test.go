@@ -0,0 +1,62 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("grub-env", Label("grub-env", "cmd"), func() {
+	var grubEnv string
+	BeforeEach(func() {
+		grubEnv = filepath.Join(GinkgoT().TempDir(), "grubenv")
+		rootCmd = NewRootCmd()
+		_ = NewGrubEnvCmd(rootCmd)
+	})
+	It("sets, gets, lists and unsets variables", func() {
+		_, _, err := executeCommandC(rootCmd, "grub-env", "set", grubEnv, "saved_entry=recovery", "next_entry=active")
+		Expect(err).ToNot(HaveOccurred())
+		info, err := os.Stat(grubEnv)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Size()).To(Equal(int64(1024)))
+
+		_, out, err := executeCommandC(rootCmd, "grub-env", "get", grubEnv, "saved_entry")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out).To(Equal("recovery\n"))
+
+		_, _, err = executeCommandC(rootCmd, "grub-env", "unset", grubEnv, "saved_entry")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, out, err = executeCommandC(rootCmd, "grub-env", "list", grubEnv)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out).To(Equal("next_entry=active\n"))
+	})
+	It("fails to get unset variables", func() {
+		_, _, err := executeCommandC(rootCmd, "grub-env", "get", grubEnv, "saved_entry")
+		Expect(err).To(HaveOccurred())
+	})
+	It("fails to set variables without a value", Label("args"), func() {
+		_, _, err := executeCommandC(rootCmd, "grub-env", "set", grubEnv, "saved_entry")
+		Expect(err).To(HaveOccurred())
+		_, err = os.Stat(grubEnv)
+		Expect(err).To(HaveOccurred())
+	})
+})
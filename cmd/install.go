@@ -27,6 +27,7 @@ import (
 	"github.com/rancher/elemental-cli/pkg/action"
 	elementalError "github.com/rancher/elemental-cli/pkg/error"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+	"github.com/rancher/elemental-cli/pkg/utils"
 )
 
 // NewInstallCmd returns a new instance of the install subcommand and appends it to
@@ -50,7 +51,7 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 			}
 			mounter := mount.New(path)
 
-			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			cfg, err := config.ReadConfigRun(cmd.Context(), viper.GetString("config-dir"), cmd.Flags(), mounter)
 			if err != nil {
 				cfg.Logger.Errorf("Error reading config: %s\n", err)
 				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
@@ -69,6 +70,15 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 			adaptEFIAndGPTFlags(cmd.Flags())
 
 			cmd.SilenceUsage = true
+			fromCmdline, _ := cmd.Flags().GetBool("from-cmdline")
+			if fromCmdline {
+				err = config.ReadInstallConfigFromCmdline(cfg)
+				if err != nil {
+					cfg.Logger.Errorf("Error reading install config from kernel command line: %s\n", err)
+					return elementalError.NewFromError(err, elementalError.FetchCmdlineInstallConfig)
+				}
+			}
+
 			spec, err := config.ReadInstallSpec(cfg, cmd.Flags())
 			if err != nil {
 				cfg.Logger.Errorf("invalid install command setup %v", err)
@@ -79,6 +89,15 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 				spec.Target = args[0]
 			}
 
+			if spec.Target == "" && spec.TargetSelector != nil {
+				spec.Target, err = utils.SelectDisk(cfg.Fs, cfg.Runner, spec.TargetSelector)
+				if err != nil {
+					cfg.Logger.Errorf("Error selecting target device: %s\n", err)
+					return elementalError.NewFromError(err, elementalError.InvalidTarget)
+				}
+				cfg.Logger.Infof("Selected target device %s", spec.Target)
+			}
+
 			if spec.Target == "" {
 				return elementalError.New("at least a target device must be supplied", elementalError.InvalidTarget)
 			}
@@ -111,6 +130,10 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 	c.Flags().Bool("force", false, "Force install")
 	c.Flags().Bool("eject-cd", false, "Try to eject the cd on reboot, only valid if booting from iso")
 	c.Flags().Bool("disable-boot-entry", false, "Dont create an EFI entry for the system install.")
+	c.Flags().Bool("grub-host-tools", false, "Install BIOS grub using the host grub tools instead of the ones included in the system image")
+	c.Flags().Bool("target-image", false, "Install into a regular image file attached to a loop device instead of a block device")
+	c.Flags().Uint("target-image-size", 0, "Size in MiB to create the target image file as a sparse file if it does not exist, implies 'target-image'")
+	c.Flags().Bool("from-cmdline", false, "Read the install configuration from the URL set in the 'elemental.install.config' kernel command line argument")
 	addSharedInstallUpgradeFlags(c)
 	addLocalImageFlag(c)
 	addPlatformFlags(c)
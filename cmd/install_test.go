@@ -64,6 +64,12 @@ var _ = Describe("Install", Label("install", "cmd"), func() {
 		Expect(err.(*elementalError.ElementalError)).ToNot(BeNil())
 		Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.InvalidTarget))
 	})
+	It("Errors out if the install config can't be read from the kernel command line", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "install", "--from-cmdline")
+		Expect(err).ToNot(BeNil())
+		Expect(err.(*elementalError.ElementalError)).ToNot(BeNil())
+		Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.FetchCmdlineInstallConfig))
+	})
 	It("Errors out setting reboot and poweroff at the same time", Label("flags"), func() {
 		_, _, err := executeCommandC(rootCmd, "install", "--reboot", "--poweroff", "/dev/whatever")
 		Expect(err).ToNot(BeNil())
@@ -40,7 +40,7 @@ func NewPullImageCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), &mount.FakeMounter{})
+			cfg, err := config.ReadConfigRun(cmd.Context(), viper.GetString("config-dir"), cmd.Flags(), &mount.FakeMounter{})
 			if err != nil {
 				cfg.Logger.Errorf("Error reading config: %s\n", err)
 				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
@@ -65,7 +65,7 @@ func NewPullImageCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 
 			cfg.Logger.Infof("Pulling image %s platform %s", image, cfg.Platform.String())
 
-			e := v1.OCIImageExtractor{}
+			e := v1.OCIImageExtractor{Logger: cfg.Logger}
 			if err = e.ExtractImage(image, destination, cfg.Platform.String(), local); err != nil {
 				cfg.Logger.Error(err.Error())
 				return elementalError.NewFromError(err, elementalError.UnpackImage)
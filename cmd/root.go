@@ -17,8 +17,15 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/rancher/elemental-cli/cmd/config"
+	"github.com/rancher/elemental-cli/pkg/constants"
 	eleError "github.com/rancher/elemental-cli/pkg/error"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -33,10 +40,16 @@ func NewRootCmd() *cobra.Command {
 	cmd.PersistentFlags().String("config-dir", "", "Set config dir")
 	cmd.PersistentFlags().String("logfile", "", "Set logfile")
 	cmd.PersistentFlags().Bool("quiet", false, "Do not output to stdout")
+	cmd.PersistentFlags().Var(newEnumFlag([]string{constants.LogFormatText, constants.LogFormatJSON}, constants.LogFormatText), "log-format", "Log format: 'text' or 'json'")
+	cmd.PersistentFlags().String("error-report", "", "Write a JSON report of the failure to the given file on errors")
+	cmd.PersistentFlags().String("events", "", "Write progress events as JSON lines to the given file, to a unix socket with 'unix:PATH' or to stdout with '-', logs are then written to stderr")
 	_ = viper.BindPFlag("debug", cmd.PersistentFlags().Lookup("debug"))
 	_ = viper.BindPFlag("config-dir", cmd.PersistentFlags().Lookup("config-dir"))
 	_ = viper.BindPFlag("logfile", cmd.PersistentFlags().Lookup("logfile"))
 	_ = viper.BindPFlag("quiet", cmd.PersistentFlags().Lookup("quiet"))
+	_ = viper.BindPFlag("log-format", cmd.PersistentFlags().Lookup("log-format"))
+	_ = viper.BindPFlag("events", cmd.PersistentFlags().Lookup("events"))
+	_ = viper.BindPFlag("error-report", cmd.PersistentFlags().Lookup("error-report"))
 	return cmd
 }
 
@@ -45,14 +58,35 @@ var rootCmd = NewRootCmd()
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the running action, it fails after unwinding its cleanup stack.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if cErr := config.CloseEvents(); cErr != nil {
+		fmt.Fprintf(os.Stderr, "Could not close progress events destination: %s\n", cErr)
+	}
 	if err != nil {
-		switch t := err.(type) {
-		case *eleError.ElementalError:
-			os.Exit(t.ExitCode())
-		default:
-			os.Exit(1)
+		if report := viper.GetString("error-report"); report != "" {
+			writeErrorReport(report, err)
+		}
+
+		// Errors returned by actions might be combined with errors of the cleanup stack
+		var eErr *eleError.ElementalError
+		if errors.As(err, &eErr) {
+			os.Exit(eErr.ExitCode())
 		}
+		os.Exit(1)
+	}
+}
+
+// writeErrorReport writes the JSON report of the given error to the given path
+func writeErrorReport(path string, err error) {
+	data, rErr := eleError.NewReport(err).JSON()
+	if rErr == nil {
+		rErr = os.WriteFile(path, data, constants.FilePerm)
+	}
+	if rErr != nil {
+		fmt.Fprintf(os.Stderr, "Could not write error report to %s: %s\n", path, rErr)
 	}
 }
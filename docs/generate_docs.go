@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/format"
 	godoc "go/doc"
 	"go/parser"
 	"go/token"
@@ -31,6 +32,7 @@ import (
 	"github.com/spf13/cobra/doc"
 
 	"github.com/rancher/elemental-cli/cmd"
+	eleefi "github.com/rancher/elemental-cli/pkg/efi"
 )
 
 func main() {
@@ -39,6 +41,9 @@ func main() {
 		rootCmd,
 		cmd.NewBuildISO(rootCmd, false),
 		cmd.NewCloudInitCmd(rootCmd),
+		cmd.NewCmdlineCmd(rootCmd, false),
+		cmd.NewEFICmd(rootCmd, eleefi.RealEFIVariables{}, false),
+		cmd.NewGrubEnvCmd(rootCmd),
 		cmd.NewInstallCmd(rootCmd, false),
 		cmd.NewPullImageCmd(rootCmd, false),
 		cmd.NewResetCmd(rootCmd, false),
@@ -88,7 +93,7 @@ func generateExitCodes() error {
 		}
 
 		used[code] = true
-		exitCodes = append(exitCodes, &ErrorCode{code: code, doc: c.Doc})
+		exitCodes = append(exitCodes, &ErrorCode{code: code, name: c.Names[0], doc: c.Doc})
 	}
 
 	sort.Slice(exitCodes[:], func(i, j int) bool {
@@ -116,7 +121,27 @@ func generateExitCodes() error {
 		}
 	}
 
-	return nil
+	return generateExitCodeNames(exitCodes)
+}
+
+// generateExitCodeNames writes the go source mapping exit codes to their symbolic names
+func generateExitCodeNames(exitCodes []*ErrorCode) error {
+	var src strings.Builder
+
+	src.WriteString("// Code generated by docs/generate_docs.go; DO NOT EDIT.\n\n")
+	src.WriteString("package error\n\n")
+	src.WriteString("// codeNames maps exit codes to the name of their constant\n")
+	src.WriteString("var codeNames = map[int]string{\n")
+	for _, code := range exitCodes {
+		src.WriteString(fmt.Sprintf("\t%d: %q,\n", code.code, code.name))
+	}
+	src.WriteString("}\n")
+
+	formatted, err := format.Source([]byte(src.String()))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("../pkg/error/exit-code-names.go", formatted, 0644)
 }
 
 func mustParse(fset *token.FileSet, filename string) *ast.File {
@@ -129,5 +154,6 @@ func mustParse(fset *token.FileSet, filename string) *ast.File {
 
 type ErrorCode struct {
 	code int
+	name string
 	doc  string
 }
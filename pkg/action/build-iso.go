@@ -51,10 +51,15 @@ func WithLiveBoot(l LiveBootloader) BuildISOActionOption {
 
 func NewBuildISOAction(cfg *v1.BuildConfig, spec *v1.LiveISO, opts ...BuildISOActionOption) *BuildISOAction {
 	b := &BuildISOAction{
-		cfg:      cfg,
-		e:        elemental.NewElemental(&cfg.Config),
-		spec:     spec,
-		liveBoot: live.NewGreenLiveBootLoader(cfg, spec),
+		cfg:  cfg,
+		e:    elemental.NewElemental(&cfg.Config),
+		spec: spec,
+	}
+	switch spec.Bootloader {
+	case constants.LiveBootloaderSystemdBoot:
+		b.liveBoot = live.NewSystemdBootLiveBootLoader(cfg, spec)
+	default:
+		b.liveBoot = live.NewGreenLiveBootLoader(cfg, spec)
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -63,11 +68,23 @@ func NewBuildISOAction(cfg *v1.BuildConfig, spec *v1.LiveISO, opts ...BuildISOAc
 }
 
 // BuildISORun will install the system from a given configuration
-func (b *BuildISOAction) ISORun() error {
+func (b *BuildISOAction) ISORun() (err error) {
+	events := newActionEvents(&b.cfg.Config, "build-iso")
+	defer func() { err = events.finish(err) }()
+
 	cleanup := utils.NewCleanStack()
-	var err error
-	defer func() { err = cleanup.Cleanup(err) }()
+	defer func() { err = unwind(&b.cfg.Config, cleanup, err) }()
 
+	if b.cfg.Reproducible {
+		events.start(elementalError.NonReproducibleBuild)
+		err = b.checkReproducible()
+		if err != nil {
+			b.cfg.Logger.Errorf("Build is not reproducible: %v", err)
+			return elementalError.NewFromError(err, elementalError.NonReproducibleBuild)
+		}
+	}
+
+	events.start(elementalError.CreateTempDir)
 	isoTmpDir, err := utils.TempDir(b.cfg.Fs, "", "elemental-iso")
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CreateTempDir)
@@ -103,6 +120,7 @@ func (b *BuildISOAction) ISORun() error {
 		}
 	}
 
+	events.start(elementalError.DumpSource)
 	b.cfg.Logger.Infof("Preparing squashfs root (%v source)...", len(b.spec.RootFS))
 	err = b.applySources(rootDir, b.spec.RootFS...)
 	if err != nil {
@@ -115,7 +133,15 @@ func (b *BuildISOAction) ISORun() error {
 		return elementalError.NewFromError(err, elementalError.CreateDir)
 	}
 
+	events.start(elementalError.SignEFI)
+	err = b.e.SignKernel(rootDir)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed signing kernel: %v", err)
+		return elementalError.NewFromError(err, elementalError.SignEFI)
+	}
+
 	if b.spec.Firmware == v1.EFI {
+		events.start(elementalError.CopyData)
 		b.cfg.Logger.Infof("Preparing EFI image...")
 		if b.spec.BootloaderInRootFs {
 			err = b.liveBoot.PrepareEFI(rootDir, uefiDir)
@@ -129,8 +155,20 @@ func (b *BuildISOAction) ISORun() error {
 			b.cfg.Logger.Errorf("Failed installing EFI packages: %v", err)
 			return err
 		}
+		if b.spec.UKI {
+			events.start(elementalError.CreateUKI)
+			err = b.e.CreateUKI(
+				rootDir, live.DefaultCmdline(b.spec),
+				filepath.Join(uefiDir, constants.UKIDir, b.cfg.Name+constants.UKIExt),
+			)
+			if err != nil {
+				b.cfg.Logger.Errorf("Failed creating unified kernel image: %v", err)
+				return elementalError.NewFromError(err, elementalError.CreateUKI)
+			}
+		}
 	}
 
+	events.start(elementalError.CreateFile)
 	b.cfg.Logger.Infof("Preparing ISO image root tree...")
 	if b.spec.BootloaderInRootFs {
 		err = b.liveBoot.PrepareISO(rootDir, isoDir)
@@ -145,13 +183,33 @@ func (b *BuildISOAction) ISORun() error {
 		return err
 	}
 
+	err = b.embedConfigs(isoDir)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed embedding configuration files: %v", err)
+		return err
+	}
+
+	events.start(elementalError.MKFSCall)
+	err = b.clampMtimes(rootDir, uefiDir)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed setting files modification time: %v", err)
+		return elementalError.NewFromError(err, elementalError.CopyData)
+	}
+
 	err = b.prepareISORoot(isoDir, rootDir)
 	if err != nil {
 		b.cfg.Logger.Errorf("Failed preparing ISO's root tree: %v", err)
 		return err
 	}
 
+	err = b.clampMtimes(isoDir)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed setting files modification time: %v", err)
+		return elementalError.NewFromError(err, elementalError.CopyData)
+	}
+
 	if b.spec.Firmware == v1.EFI {
+		events.start(elementalError.CreateImgFromTree)
 		b.cfg.Logger.Info("Creating EFI image...")
 		err = b.createEFI(uefiDir, filepath.Join(isoTmpDir, constants.ISOEFIImg))
 		if err != nil {
@@ -159,6 +217,7 @@ func (b *BuildISOAction) ISORun() error {
 		}
 	}
 
+	events.start(elementalError.CommandRun)
 	b.cfg.Logger.Infof("Creating ISO image...")
 	err = b.burnISO(isoDir, filepath.Join(isoTmpDir, constants.ISOEFIImg))
 	if err != nil {
@@ -166,9 +225,54 @@ func (b *BuildISOAction) ISORun() error {
 		return err
 	}
 
+	if b.spec.Netboot {
+		events.start(elementalError.CopyFile)
+		b.cfg.Logger.Infof("Creating netboot artifacts...")
+		err = b.netboot(isoDir)
+		if err != nil {
+			b.cfg.Logger.Errorf("Failed creating netboot artifacts: %v", err)
+			return err
+		}
+	}
+
 	return err
 }
 
+// checkReproducible verifies the build does not depend on the build time or on
+// sources which could change between builds
+func (b BuildISOAction) checkReproducible() error {
+	if b.cfg.SourceDateEpoch == nil {
+		return fmt.Errorf("%s is not set", constants.SourceDateEpochEnv)
+	}
+	sources := append([]*v1.ImageSource{}, b.spec.RootFS...)
+	sources = append(sources, b.spec.UEFI...)
+	sources = append(sources, b.spec.Image...)
+	for _, src := range sources {
+		if !src.IsPinned() {
+			return fmt.Errorf("image source '%s' is not referenced by digest", src.String())
+		}
+	}
+	if !utils.SquashfsSupportsFixedTimes(b.cfg.Runner) {
+		return fmt.Errorf("mksquashfs does not support fixing the filesystem timestamps")
+	}
+	return nil
+}
+
+// clampMtimes sets the modification time of all files newer than SOURCE_DATE_EPOCH to it, if set
+func (b BuildISOAction) clampMtimes(dirs ...string) error {
+	if b.cfg.SourceDateEpoch == nil {
+		return nil
+	}
+	for _, dir := range dirs {
+		b.cfg.Logger.Debugf("Clamping modification times of %s to %s", dir, b.cfg.SourceDateEpoch.Format(time.RFC3339))
+		err := utils.ClampMtimes(b.cfg.Fs, dir, *b.cfg.SourceDateEpoch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b BuildISOAction) prepareISORoot(isoDir string, rootDir string) error {
 	kernel, initrd, err := b.e.FindKernelInitrd(rootDir)
 	if err != nil {
@@ -193,7 +297,10 @@ func (b BuildISOAction) prepareISORoot(isoDir string, rootDir string) error {
 	}
 
 	b.cfg.Logger.Info("Creating squashfs...")
-	squashOptions := append(constants.GetDefaultSquashfsOptions(), b.cfg.SquashFsCompressionConfig...)
+	squashOptions, err := utils.SquashfsOptions(&b.cfg.Config, b.spec.Compression)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.MKFSCall)
+	}
 	err = utils.CreateSquashFS(b.cfg.Runner, b.cfg.Logger, rootDir, filepath.Join(isoDir, constants.ISORootFile), squashOptions)
 	return elementalError.NewFromError(err, elementalError.MKFSCall)
 }
@@ -208,29 +315,12 @@ func (b BuildISOAction) createEFI(root string, img string) error {
 	align := int64(4 * 1024 * 1024)
 	efiSizeMB := (efiSize/align*align + align) / (1024 * 1024)
 
-	err = b.e.CreateFileSystemImage(&v1.Image{
+	return b.e.CreateImgFromTree(root, &v1.Image{
 		File:  img,
 		Size:  uint(efiSizeMB),
 		FS:    constants.EfiFs,
 		Label: constants.EfiLabel,
-	})
-	if err != nil {
-		return err
-	}
-
-	files, err := b.cfg.Fs.ReadDir(root)
-	if err != nil {
-		return err
-	}
-
-	for _, f := range files {
-		_, err = b.cfg.Runner.Run("mcopy", "-s", "-i", img, filepath.Join(root, f.Name()), "::")
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	}, nil)
 }
 
 func (b BuildISOAction) burnISO(root, efiImg string) error {
@@ -239,7 +329,7 @@ func (b BuildISOAction) burnISO(root, efiImg string) error {
 	var isoFileName string
 
 	if b.cfg.Date {
-		currTime := time.Now()
+		currTime := b.cfg.Now()
 		isoFileName = fmt.Sprintf("%s.%s.iso", b.cfg.Name, currTime.Format("20060102"))
 	} else {
 		isoFileName = fmt.Sprintf("%s.iso", b.cfg.Name)
@@ -262,6 +352,13 @@ func (b BuildISOAction) burnISO(root, efiImg string) error {
 		"-volid", b.spec.Label /*"-joliet", "on"*/, "-padding", "0",
 		"-outdev", outputFile, "-map", root, "/", "-chmod", "0755", "--",
 	}
+	if b.cfg.SourceDateEpoch != nil {
+		date := b.cfg.SourceDateEpoch.UTC().Format("2006010215040500")
+		args = append(args,
+			"-volume_date", "c", date, "-volume_date", "m", date,
+			"-volume_date", "uuid", date, "-volume_date", "all_file_dates", date,
+		)
+	}
 	args = append(args, live.XorrisoBooloaderArgs(root, efiImg, b.spec.Firmware)...)
 
 	out, err := b.cfg.Runner.Run(cmd, args...)
@@ -284,6 +381,78 @@ func (b BuildISOAction) burnISO(root, efiImg string) error {
 	return nil
 }
 
+// embedConfigs places the configured cloud-config and install configuration files in the ISO
+// root tree. The cloud-config is executed by the live system at boot.
+func (b BuildISOAction) embedConfigs(isoDir string) error {
+	configs := [][2]string{
+		{b.spec.CloudConfig, constants.ISOCloudConfigFile},
+		{b.spec.InstallConfig, constants.ISOInstallConfigFile},
+	}
+	for _, cfg := range configs {
+		if cfg[0] == "" {
+			continue
+		}
+		b.cfg.Logger.Infof("Embedding %s as %s", cfg[0], cfg[1])
+		err := utils.GetSource(&b.cfg.Config, cfg[0], filepath.Join(isoDir, cfg[1]))
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.DownloadFile)
+		}
+	}
+
+	if b.spec.AutoInstall {
+		if !b.spec.BootloaderInRootFs {
+			b.cfg.Logger.Warnf("The provided bootloader configuration must set '%s' to boot the automated installation", constants.InstallConfigCmdlineArg)
+		}
+		autoInstall := filepath.Join(isoDir, constants.ISOAutoInstallFile)
+		err := utils.MkdirAll(b.cfg.Fs, filepath.Dir(autoInstall), constants.DirPerm)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CreateDir)
+		}
+		err = b.cfg.Fs.WriteFile(autoInstall, []byte(live.AutoInstallCloudConfig()), constants.FilePerm)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CreateFile)
+		}
+	}
+	return nil
+}
+
+// netboot writes the kernel, initrd and squashfs of the ISO root tree next to the ISO
+// including an iPXE script and a grub configuration to boot them from the network
+func (b BuildISOAction) netboot(isoDir string) error {
+	artifacts := map[string]string{
+		constants.ISOKernelPath: b.cfg.Name + constants.NetbootKernelSuffix,
+		constants.ISOInitrdPath: b.cfg.Name + constants.NetbootInitrdSuffix,
+		constants.ISORootFile:   b.cfg.Name + constants.NetbootSquashfsSuffix,
+	}
+	for src, dst := range artifacts {
+		dst = filepath.Join(b.cfg.OutDir, dst)
+		b.cfg.Logger.Debugf("Copying %s to %s", src, dst)
+		err := utils.CopyFile(b.cfg.Fs, filepath.Join(isoDir, src), dst)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CopyFile)
+		}
+	}
+
+	grubCfg, err := live.GrubNetbootCfg(b.spec, b.cfg.Name)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	err = b.cfg.Fs.WriteFile(filepath.Join(b.cfg.OutDir, b.cfg.Name+constants.NetbootGrubSuffix), []byte(grubCfg), constants.FilePerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+
+	ipxe, err := live.IPXEScript(b.spec, b.cfg.Name)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	err = b.cfg.Fs.WriteFile(filepath.Join(b.cfg.OutDir, b.cfg.Name+constants.NetbootIPXESuffix), []byte(ipxe), constants.FilePerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	return nil
+}
+
 func (b BuildISOAction) applySources(target string, sources ...*v1.ImageSource) error {
 	for _, src := range sources {
 		_, err := b.e.DumpSource(target, src)
@@ -18,8 +18,11 @@ package action_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"path/filepath"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -30,6 +33,7 @@ import (
 	"github.com/rancher/elemental-cli/pkg/action"
 	"github.com/rancher/elemental-cli/pkg/config"
 	"github.com/rancher/elemental-cli/pkg/constants"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 	"github.com/rancher/elemental-cli/pkg/utils"
 	v1mock "github.com/rancher/elemental-cli/tests/mocks"
@@ -140,6 +144,39 @@ var _ = Describe("Runtime Actions", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("Emits progress events of the build", Label("events"), func() {
+			buf := &bytes.Buffer{}
+			logger.SetEventEmitter(v1.NewJSONEventEmitter(buf))
+			rootSrc, _ := v1.NewSrcFromURI("dir:/overlay/dir")
+			iso.RootFS = append(iso.RootFS, rootSrc)
+
+			Expect(utils.MkdirAll(fs, "/overlay/dir/boot", constants.DirPerm)).To(Succeed())
+			_, err := fs.Create("/overlay/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/overlay/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			liveBoot := &v1mock.LiveBootLoaderMock{}
+			buildISO := action.NewBuildISOAction(cfg, iso, action.WithLiveBoot(liveBoot))
+			Expect(buildISO.ISORun()).To(Succeed())
+
+			var events []v1.Event
+			steps := map[string]bool{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event v1.Event
+				Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+				events = append(events, event)
+				if event.Type == v1.StepStartedEvent {
+					Expect(steps).NotTo(HaveKey(event.Step))
+					steps[event.Step] = true
+				}
+			}
+			Expect(events[0].Type).To(Equal(v1.ActionStartedEvent))
+			Expect(events[0].Action).To(Equal("build-iso"))
+			Expect(events[len(events)-1].Type).To(Equal(v1.ActionFinishedEvent))
+			Expect(events[len(events)-1].Status).To(Equal(v1.EventStatusSuccess))
+			Expect(steps).To(HaveKey(elementalError.Name(elementalError.MKFSCall)))
+		})
 		It("Fails on prepare EFI", func() {
 			iso.BootloaderInRootFs = true
 
@@ -211,6 +248,235 @@ var _ = Describe("Runtime Actions", func() {
 			err := buildISO.ISORun()
 			Expect(err).Should(HaveOccurred())
 		})
+		It("Successfully builds a reproducible ISO", func() {
+			epoch := time.Unix(1672531200, 0).UTC()
+			cfg.SourceDateEpoch = &epoch
+			cfg.Reproducible = true
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var rootMtime time.Time
+			xorriso := runner.SideEffect
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				switch cmd {
+				case "mksquashfs":
+					if args[0] == "-help" {
+						return []byte("-mkfs-time <time>\n-all-time <time>\n"), nil
+					}
+					info, err := fs.Stat(filepath.Join(args[0], "boot", "vmlinuz"))
+					if err != nil {
+						return []byte{}, err
+					}
+					rootMtime = info.ModTime()
+					return []byte{}, nil
+				default:
+					return xorriso(cmd, args...)
+				}
+			}
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rootMtime.Equal(epoch)).To(BeTrue())
+
+			var squash, burn []string
+			for _, cmd := range runner.GetCmds() {
+				switch {
+				case cmd[0] == "mksquashfs" && cmd[1] != "-help":
+					squash = cmd
+				case cmd[0] == "xorriso":
+					burn = cmd
+				}
+			}
+			Expect(squash).To(ContainElements("-mkfs-time", "1672531200", "-all-time"))
+			Expect(burn).To(ContainElements("-volume_date", "uuid", "all_file_dates", "2023010100000000"))
+		})
+		It("Successfully builds an ISO including netboot artifacts", func() {
+			iso.Netboot = true
+			iso.NetbootURL = "http://boot.example.com/elemental/"
+			iso.BootMenu = v1.LiveBootMenu{
+				Timeout: 5,
+				Default: 1,
+				Entries: []v1.LiveMenuEntry{
+					{Title: "Elemental"},
+					{Title: "Elemental (debug)", Cmdline: "rd.debug"},
+				},
+			}
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			xorriso := runner.SideEffect
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "mksquashfs" {
+					return []byte{}, fs.WriteFile(args[1], []byte("squashfs"), constants.FilePerm)
+				}
+				return xorriso(cmd, args...)
+			}
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for _, f := range []string{"elemental-kernel", "elemental-initrd", "elemental.squashfs"} {
+				Expect(utils.Exists(fs, filepath.Join(cfg.OutDir, f))).To(BeTrue())
+			}
+
+			ipxe, err := fs.ReadFile(filepath.Join(cfg.OutDir, "elemental.ipxe"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(ipxe)).To(ContainSubstring("set base-url http://boot.example.com/elemental\n"))
+			Expect(string(ipxe)).To(ContainSubstring("root=live:http://boot.example.com/elemental/elemental.squashfs"))
+			Expect(string(ipxe)).To(ContainSubstring("initrd ${base-url}/elemental-initrd"))
+			Expect(string(ipxe)).To(ContainSubstring("item entry1 Elemental (debug)"))
+			Expect(string(ipxe)).To(ContainSubstring("choose --default entry1 --timeout 5000 target"))
+			Expect(string(ipxe)).To(ContainSubstring("rd.cos.disable rd.debug initrd=elemental-initrd"))
+			Expect(string(ipxe)).NotTo(ContainSubstring("cos.setup"))
+
+			grubCfg, err := fs.ReadFile(filepath.Join(cfg.OutDir, "elemental-grub.cfg"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(grubCfg)).To(ContainSubstring("linux (http,boot.example.com)/elemental/elemental-kernel"))
+			Expect(string(grubCfg)).To(ContainSubstring("initrd (http,boot.example.com)/elemental/elemental-initrd"))
+			Expect(string(grubCfg)).To(ContainSubstring("set default=1\nset timeout=5\n"))
+			Expect(string(grubCfg)).To(ContainSubstring(`menuentry "Elemental (debug)"`))
+			Expect(string(grubCfg)).To(ContainSubstring("rd.cos.disable rd.debug\n"))
+			Expect(string(grubCfg)).NotTo(ContainSubstring("cos.setup"))
+		})
+		It("Successfully builds an ISO including a unified kernel image", func() {
+			iso.UKI = true
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var ukify []string
+			for _, cmd := range runner.GetCmds() {
+				if cmd[0] == "ukify" {
+					ukify = cmd
+				}
+			}
+			Expect(ukify).NotTo(BeEmpty())
+			Expect(strings.Join(ukify, " ")).To(ContainSubstring("--cmdline cdroot root=live:CDLABEL=COS_LIVE"))
+			Expect(ukify[len(ukify)-1]).To(HaveSuffix("/uefi/EFI/Linux/elemental.efi"))
+		})
+		It("Fails to build an ISO if the unified kernel image can't be created", func() {
+			iso.UKI = true
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			xorriso := runner.SideEffect
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "ukify" {
+					return []byte{}, errors.New("ukify failed")
+				}
+				return xorriso(cmd, args...)
+			}
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).Should(HaveOccurred())
+			Expect(runner.IncludesCmds([][]string{{"xorriso"}})).NotTo(BeNil())
+		})
+		It("Successfully builds an ISO embedding cloud-config and install configuration", func() {
+			iso.CloudConfig = "/config/cloud-config.yaml"
+			iso.InstallConfig = "/config/install.yaml"
+			iso.AutoInstall = true
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/vmlinuz")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = fs.Create("/local/dir/boot/initrd")
+			Expect(err).ShouldNot(HaveOccurred())
+			err = utils.MkdirAll(fs, "/config", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			err = fs.WriteFile("/config/cloud-config.yaml", []byte("#cloud-config"), constants.FilePerm)
+			Expect(err).ShouldNot(HaveOccurred())
+			err = fs.WriteFile("/config/install.yaml", []byte("target: /dev/sda"), constants.FilePerm)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var embedded []string
+			xorriso := runner.SideEffect
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "xorriso" {
+					root := args[7]
+					for _, f := range []string{"iso-config/cloud-config.yaml", "iso-config/auto-install.yaml", "install-config.yaml"} {
+						if ok, _ := utils.Exists(fs, filepath.Join(root, f)); ok {
+							embedded = append(embedded, f)
+						}
+					}
+				}
+				return xorriso(cmd, args...)
+			}
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(embedded).To(ConsistOf("iso-config/cloud-config.yaml", "iso-config/auto-install.yaml", "install-config.yaml"))
+		})
+		It("Fails to build an ISO if the cloud-config can't be fetched", func() {
+			iso.CloudConfig = "/config/missing.yaml"
+
+			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			err := utils.MkdirAll(fs, "/local/dir/boot", constants.DirPerm)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err = buildISO.ISORun()
+			Expect(err).Should(HaveOccurred())
+			Expect(memLog.String()).To(ContainSubstring("Failed embedding configuration files"))
+			Expect(runner.IncludesCmds([][]string{{"xorriso"}})).NotTo(Succeed())
+		})
+		It("Fails a reproducible build if an image source is not pinned", func() {
+			epoch := time.Unix(1672531200, 0).UTC()
+			cfg.SourceDateEpoch = &epoch
+			cfg.Reproducible = true
+
+			rootSrc, _ := v1.NewSrcFromURI("oci:elementalos:latest")
+			iso.RootFS = []*v1.ImageSource{rootSrc}
+
+			buildISO := action.NewBuildISOAction(cfg, iso)
+			err := buildISO.ISORun()
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not referenced by digest"))
+			Expect(runner.IncludesCmds([][]string{{"xorriso"}})).NotTo(Succeed())
+		})
 		It("Fails on ISO filesystem creation", func() {
 			rootSrc, _ := v1.NewSrcFromURI("dir:/local/dir")
 			iso.RootFS = []*v1.ImageSource{rootSrc}
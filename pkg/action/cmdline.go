@@ -0,0 +1,136 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"path/filepath"
+
+	cnst "github.com/rancher/elemental-cli/pkg/constants"
+	"github.com/rancher/elemental-cli/pkg/elemental"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+	"github.com/rancher/elemental-cli/pkg/utils"
+)
+
+// CmdlineAction shows and edits the extra kernel command line of each image, stored
+// in the grub OEM environment file of the state partition
+type CmdlineAction struct {
+	cfg   *v1.RunConfig
+	state *v1.Partition
+}
+
+func NewCmdlineAction(cfg *v1.RunConfig, state *v1.Partition) *CmdlineAction {
+	return &CmdlineAction{cfg: cfg, state: state}
+}
+
+// Show returns the extra kernel command line of each image
+func (c *CmdlineAction) Show() (cmdline v1.ExtraCmdline, err error) {
+	cleanup := utils.NewCleanStack()
+	defer func() {
+		err = unwind(&c.cfg.Config, cleanup, err)
+	}()
+
+	e := elemental.NewElemental(&c.cfg.Config)
+	if mnt, _ := utils.IsMounted(&c.cfg.Config, c.state); !mnt {
+		err = e.MountPartition(c.state, "ro")
+		if err != nil {
+			return cmdline, elementalError.NewFromError(err, elementalError.MountStatePartition)
+		}
+		cleanup.Push(func() error { return e.UnmountPartition(c.state) })
+	}
+
+	env, err := utils.ReadGrubEnv(c.cfg.Fs, c.grubEnvFile())
+	if err != nil {
+		return cmdline, elementalError.NewFromError(err, elementalError.ReadFile)
+	}
+	vars := cnst.GetGrubCmdlineVars()
+	cmdline.Active, _ = env.Get(vars[cnst.ActiveImgName])
+	cmdline.Passive, _ = env.Get(vars[cnst.PassiveImgName])
+	cmdline.Recovery, _ = env.Get(vars[cnst.RecoveryImgName])
+	return cmdline, nil
+}
+
+// Set sets the extra kernel command line of the given image
+func (c *CmdlineAction) Set(image, cmdline string) error {
+	key, err := c.grubVar(image)
+	if err != nil {
+		return err
+	}
+	return c.update(func(env *utils.GrubEnv) {
+		env.Set(key, cmdline)
+	})
+}
+
+// Reset removes the extra kernel command line of the given images, of all images if none is given
+func (c *CmdlineAction) Reset(images ...string) error {
+	var keys []string
+	if len(images) == 0 {
+		images = []string{cnst.ActiveImgName, cnst.PassiveImgName, cnst.RecoveryImgName}
+	}
+	for _, image := range images {
+		key, err := c.grubVar(image)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	return c.update(func(env *utils.GrubEnv) {
+		for _, key := range keys {
+			env.Unset(key)
+		}
+	})
+}
+
+// update applies the given changes to the grub OEM environment file with the state partition mounted RW
+func (c *CmdlineAction) update(change func(env *utils.GrubEnv)) (err error) {
+	cleanup := utils.NewCleanStack()
+	defer func() {
+		err = unwind(&c.cfg.Config, cleanup, err)
+	}()
+
+	e := elemental.NewElemental(&c.cfg.Config)
+	umount, err := e.MountRWPartition(c.state)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.MountStatePartition)
+	}
+	cleanup.Push(umount)
+
+	env, err := utils.ReadGrubEnv(c.cfg.Fs, c.grubEnvFile())
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.ReadFile)
+	}
+	change(env)
+	err = utils.WriteGrubEnv(c.cfg.Fs, c.grubEnvFile(), env)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.SetGrubVariables)
+	}
+	return nil
+}
+
+func (c *CmdlineAction) grubEnvFile() string {
+	return filepath.Join(c.state.MountPoint, cnst.GrubOEMEnv)
+}
+
+// grubVar returns the grub environment variable holding the extra kernel command line of the given image
+func (c *CmdlineAction) grubVar(image string) (string, error) {
+	key, ok := cnst.GetGrubCmdlineVars()[image]
+	if !ok {
+		return "", fmt.Errorf("unknown image '%s', expected one of %s, %s or %s", image, cnst.ActiveImgName, cnst.PassiveImgName, cnst.RecoveryImgName)
+	}
+	return key, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action_test
+
+import (
+	"bytes"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs"
+	"github.com/twpayne/go-vfs/vfst"
+
+	"github.com/rancher/elemental-cli/pkg/action"
+	conf "github.com/rancher/elemental-cli/pkg/config"
+	"github.com/rancher/elemental-cli/pkg/constants"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+	"github.com/rancher/elemental-cli/pkg/utils"
+	v1mock "github.com/rancher/elemental-cli/tests/mocks"
+)
+
+var _ = Describe("Cmdline action tests", Label("cmdline"), func() {
+	var config *v1.RunConfig
+	var runner *v1mock.FakeRunner
+	var fs vfs.FS
+	var mounter *v1mock.ErrorMounter
+	var cleanup func()
+	var state *v1.Partition
+	var cmdline *action.CmdlineAction
+	var grubEnv string
+
+	BeforeEach(func() {
+		var err error
+		runner = v1mock.NewFakeRunner()
+		mounter = v1mock.NewErrorMounter()
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+
+		config = conf.NewRunConfig(
+			conf.WithFs(fs),
+			conf.WithRunner(runner),
+			conf.WithLogger(v1.NewBufferLogger(&bytes.Buffer{})),
+			conf.WithMounter(mounter),
+		)
+		state = &v1.Partition{
+			Name:            constants.StatePartName,
+			FilesystemLabel: constants.StateLabel,
+			Path:            "/dev/device2",
+			MountPoint:      constants.StateDir,
+		}
+		grubEnv = filepath.Join(constants.StateDir, constants.GrubOEMEnv)
+		Expect(utils.MkdirAll(fs, constants.StateDir, constants.DirPerm)).To(Succeed())
+
+		env := utils.NewGrubEnv()
+		env.Set("state_label", constants.StateLabel)
+		env.Set(constants.GrubActiveCmdlineVar, "console=ttyS0")
+		Expect(utils.WriteGrubEnv(fs, grubEnv, env)).To(Succeed())
+
+		cmdline = action.NewCmdlineAction(config, state)
+	})
+	AfterEach(func() { cleanup() })
+
+	It("shows the extra kernel command line of each image", func() {
+		extra, err := cmdline.Show()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(extra).To(Equal(v1.ExtraCmdline{Active: "console=ttyS0"}))
+		// The state partition is mounted read only
+		Expect(mounter.List()).To(BeEmpty())
+	})
+	It("sets the extra kernel command line of an image keeping other variables", func() {
+		Expect(cmdline.Set(constants.RecoveryImgName, "rd.break quiet")).To(Succeed())
+
+		env, err := utils.ReadGrubEnv(fs, grubEnv)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Keys()).To(Equal([]string{"state_label", constants.GrubActiveCmdlineVar, constants.GrubRecoveryCmdlineVar}))
+		value, _ := env.Get(constants.GrubRecoveryCmdlineVar)
+		Expect(value).To(Equal("rd.break quiet"))
+		// The state partition is unmounted after the change
+		Expect(mounter.List()).To(BeEmpty())
+	})
+	It("resets the extra kernel command line of all images", func() {
+		Expect(cmdline.Set(constants.PassiveImgName, "quiet")).To(Succeed())
+		Expect(cmdline.Reset()).To(Succeed())
+
+		extra, err := cmdline.Show()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(extra).To(Equal(v1.ExtraCmdline{}))
+	})
+	It("resets the extra kernel command line of the given images only", func() {
+		Expect(cmdline.Set(constants.PassiveImgName, "quiet")).To(Succeed())
+		Expect(cmdline.Reset(constants.ActiveImgName)).To(Succeed())
+
+		extra, err := cmdline.Show()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(extra).To(Equal(v1.ExtraCmdline{Passive: "quiet"}))
+	})
+	It("fails on unknown images", func() {
+		Expect(cmdline.Set("unknown", "quiet")).NotTo(Succeed())
+		Expect(cmdline.Reset(constants.ActiveImgName, "unknown")).NotTo(Succeed())
+	})
+	It("fails if the state partition can't be mounted", func() {
+		mounter.ErrorOnMount = true
+		Expect(cmdline.Set(constants.ActiveImgName, "quiet")).NotTo(Succeed())
+		_, err := cmdline.Show()
+		Expect(err).To(HaveOccurred())
+	})
+})
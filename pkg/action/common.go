@@ -17,13 +17,70 @@ limitations under the License.
 package action
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 
+	cnst "github.com/rancher/elemental-cli/pkg/constants"
+	"github.com/rancher/elemental-cli/pkg/elemental"
 	elementalError "github.com/rancher/elemental-cli/pkg/error"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 	"github.com/rancher/elemental-cli/pkg/utils"
 )
 
+// actionEvents emits the progress events of an action and its steps. Starting a step
+// finishes the previous one, steps are named after their pkg/error exit code.
+type actionEvents struct {
+	log    v1.Logger
+	runner v1.Runner
+	action string
+	step   string
+}
+
+// newActionEvents emits the started event of the given action
+func newActionEvents(config *v1.Config, action string) *actionEvents {
+	config.Logger.Emit(v1.NewActionEvent(action, false, nil))
+	return &actionEvents{log: config.Logger, runner: config.Runner, action: action}
+}
+
+// start finishes the current step and emits the started event of the step of the given exit code
+func (a *actionEvents) start(code int) {
+	a.finishStep(nil)
+	a.step = elementalError.Name(code)
+	a.log.Emit(v1.NewStepEvent(a.step, false, nil))
+}
+
+// finishStep emits the finished event of the current step, if any
+func (a *actionEvents) finishStep(err error) {
+	if a.step == "" {
+		return
+	}
+	a.log.Emit(v1.NewStepEvent(a.step, true, err))
+	a.step = ""
+}
+
+// finish emits the finished events of the current step and the action with the final status.
+// On failure the returned error includes the failing step and the last command for error reports.
+func (a *actionEvents) finish(err error) error {
+	if err != nil {
+		cmd, out := a.runner.LastCommand()
+		err = elementalError.WithContext(err, a.step, cmd, string(out))
+	}
+	a.finishStep(err)
+	a.log.Emit(v1.NewActionEvent(a.action, true, err))
+	return err
+}
+
+// unwind runs the cleanup stack of an action. Cleanup jobs run their commands unbound from
+// the action context, so mounts and devices are released even if the action was cancelled.
+func unwind(config *v1.Config, cleanup *utils.CleanStack, err error) error {
+	config.Runner.SetContext(context.Background())
+	return cleanup.Cleanup(err)
+}
+
 // Hook is RunStage wrapper that only adds logic to ignore errors
 // in case v1.RunConfig.Strict is set to false
 func Hook(config *v1.Config, hook string, strict bool, cloudInitPaths ...string) error {
@@ -32,6 +89,7 @@ func Hook(config *v1.Config, hook string, strict bool, cloudInitPaths ...string)
 	config.Logger.SetLevel(logrus.ErrorLevel)
 	err := utils.RunStage(config, hook, strict, cloudInitPaths...)
 	config.Logger.SetLevel(oldLevel)
+	config.Logger.Emit(v1.NewHookEvent(hook, err))
 	if !strict {
 		err = nil
 	}
@@ -69,3 +127,32 @@ func PowerAction(cfg *v1.RunConfig) error {
 
 	return elementalError.NewFromError(err, code)
 }
+
+// ukiPath returns the path of the unified kernel image of the given image name
+// relative to the EFI partition root
+func ukiPath(name string) string {
+	return filepath.Join(cnst.UKIDir, name+cnst.UKIExt)
+}
+
+// ukiCmdline returns the kernel command line booting the given image file stored in
+// the given partition followed by the given extra arguments. Unified kernel images
+// embed the command line, thus each image requires its own.
+func ukiCmdline(part *v1.Partition, img v1.Image, extra ...string) string {
+	file := strings.TrimPrefix(img.File, part.MountPoint)
+	var cmdline string
+	if img.FS == cnst.SquashFs {
+		cmdline = fmt.Sprintf(
+			"root=live:LABEL=%s rd.live.dir=%s rd.live.squashimg=%s",
+			part.FilesystemLabel, filepath.Dir(file), filepath.Base(file),
+		)
+	} else {
+		cmdline = fmt.Sprintf("root=LABEL=%s cos-img/filename=%s", img.Label, file)
+	}
+	return strings.Join(strings.Fields(strings.Join(append([]string{cmdline}, extra...), " ")), " ")
+}
+
+// createUKI creates the unified kernel image of the given image from the given root
+// tree into the EFI partition
+func createUKI(e *elemental.Elemental, rootDir string, efi, part *v1.Partition, img v1.Image, name string, extra ...string) error {
+	return e.CreateUKI(rootDir, ukiCmdline(part, img, extra...), filepath.Join(efi.MountPoint, ukiPath(name)))
+}
@@ -58,7 +58,7 @@ func (i *InstallAction) createInstallStateYaml(sysMeta, recMeta interface{}) err
 	}
 
 	installState := &v1.InstallState{
-		Date: time.Now().Format(time.RFC3339),
+		Date: i.cfg.Now().Format(time.RFC3339),
 		Partitions: map[string]*v1.PartitionState{
 			cnst.StatePartName: {
 				FSLabel: i.spec.Partitions.State.FilesystemLabel,
@@ -68,12 +68,16 @@ func (i *InstallAction) createInstallStateYaml(sysMeta, recMeta interface{}) err
 						SourceMetadata: sysMeta,
 						Label:          i.spec.Active.Label,
 						FS:             i.spec.Active.FS,
+						Compression:    i.spec.Active.Compression,
+						UKI:            i.ukiPath(cnst.ActiveImgName),
 					},
 					cnst.PassiveImgName: {
 						Source:         i.spec.Active.Source,
 						SourceMetadata: sysMeta,
 						Label:          i.spec.Passive.Label,
 						FS:             i.spec.Passive.FS,
+						Compression:    i.spec.Active.Compression,
+						UKI:            i.ukiPath(cnst.PassiveImgName),
 					},
 				},
 			},
@@ -85,6 +89,8 @@ func (i *InstallAction) createInstallStateYaml(sysMeta, recMeta interface{}) err
 						SourceMetadata: recMeta,
 						Label:          i.spec.Recovery.Label,
 						FS:             i.spec.Recovery.FS,
+						Compression:    i.spec.Recovery.Compression,
+						UKI:            i.ukiPath(cnst.RecoveryImgName),
 					},
 				},
 			},
@@ -124,9 +130,12 @@ func NewInstallAction(cfg *v1.RunConfig, spec *v1.InstallSpec) *InstallAction {
 
 // InstallRun will install the system from a given configuration
 func (i InstallAction) Run() (err error) {
+	events := newActionEvents(&i.cfg.Config, "install")
+	defer func() { err = events.finish(err) }()
+
 	e := elemental.NewElemental(&i.cfg.Config)
 	cleanup := utils.NewCleanStack()
-	defer func() { err = cleanup.Cleanup(err) }()
+	defer func() { err = unwind(&i.cfg.Config, cleanup, err) }()
 
 	// Set installation sources from a downloaded ISO
 	if i.spec.Iso != "" {
@@ -137,12 +146,27 @@ func (i InstallAction) Run() (err error) {
 		}
 	}
 
+	// Attach the target image file to a loop device if needed
+	if i.spec.TargetImage {
+		events.start(elementalError.AttachTargetImage)
+		loop, err := e.AttachTargetImage(i.spec.Target, i.spec.TargetImageSize)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.AttachTargetImage)
+		}
+		cleanup.Push(func() error { return e.DetachTargetImage(loop) })
+		i.spec.Target = loop
+		// Boot entries of the host firmware are not related to the image
+		i.spec.DisableBootEntry = true
+	}
+
 	// Partition and format device if needed
+	events.start(elementalError.PartitioningDevice)
 	err = i.prepareDevice(e)
 	if err != nil {
 		return err
 	}
 
+	events.start(elementalError.MountPartitions)
 	err = e.MountPartitions(i.spec.Partitions.PartitionsByMountPoint(false))
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.MountPartitions)
@@ -152,12 +176,14 @@ func (i InstallAction) Run() (err error) {
 	})
 
 	// Before install hook happens after partitioning but before the image OS is applied
+	events.start(elementalError.HookBeforeInstall)
 	err = i.installHook(cnst.BeforeInstallHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookBeforeInstall)
 	}
 
 	// Deploy active image
+	events.start(elementalError.DeployImgTree)
 	systemMeta, treeCleaner, err := e.DeployImgTree(&i.spec.Active, cnst.WorkingImgDir)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.DeployImgTree)
@@ -165,12 +191,14 @@ func (i InstallAction) Run() (err error) {
 	cleanup.Push(func() error { return treeCleaner() })
 
 	// Copy cloud-init if any
+	events.start(elementalError.CopyFile)
 	err = e.CopyCloudConfig(i.spec.CloudInit)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CopyFile)
 	}
 	// Install grub
-	grub := utils.NewGrub(&i.cfg.Config)
+	events.start(elementalError.InstallGrub)
+	grub := utils.NewGrub(&i.cfg.Config, utils.WithGrubHostTools(i.spec.GrubHostTools))
 	err = grub.Install(
 		i.spec.Target,
 		cnst.WorkingImgDir,
@@ -186,21 +214,28 @@ func (i InstallAction) Run() (err error) {
 	}
 
 	// Relabel SELinux
+	events.start(elementalError.SelinuxRelabel)
 	err = i.applySelinuxLabels(e)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.SelinuxRelabel)
 	}
 
+	events.start(elementalError.HookAfterInstallChroot)
 	err = i.installChrootHook(cnst.AfterInstallChrootHook, cnst.WorkingImgDir)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookAfterInstallChroot)
 	}
+	events.start(elementalError.HookAfterInstall)
 	err = i.installHook(cnst.AfterInstallHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookAfterInstall)
 	}
 
+	events.start(elementalError.SetGrubVariables)
 	grubVars := i.spec.GetGrubLabels()
+	for key, value := range i.spec.ExtraCmdline.GetGrubVars() {
+		grubVars[key] = value
+	}
 	err = grub.SetPersistentVariables(
 		filepath.Join(i.spec.Partitions.State.MountPoint, cnst.GrubOEMEnv),
 		grubVars,
@@ -211,6 +246,7 @@ func (i InstallAction) Run() (err error) {
 	}
 
 	// Installation rebrand (only grub for now)
+	events.start(elementalError.SetDefaultGrubEntry)
 	err = e.SetDefaultGrubEntry(
 		i.spec.Partitions.State.MountPoint,
 		cnst.WorkingImgDir,
@@ -220,19 +256,45 @@ func (i InstallAction) Run() (err error) {
 		return elementalError.NewFromError(err, elementalError.SetDefaultGrubEntry)
 	}
 
+	recoveryFromActive := i.spec.Recovery.Source.IsFile() && i.spec.Active.File == i.spec.Recovery.Source.Value() && i.spec.Active.FS == i.spec.Recovery.FS
+
+	if i.cfg.SecureBoot.Enabled() {
+		events.start(elementalError.SignEFI)
+		err = e.SignKernel(cnst.WorkingImgDir)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.SignEFI)
+		}
+	}
+
+	// Unified kernel images are created from the tree before packing it into an image
+	if i.spec.UKI {
+		events.start(elementalError.CreateUKI)
+		err = i.createUKIs(e, cnst.WorkingImgDir, recoveryFromActive)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CreateUKI)
+		}
+	}
+
+	events.start(elementalError.CreateImgFromTree)
 	err = e.CreateImgFromTree(cnst.WorkingImgDir, &i.spec.Active, treeCleaner)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CreateImgFromTree)
 	}
 
 	// Install Recovery
+	events.start(elementalError.DeployImage)
 	var recoveryMeta interface{}
-	if i.spec.Recovery.Source.IsFile() && i.spec.Active.File == i.spec.Recovery.Source.Value() && i.spec.Active.FS == i.spec.Recovery.FS {
+	if recoveryFromActive {
 		// Reuse image file from active image
 		err := e.CopyFileImg(&i.spec.Recovery)
 		if err != nil {
 			return elementalError.NewFromError(err, elementalError.CopyFileImg)
 		}
+	} else if i.spec.UKI || i.cfg.SecureBoot.Enabled() {
+		recoveryMeta, err = i.deployRecoveryTree(e)
+		if err != nil {
+			return err
+		}
 	} else {
 		recoveryMeta, err = e.DeployImage(&i.spec.Recovery)
 		if err != nil {
@@ -241,24 +303,28 @@ func (i InstallAction) Run() (err error) {
 	}
 
 	// Install Passive
+	events.start(elementalError.CopyFileImg)
 	err = e.CopyFileImg(&i.spec.Passive)
 	if err != nil {
-		return elementalError.NewFromError(err, elementalError.DeployImage)
+		return elementalError.NewFromError(err, elementalError.CopyFileImg)
 	}
 
+	events.start(elementalError.HookPostInstall)
 	err = i.installHook(cnst.PostInstallHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookPostInstall)
 	}
 
 	// Add state.yaml file on state and recovery partitions
+	events.start(elementalError.CreateFile)
 	err = i.createInstallStateYaml(systemMeta, recoveryMeta)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CreateFile)
 	}
 
 	// Do not reboot/poweroff on cleanup errors
-	err = cleanup.Cleanup(err)
+	events.start(elementalError.Cleanup)
+	err = unwind(&i.cfg.Config, cleanup, err)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.Cleanup)
 	}
@@ -275,6 +341,60 @@ func (i InstallAction) Run() (err error) {
 	return PowerAction(i.cfg)
 }
 
+// ukiPath returns the unified kernel image path of the given image, if any
+func (i *InstallAction) ukiPath(name string) string {
+	if !i.spec.UKI {
+		return ""
+	}
+	return ukiPath(name)
+}
+
+// createUKIs creates the unified kernel images of the active and passive images from
+// the given root tree, also the recovery one if recovery is a copy of active. The extra
+// command line of each image is embedded in its unified kernel image.
+func (i *InstallAction) createUKIs(e *elemental.Elemental, rootDir string, withRecovery bool) error {
+	parts := i.spec.Partitions
+	err := createUKI(e, rootDir, parts.EFI, parts.State, i.spec.Active, cnst.ActiveImgName, i.spec.UKICmdline, i.spec.ExtraCmdline.Active)
+	if err != nil {
+		return err
+	}
+	err = createUKI(e, rootDir, parts.EFI, parts.State, i.spec.Passive, cnst.PassiveImgName, i.spec.UKICmdline, i.spec.ExtraCmdline.Passive)
+	if err != nil {
+		return err
+	}
+	if withRecovery {
+		return createUKI(e, rootDir, parts.EFI, parts.Recovery, i.spec.Recovery, cnst.RecoveryImgName, i.spec.UKICmdline, i.spec.ExtraCmdline.Recovery)
+	}
+	return nil
+}
+
+// deployRecoveryTree deploys the recovery image signing its kernel and creating its unified
+// kernel image from the recovery tree
+func (i *InstallAction) deployRecoveryTree(e *elemental.Elemental) (interface{}, error) {
+	meta, treeCleaner, err := e.DeployImgTree(&i.spec.Recovery, cnst.WorkingImgDir)
+	if err != nil {
+		return nil, elementalError.NewFromError(err, elementalError.DeployImgTree)
+	}
+	err = e.SignKernel(cnst.WorkingImgDir)
+	if err != nil {
+		_ = treeCleaner()
+		return nil, elementalError.NewFromError(err, elementalError.SignEFI)
+	}
+	if i.spec.UKI {
+		parts := i.spec.Partitions
+		err = createUKI(e, cnst.WorkingImgDir, parts.EFI, parts.Recovery, i.spec.Recovery, cnst.RecoveryImgName, i.spec.UKICmdline, i.spec.ExtraCmdline.Recovery)
+		if err != nil {
+			_ = treeCleaner()
+			return nil, elementalError.NewFromError(err, elementalError.CreateUKI)
+		}
+	}
+	err = e.CreateImgFromTree(cnst.WorkingImgDir, &i.spec.Recovery, treeCleaner)
+	if err != nil {
+		return nil, elementalError.NewFromError(err, elementalError.CreateImgFromTree)
+	}
+	return meta, nil
+}
+
 // applySelinuxLabels sets SELinux extended attributes to the root-tree being installed
 func (i *InstallAction) applySelinuxLabels(e *elemental.Elemental) error {
 	binds := map[string]string{}
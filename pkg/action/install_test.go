@@ -18,9 +18,9 @@ package action_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 
@@ -34,6 +34,7 @@ import (
 	"github.com/rancher/elemental-cli/pkg/action"
 	conf "github.com/rancher/elemental-cli/pkg/config"
 	"github.com/rancher/elemental-cli/pkg/constants"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 	"github.com/rancher/elemental-cli/pkg/utils"
 	v1mock "github.com/rancher/elemental-cli/tests/mocks"
@@ -136,21 +137,17 @@ var _ = Describe("Install action tests", func() {
         {"label": "COS_PERSISTENT", "type": "part", "path": "/some/device4"}
     ]
 }`), nil
+				case "losetup":
+					// Fake loop device pointing to the test device
+					if args[0] == "--show" {
+						return []byte(device), nil
+					}
+					return []byte{}, nil
 				case "cat":
 					if args[0] == "/proc/cmdline" {
 						return cmdline()
 					}
 					return []byte{}, nil
-				case "grub2-editenv":
-					if args[1] == "set" {
-						f, err := fs.OpenFile(args[0], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
-						Expect(err).To(BeNil())
-
-						_, err = f.Write([]byte(fmt.Sprintf("%s\n", args[2])))
-						Expect(err).To(BeNil())
-					}
-					return []byte{}, nil
 				default:
 					return []byte{}, nil
 				}
@@ -168,6 +165,12 @@ var _ = Describe("Install action tests", func() {
 			_, err = fs.Create(grubCfg)
 			Expect(err).To(BeNil())
 
+			// Grub BIOS install runs the grub2-install binary of the image
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "/usr/share/grub2/i386-pc"), constants.DirPerm)).To(Succeed())
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "/usr/sbin"), constants.DirPerm)).To(Succeed())
+			_, err = fs.Create(filepath.Join(constants.WorkingImgDir, "/usr/sbin/grub2-install"))
+			Expect(err).To(BeNil())
+
 			// Set default cmdline function so we dont panic :o
 			cmdline = func() ([]byte, error) {
 				return []byte{}, nil
@@ -229,6 +232,29 @@ var _ = Describe("Install action tests", func() {
 			Expect(runner.IncludesCmds([][]string{{"reboot", "-f"}}))
 		})
 
+		It("Successfully installs into an image file", func() {
+			spec.Target = "/some/disk.img"
+			spec.TargetImage = true
+			spec.TargetImageSize = 8192
+			Expect(installer.Run()).To(BeNil())
+			Expect(spec.DisableBootEntry).To(BeTrue())
+			_, err := fs.Stat("/some/disk.img")
+			Expect(err).To(BeNil())
+			Expect(runner.MatchMilestones([][]string{
+				{"losetup", "--show", "-f", "-P", "/some/disk.img"},
+				{"parted", "--script", "--machine", "--", device, "unit", "s", "mklabel", "gpt"},
+				{"losetup", "-d", device},
+			})).To(BeNil())
+		})
+
+		It("Fails to install into an image file if it can't be attached", func() {
+			spec.Target = "/some/disk.img"
+			spec.TargetImage = true
+			cmdFail = "losetup"
+			Expect(installer.Run()).NotTo(BeNil())
+			Expect(runner.IncludesCmds([][]string{{"parted"}})).NotTo(BeNil())
+		})
+
 		It("Sets the executable /run/cos/ejectcd so systemd can eject the cd on restart", func() {
 			_ = utils.MkdirAll(fs, "/usr/lib/systemd/system-shutdown", constants.DirPerm)
 			_, err := fs.Stat("/usr/lib/systemd/system-shutdown/eject")
@@ -279,6 +305,53 @@ var _ = Describe("Install action tests", func() {
 			Expect(installer.Run()).To(BeNil())
 		})
 
+		It("Successfully installs with unified kernel images", Label("uki"), func() {
+			spec.Target = device
+			spec.UKI = true
+			spec.ExtraCmdline.Active = "quiet"
+			Expect(spec.Partitions.SetFirmwarePartitions(v1.EFI, v1.GPT)).To(Succeed())
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "boot"), constants.DirPerm)).To(Succeed())
+			for _, f := range []string{"/boot/vmlinuz", "/boot/initrd"} {
+				_, err = fs.Create(filepath.Join(constants.WorkingImgDir, f))
+				Expect(err).To(BeNil())
+			}
+
+			Expect(installer.Run()).To(BeNil())
+			Expect(runner.MatchMilestones([][]string{
+				{
+					"ukify", "build", "--linux", "/run/cos/workingtree/boot/vmlinuz",
+					"--initrd", "/run/cos/workingtree/boot/initrd", "--cmdline",
+					"root=LABEL=COS_ACTIVE cos-img/filename=/cOS/active.img " + constants.UKIDefCmdline + " quiet",
+					"--output", "/run/cos/efi/EFI/Linux/active.efi",
+				},
+				{"ukify", "build", "--linux"},
+				{"ukify", "build", "--linux"},
+				{"mkfs.ext2", "-L", "COS_ACTIVE"},
+			})).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{
+					"ukify", "build", "--linux", "/run/cos/workingtree/boot/vmlinuz",
+					"--initrd", "/run/cos/workingtree/boot/initrd", "--cmdline",
+					"root=LABEL=COS_SYSTEM cos-img/filename=/cOS/recovery.img " + constants.UKIDefCmdline,
+					"--output", "/run/cos/efi/EFI/Linux/recovery.efi",
+				},
+			})).To(BeNil())
+
+			state, err := fs.ReadFile(filepath.Join(constants.StateDir, constants.InstallStateFile))
+			Expect(err).To(BeNil())
+			for _, uki := range []string{"active.efi", "passive.efi", "recovery.efi"} {
+				Expect(string(state)).To(ContainSubstring("uki: /EFI/Linux/" + uki))
+			}
+		})
+
+		It("Fails to install if the unified kernel image can't be created", Label("uki"), func() {
+			spec.Target = device
+			spec.UKI = true
+			Expect(spec.Partitions.SetFirmwarePartitions(v1.EFI, v1.GPT)).To(Succeed())
+			Expect(installer.Run()).NotTo(BeNil())
+			Expect(runner.IncludesCmds([][]string{{"ukify"}})).NotTo(BeNil())
+		})
+
 		It("Successfully sets GRUB labels", Label("grub"), func() {
 			spec.Target = device
 			Expect(installer.Run()).To(BeNil())
@@ -286,7 +359,7 @@ var _ = Describe("Install action tests", func() {
 			grubOemEnvPath := filepath.Join(constants.StateDir, "grub_oem_env")
 			Expect(utils.Exists(fs, grubOemEnvPath)).To(BeTrue())
 
-			actualBytes, err := fs.ReadFile(filepath.Join(constants.StateDir, "grub_oem_env"))
+			env, err := utils.ReadGrubEnv(fs, filepath.Join(constants.StateDir, "grub_oem_env"))
 			Expect(err).To(BeNil())
 
 			expected := map[string]string{
@@ -299,19 +372,26 @@ var _ = Describe("Install action tests", func() {
 				"persistent_label": "COS_PERSISTENT",
 			}
 
-			lines := strings.Split(string(actualBytes), "\n")
-
-			Expect(len(lines) - 1).To(Equal(len(expected)))
-
-			for _, line := range lines {
-				if line == "" {
-					continue
-				}
+			Expect(env.Keys()).To(HaveLen(len(expected)))
+			for key, value := range expected {
+				actual, _ := env.Get(key)
+				Expect(actual).To(Equal(value))
+			}
+		})
 
-				split := strings.SplitN(line, "=", 2)
+		It("Successfully sets the extra kernel command line of each image", Label("grub"), func() {
+			spec.Target = device
+			spec.ExtraCmdline = v1.ExtraCmdline{Active: "console=ttyS0", Recovery: "rd.break"}
+			Expect(installer.Run()).To(BeNil())
 
-				Expect(split[1]).To(Equal(expected[split[0]]))
-			}
+			env, err := utils.ReadGrubEnv(fs, filepath.Join(constants.StateDir, constants.GrubOEMEnv))
+			Expect(err).To(BeNil())
+			value, _ := env.Get(constants.GrubActiveCmdlineVar)
+			Expect(value).To(Equal("console=ttyS0"))
+			value, _ = env.Get(constants.GrubRecoveryCmdlineVar)
+			Expect(value).To(Equal("rd.break"))
+			_, ok := env.Get(constants.GrubPassiveCmdlineVar)
+			Expect(ok).To(BeFalse())
 		})
 
 		It("Successfully installs and adds remote cloud-config", Label("cloud-config"), func() {
@@ -371,6 +451,50 @@ var _ = Describe("Install action tests", func() {
 			Expect(runner.MatchMilestones([][]string{{"parted"}}))
 		})
 
+		It("Emits progress events of the failing step", Label("events"), func() {
+			buf := &bytes.Buffer{}
+			config.Logger.SetEventEmitter(v1.NewJSONEventEmitter(buf))
+			spec.Target = device
+			cmdFail = "parted"
+			Expect(installer.Run()).NotTo(BeNil())
+
+			var events []v1.Event
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event v1.Event
+				Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+				events = append(events, event)
+			}
+			Expect(len(events)).To(Equal(4))
+			Expect(events[0].Type).To(Equal(v1.ActionStartedEvent))
+			Expect(events[0].Action).To(Equal("install"))
+			Expect(events[1].Type).To(Equal(v1.StepStartedEvent))
+			Expect(events[1].Step).To(Equal(elementalError.Name(elementalError.PartitioningDevice)))
+			Expect(events[2].Type).To(Equal(v1.StepFinishedEvent))
+			Expect(events[2].Status).To(Equal(v1.EventStatusFailed))
+			Expect(events[2].Code).To(Equal(elementalError.PartitioningDevice))
+			Expect(events[3].Type).To(Equal(v1.ActionFinishedEvent))
+			Expect(events[3].Status).To(Equal(v1.EventStatusFailed))
+		})
+
+		It("Emits a unique name for each step", Label("events"), func() {
+			buf := &bytes.Buffer{}
+			config.Logger.SetEventEmitter(v1.NewJSONEventEmitter(buf))
+			spec.Target = device
+			Expect(installer.Run()).To(BeNil())
+
+			steps := map[string]bool{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event v1.Event
+				Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+				if event.Type == v1.StepStartedEvent {
+					Expect(steps).NotTo(HaveKey(event.Step))
+					steps[event.Step] = true
+				}
+			}
+			Expect(steps).To(HaveKey(elementalError.Name(elementalError.DeployImage)))
+			Expect(steps).To(HaveKey(elementalError.Name(elementalError.CopyFileImg)))
+		})
+
 		It("Fails on parted errors", Label("disk", "partitions"), func() {
 			spec.Target = device
 			cmdFail = "parted"
@@ -400,9 +524,9 @@ var _ = Describe("Install action tests", func() {
 
 		It("Fails on grub2-install errors", Label("grub"), func() {
 			spec.Target = device
-			cmdFail = "grub2-install"
+			cmdFail = "/usr/sbin/grub2-install"
 			Expect(installer.Run()).NotTo(BeNil())
-			Expect(runner.MatchMilestones([][]string{{"grub2-install"}}))
+			Expect(runner.MatchMilestones([][]string{{"/usr/sbin/grub2-install"}}))
 		})
 
 		It("Fails copying Passive image", Label("copy", "active"), func() {
@@ -415,9 +539,9 @@ var _ = Describe("Install action tests", func() {
 		It("Fails setting the grub default entry", Label("grub"), func() {
 			spec.Target = device
 			spec.GrubDefEntry = "cOS"
-			cmdFail = "grub2-editenv"
+			// A directory in place of the grub environment file can't be parsed
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.StateDir, constants.GrubOEMEnv), constants.DirPerm)).To(Succeed())
 			Expect(installer.Run()).NotTo(BeNil())
-			Expect(runner.MatchMilestones([][]string{{"grub2-editenv", filepath.Join(constants.StateDir, constants.GrubOEMEnv)}}))
 		})
 	})
 })
@@ -60,7 +60,7 @@ func (r *ResetAction) updateInstallState(e *elemental.Elemental, cleanup *utils.
 	}
 
 	installState := &v1.InstallState{
-		Date: time.Now().Format(time.RFC3339),
+		Date: r.cfg.Now().Format(time.RFC3339),
 		Partitions: map[string]*v1.PartitionState{
 			cnst.StatePartName: {
 				FSLabel: r.spec.Partitions.State.FilesystemLabel,
@@ -70,12 +70,16 @@ func (r *ResetAction) updateInstallState(e *elemental.Elemental, cleanup *utils.
 						SourceMetadata: meta,
 						Label:          r.spec.Active.Label,
 						FS:             r.spec.Active.FS,
+						Compression:    r.spec.Active.Compression,
+						UKI:            r.ukiPath(cnst.ActiveImgName),
 					},
 					cnst.PassiveImgName: {
 						Source:         r.spec.Active.Source,
 						SourceMetadata: meta,
 						Label:          r.spec.Passive.Label,
 						FS:             r.spec.Passive.FS,
+						Compression:    r.spec.Active.Compression,
+						UKI:            r.ukiPath(cnst.PassiveImgName),
 					},
 				},
 			},
@@ -108,19 +112,43 @@ func (r *ResetAction) updateInstallState(e *elemental.Elemental, cleanup *utils.
 	)
 }
 
+// ukiPath returns the unified kernel image path of the given image, if any
+func (r *ResetAction) ukiPath(name string) string {
+	if !r.spec.UKI {
+		return ""
+	}
+	return ukiPath(name)
+}
+
+// createUKIs creates the unified kernel images of the active and passive images from
+// the given root tree, embedding the extra command line of each image
+func (r *ResetAction) createUKIs(e *elemental.Elemental, rootDir string) error {
+	parts := r.spec.Partitions
+	err := createUKI(e, rootDir, parts.EFI, parts.State, r.spec.Active, cnst.ActiveImgName, r.spec.UKICmdline, r.spec.ExtraCmdline.Active)
+	if err != nil {
+		return err
+	}
+	return createUKI(e, rootDir, parts.EFI, parts.State, r.spec.Passive, cnst.PassiveImgName, r.spec.UKICmdline, r.spec.ExtraCmdline.Passive)
+}
+
 // ResetRun will reset the cos system to by following several steps
 func (r ResetAction) Run() (err error) {
+	events := newActionEvents(&r.cfg.Config, "reset")
+	defer func() { err = events.finish(err) }()
+
 	e := elemental.NewElemental(&r.cfg.Config)
 	cleanup := utils.NewCleanStack()
-	defer func() { err = cleanup.Cleanup(err) }()
+	defer func() { err = unwind(&r.cfg.Config, cleanup, err) }()
 
 	// Unmount partitions if any is already mounted before formatting
+	events.start(elementalError.UnmountPartitions)
 	err = e.UnmountPartitions(r.spec.Partitions.PartitionsByMountPoint(true, r.spec.Partitions.Recovery))
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.UnmountPartitions)
 	}
 
 	// Reformat state partition
+	events.start(elementalError.FormatPartitions)
 	err = e.FormatPartition(r.spec.Partitions.State)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.FormatPartitions)
@@ -148,6 +176,7 @@ func (r ResetAction) Run() (err error) {
 		}
 	}
 	// Mount configured partitions
+	events.start(elementalError.MountPartitions)
 	err = e.MountPartitions(r.spec.Partitions.PartitionsByMountPoint(false, r.spec.Partitions.Recovery))
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.MountPartitions)
@@ -157,12 +186,14 @@ func (r ResetAction) Run() (err error) {
 	})
 
 	// Before reset hook happens once partitions are aready and before deploying the OS image
+	events.start(elementalError.HookBeforeReset)
 	err = r.resetHook(cnst.BeforeResetHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookBeforeReset)
 	}
 
 	// Deploy active image
+	events.start(elementalError.DeployImgTree)
 	meta, treeCleaner, err := e.DeployImgTree(&r.spec.Active, cnst.WorkingImgDir)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.DeployImgTree)
@@ -170,7 +201,8 @@ func (r ResetAction) Run() (err error) {
 	cleanup.Push(func() error { return treeCleaner() })
 
 	// install grub
-	grub := utils.NewGrub(&r.cfg.Config)
+	events.start(elementalError.InstallGrub)
+	grub := utils.NewGrub(&r.cfg.Config, utils.WithGrubHostTools(r.spec.GrubHostTools))
 	err = grub.Install(
 		r.spec.Target,
 		cnst.WorkingImgDir,
@@ -186,6 +218,7 @@ func (r ResetAction) Run() (err error) {
 	}
 
 	// Relabel SELinux
+	events.start(elementalError.SelinuxRelabel)
 	// TODO probably relabelling persistent volumes should be an opt in feature, it could
 	// have undesired effects in case of failures
 	binds := map[string]string{}
@@ -203,16 +236,22 @@ func (r ResetAction) Run() (err error) {
 		return elementalError.NewFromError(err, elementalError.SelinuxRelabel)
 	}
 
+	events.start(elementalError.HookAfterResetChroot)
 	err = r.resetChrootHook(cnst.AfterResetChrootHook, cnst.WorkingImgDir)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookAfterResetChroot)
 	}
+	events.start(elementalError.HookAfterReset)
 	err = r.resetHook(cnst.AfterResetHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookAfterReset)
 	}
 
+	events.start(elementalError.SetGrubVariables)
 	grubVars := r.spec.GetGrubLabels()
+	for key, value := range r.spec.ExtraCmdline.GetGrubVars() {
+		grubVars[key] = value
+	}
 	err = grub.SetPersistentVariables(
 		filepath.Join(r.spec.Partitions.State.MountPoint, cnst.GrubOEMEnv),
 		grubVars,
@@ -223,6 +262,7 @@ func (r ResetAction) Run() (err error) {
 	}
 
 	// installation rebrand (only grub for now)
+	events.start(elementalError.SetDefaultGrubEntry)
 	err = e.SetDefaultGrubEntry(
 		r.spec.Partitions.State.MountPoint,
 		cnst.WorkingImgDir,
@@ -232,29 +272,51 @@ func (r ResetAction) Run() (err error) {
 		return elementalError.NewFromError(err, elementalError.SetDefaultGrubEntry)
 	}
 
+	if r.cfg.SecureBoot.Enabled() {
+		events.start(elementalError.SignEFI)
+		err = e.SignKernel(cnst.WorkingImgDir)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.SignEFI)
+		}
+	}
+
+	// Unified kernel images are created from the tree before packing it into an image
+	if r.spec.UKI {
+		events.start(elementalError.CreateUKI)
+		err = r.createUKIs(e, cnst.WorkingImgDir)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CreateUKI)
+		}
+	}
+
+	events.start(elementalError.CreateImgFromTree)
 	err = e.CreateImgFromTree(cnst.WorkingImgDir, &r.spec.Active, treeCleaner)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CreateImgFromTree)
 	}
 
 	// Install Passive
+	events.start(elementalError.CopyFileImg)
 	err = e.CopyFileImg(&r.spec.Passive)
 	if err != nil {
-		return elementalError.NewFromError(err, elementalError.DeployImage)
+		return elementalError.NewFromError(err, elementalError.CopyFileImg)
 	}
 
+	events.start(elementalError.HookPostReset)
 	err = r.resetHook(cnst.PostResetHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookPostReset)
 	}
 
+	events.start(elementalError.CreateFile)
 	err = r.updateInstallState(e, cleanup, meta)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CreateFile)
 	}
 
 	// Do not reboot/poweroff on cleanup errors
-	err = cleanup.Cleanup(err)
+	events.start(elementalError.Cleanup)
+	err = unwind(&r.cfg.Config, cleanup, err)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.Cleanup)
 	}
@@ -19,10 +19,7 @@ package action_test
 import (
 	"bytes"
 	"errors"
-	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/jaypipes/ghw/pkg/block"
 	. "github.com/onsi/ginkgo/v2"
@@ -154,17 +151,16 @@ var _ = Describe("Reset action tests", func() {
 			_, err = fs.Create(grubCfg)
 			Expect(err).To(BeNil())
 
+			// Grub BIOS install runs the grub2-install binary of the image
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "/usr/share/grub2/i386-pc"), constants.DirPerm)).To(Succeed())
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "/usr/sbin"), constants.DirPerm)).To(Succeed())
+			_, err = fs.Create(filepath.Join(constants.WorkingImgDir, "/usr/sbin/grub2-install"))
+			Expect(err).To(BeNil())
+
 			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
 				if cmdFail == cmd {
 					return []byte{}, errors.New("Command failed")
 				}
-				if cmd == "grub2-editenv" && args[1] == "set" {
-					f, err := fs.OpenFile(args[0], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-					Expect(err).To(BeNil())
-
-					_, err = f.Write([]byte(fmt.Sprintf("%s\n", args[2])))
-					Expect(err).To(BeNil())
-				}
 				return []byte{}, nil
 			}
 			reset = action.NewResetAction(config, spec)
@@ -199,7 +195,7 @@ var _ = Describe("Reset action tests", func() {
 		It("Successfully writes GRUB labels to oem_env file", func() {
 			Expect(reset.Run()).To(BeNil())
 
-			actualBytes, err := fs.ReadFile(filepath.Join(constants.StateDir, "grub_oem_env"))
+			env, err := utils.ReadGrubEnv(fs, filepath.Join(constants.StateDir, "grub_oem_env"))
 			Expect(err).To(BeNil())
 
 			expected := map[string]string{
@@ -207,26 +203,15 @@ var _ = Describe("Reset action tests", func() {
 				"active_label":       "COS_ACTIVE",
 				"passive_label":      "COS_PASSIVE",
 				"recovery_label":     "COS_RECOVERY",
-				"system_label":       "COS_SYSTEM",
 				"oem_label":          "COS_OEM",
 				"persistent_label":   "COS_PERSISTENT",
 				"default_menu_entry": "cOS",
 			}
 
-			lines := strings.Split(string(actualBytes), "\n")
-
-			By(string(actualBytes))
-
-			Expect(len(lines)).To(Equal(len(expected)))
-
-			for _, line := range lines {
-				if line == "" {
-					continue
-				}
-
-				split := strings.SplitN(line, "=", 2)
-
-				Expect(split[1]).To(Equal(expected[split[0]]))
+			Expect(env.Keys()).To(HaveLen(len(expected)))
+			for key, value := range expected {
+				actual, _ := env.Get(key)
+				Expect(actual).To(Equal(value))
 			}
 		})
 		It("Successfully resets from a docker image", Label("docker"), func() {
@@ -236,10 +221,79 @@ var _ = Describe("Reset action tests", func() {
 		It("Successfully resets from a channel package", Label("channel"), func() {
 			Expect(reset.Run()).To(BeNil())
 		})
+		It("Successfully resets unified kernel images", Label("uki"), func() {
+			statePath := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+			installState := &v1.InstallState{
+				Partitions: map[string]*v1.PartitionState{
+					constants.StatePartName: {
+						FSLabel: "COS_STATE",
+						Images: map[string]*v1.ImageState{
+							constants.ActiveImgName: {
+								Label: constants.ActiveLabel,
+								FS:    constants.LinuxImgFs,
+								UKI:   "/EFI/Linux/active.efi",
+							},
+							constants.PassiveImgName: {
+								Label: constants.PassiveLabel,
+								FS:    constants.LinuxImgFs,
+								UKI:   "/EFI/Linux/passive.efi",
+							},
+						},
+					},
+				},
+			}
+			Expect(config.WriteInstallState(installState, statePath, statePath)).To(Succeed())
+
+			Expect(utils.MkdirAll(fs, filepath.Join(constants.WorkingImgDir, "boot"), constants.DirPerm)).To(Succeed())
+			for _, f := range []string{"vmlinuz", "initrd"} {
+				Expect(fs.WriteFile(filepath.Join(constants.WorkingImgDir, "boot", f), []byte(f), constants.FilePerm)).To(Succeed())
+			}
+
+			Expect(utils.MkdirAll(fs, filepath.Dir(constants.EfiDevice), constants.DirPerm)).To(Succeed())
+			_, err = fs.Create(constants.EfiDevice)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "cat" {
+					return []byte(bootedFrom), nil
+				}
+				return []byte{}, nil
+			}
+			spec, err = conf.NewResetSpec(config.Config)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(spec.UKI).To(BeTrue())
+			// EFI bootloader install is covered by the grub tests
+			spec.Efi = false
+			spec.Active.Size = 16
+			spec.ExtraCmdline.Passive = "quiet"
+			Expect(spec.Sanitize()).To(Succeed())
+
+			reset = action.NewResetAction(config, spec)
+			Expect(reset.Run()).To(Succeed())
+
+			Expect(runner.MatchMilestones([][]string{
+				{
+					"ukify", "build", "--linux", "/run/cos/workingtree/boot/vmlinuz",
+					"--initrd", "/run/cos/workingtree/boot/initrd", "--cmdline",
+					"root=LABEL=COS_ACTIVE cos-img/filename=/cOS/active.img " + constants.UKIDefCmdline,
+				},
+				{
+					"ukify", "build", "--linux", "/run/cos/workingtree/boot/vmlinuz",
+					"--initrd", "/run/cos/workingtree/boot/initrd", "--cmdline",
+					"root=LABEL=COS_PASSIVE cos-img/filename=/cOS/passive.img " + constants.UKIDefCmdline + " quiet",
+				},
+				{"mkfs.ext2"},
+			})).To(Succeed())
+
+			stateYaml, err := fs.ReadFile(filepath.Join(spec.Partitions.State.MountPoint, constants.InstallStateFile))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(stateYaml)).To(ContainSubstring("uki: /EFI/Linux/active.efi"))
+			Expect(string(stateYaml)).To(ContainSubstring("uki: /EFI/Linux/passive.efi"))
+		})
 		It("Fails installing grub", func() {
-			cmdFail = "grub2-install"
+			cmdFail = "/usr/sbin/grub2-install"
 			Expect(reset.Run()).NotTo(BeNil())
-			Expect(runner.IncludesCmds([][]string{{"grub2-install"}}))
+			Expect(runner.IncludesCmds([][]string{{"/usr/sbin/grub2-install"}}))
 		})
 		It("Fails formatting state partition", func() {
 			cmdFail = "mkfs.ext4"
@@ -72,7 +72,7 @@ func (u UpgradeAction) upgradeChrootHook(hook string, root string) error {
 	return ChrootHook(&u.config.Config, hook, u.config.Strict, root, mountPoints, u.config.CloudInitPaths...)
 }
 
-func (u *UpgradeAction) upgradeInstallStateYaml(meta interface{}, img v1.Image) error {
+func (u *UpgradeAction) upgradeInstallStateYaml(meta interface{}, img v1.Image, ukiName string) error {
 	if u.spec.Partitions.Recovery == nil || u.spec.Partitions.State == nil {
 		return fmt.Errorf("undefined state or recovery partition")
 	}
@@ -83,12 +83,16 @@ func (u *UpgradeAction) upgradeInstallStateYaml(meta interface{}, img v1.Image)
 		}
 	}
 
-	u.spec.State.Date = time.Now().Format(time.RFC3339)
+	u.spec.State.Date = u.config.Now().Format(time.RFC3339)
 	imgState := &v1.ImageState{
 		Source:         img.Source,
 		SourceMetadata: meta,
 		Label:          img.Label,
 		FS:             img.FS,
+		Compression:    img.Compression,
+	}
+	if u.spec.UKI {
+		imgState.UKI = ukiPath(ukiName)
 	}
 	if u.spec.RecoveryUpgrade {
 		recoveryPart := u.spec.State.Partitions[constants.RecoveryPartName]
@@ -119,6 +123,10 @@ func (u *UpgradeAction) upgradeInstallStateYaml(meta interface{}, img v1.Image)
 			statePart.Images[constants.PassiveImgName].Source = statePart.Images[constants.ActiveImgName].Source
 			statePart.Images[constants.PassiveImgName].SourceMetadata = statePart.Images[constants.ActiveImgName].SourceMetadata
 			statePart.Images[constants.PassiveImgName].FS = statePart.Images[constants.ActiveImgName].FS
+			statePart.Images[constants.PassiveImgName].Compression = statePart.Images[constants.ActiveImgName].Compression
+		}
+		if u.spec.UKI {
+			statePart.Images[constants.PassiveImgName].UKI = ukiPath(constants.PassiveImgName)
 		}
 		statePart.Images[constants.ActiveImgName] = imgState
 	}
@@ -132,11 +140,14 @@ func (u *UpgradeAction) upgradeInstallStateYaml(meta interface{}, img v1.Image)
 
 func (u *UpgradeAction) Run() (err error) {
 	var upgradeImg v1.Image
-	var finalImageFile string
+	var finalImageFile, ukiName string
+
+	events := newActionEvents(&u.config.Config, "upgrade")
+	defer func() { err = events.finish(err) }()
 
 	cleanup := utils.NewCleanStack()
 	defer func() {
-		err = cleanup.Cleanup(err)
+		err = unwind(&u.config.Config, cleanup, err)
 	}()
 
 	e := elemental.NewElemental(&u.config.Config)
@@ -144,16 +155,20 @@ func (u *UpgradeAction) Run() (err error) {
 	if u.spec.RecoveryUpgrade {
 		upgradeImg = u.spec.Recovery
 		finalImageFile = filepath.Join(u.spec.Partitions.Recovery.MountPoint, "cOS", constants.RecoveryImgFile)
+		ukiName = constants.RecoveryImgName
 	} else {
 		upgradeImg = u.spec.Active
 		finalImageFile = filepath.Join(u.spec.Partitions.State.MountPoint, "cOS", constants.ActiveImgFile)
+		ukiName = constants.ActiveImgName
 	}
 
+	events.start(elementalError.MountStatePartition)
 	umount, err := e.MountRWPartition(u.spec.Partitions.State)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.MountStatePartition)
 	}
 	cleanup.Push(umount)
+	events.start(elementalError.MountRecoveryPartition)
 	umount, err = e.MountRWPartition(u.spec.Partitions.Recovery)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.MountRecoveryPartition)
@@ -163,6 +178,19 @@ func (u *UpgradeAction) Run() (err error) {
 	// Cleanup transition image file before leaving
 	cleanup.Push(func() error { return u.remove(upgradeImg.File) })
 
+	// Unified kernel images are stored in the EFI partition
+	var transitionUKI string
+	if u.spec.UKI {
+		transitionUKI = filepath.Join(u.spec.Partitions.EFI.MountPoint, ukiPath(constants.UKITransition))
+		events.start(elementalError.MountPartitions)
+		umount, err = e.MountRWPartition(u.spec.Partitions.EFI)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.MountPartitions)
+		}
+		cleanup.Push(umount)
+		cleanup.Push(func() error { return u.remove(transitionUKI) })
+	}
+
 	// Recovery does not mount persistent, so try to mount it. Ignore errors, as it's not mandatory.
 	persistentPart := u.spec.Partitions.Persistent
 	if persistentPart != nil {
@@ -180,6 +208,7 @@ func (u *UpgradeAction) Run() (err error) {
 	}
 
 	// before upgrade hook happens once partitions are RW mounted, just before image OS is deployed
+	events.start(elementalError.HookBeforeUpgrade)
 	err = u.upgradeHook(constants.BeforeUpgradeHook)
 	if err != nil {
 		u.Error("Error while running hook before-upgrade: %s", err)
@@ -188,6 +217,7 @@ func (u *UpgradeAction) Run() (err error) {
 
 	u.Info("deploying image %s to %s", upgradeImg.Source.Value(), upgradeImg.File)
 	// Deploy active image
+	events.start(elementalError.DeployImgTree)
 	upgradeMeta, treeCleaner, err := e.DeployImgTree(&upgradeImg, constants.WorkingImgDir)
 	if err != nil {
 		u.Error("Failed deploying image to file '%s': %s", upgradeImg.File, err)
@@ -198,6 +228,7 @@ func (u *UpgradeAction) Run() (err error) {
 	// Selinux relabel
 	// Doesn't make sense to relabel a readonly filesystem
 	if upgradeImg.FS != constants.SquashFs {
+		events.start(elementalError.SelinuxRelabel)
 		// Relabel SELinux
 		// TODO probably relabelling persistent volumes should be an opt in feature, it could
 		// have undesired effects in case of failures
@@ -217,22 +248,34 @@ func (u *UpgradeAction) Run() (err error) {
 		}
 	}
 
+	events.start(elementalError.HookAfterUpgradeChroot)
 	err = u.upgradeChrootHook(constants.AfterUpgradeChrootHook, constants.WorkingImgDir)
 	if err != nil {
 		u.Error("Error running hook after-upgrade-chroot: %s", err)
 		return elementalError.NewFromError(err, elementalError.HookAfterUpgradeChroot)
 	}
+	events.start(elementalError.HookAfterUpgrade)
 	err = u.upgradeHook(constants.AfterUpgradeHook)
 	if err != nil {
 		u.Error("Error running hook after-upgrade: %s", err)
 		return elementalError.NewFromError(err, elementalError.HookAfterUpgrade)
 	}
 
+	events.start(elementalError.SetGrubVariables)
+	grubEnvFile := filepath.Join(u.spec.Partitions.State.MountPoint, constants.GrubOEMEnv)
 	grubVars := u.spec.GetGrubLabels()
-	err = utils.NewGrub(&u.config.Config).SetPersistentVariables(
-		filepath.Join(u.spec.Partitions.State.MountPoint, constants.GrubOEMEnv),
-		grubVars,
-	)
+	if !u.spec.RecoveryUpgrade {
+		// The current active image becomes the passive one, so does its extra kernel command line
+		err = u.movePassiveCmdline(grubEnvFile, grubVars)
+		if err != nil {
+			u.Error("Error reading GRUB variables: %s", err)
+			return elementalError.NewFromError(err, elementalError.SetGrubVariables)
+		}
+	}
+	for key, value := range u.spec.ExtraCmdline.GetGrubVars() {
+		grubVars[key] = value
+	}
+	err = utils.NewGrub(&u.config.Config).SetPersistentVariables(grubEnvFile, grubVars)
 	if err != nil {
 		u.Error("Error setting GRUB labels: %s", err)
 		return elementalError.NewFromError(err, elementalError.SetGrubVariables)
@@ -240,6 +283,7 @@ func (u *UpgradeAction) Run() (err error) {
 
 	// Only apply rebrand stage for system upgrades
 	if !u.spec.RecoveryUpgrade {
+		events.start(elementalError.SetDefaultGrubEntry)
 		u.Info("rebranding")
 
 		err = e.SetDefaultGrubEntry(u.spec.Partitions.State.MountPoint, constants.WorkingImgDir, u.spec.GrubDefEntry)
@@ -249,6 +293,31 @@ func (u *UpgradeAction) Run() (err error) {
 		}
 	}
 
+	if u.config.SecureBoot.Enabled() {
+		events.start(elementalError.SignEFI)
+		err = e.SignKernel(constants.WorkingImgDir)
+		if err != nil {
+			u.Error("failed signing kernel")
+			return elementalError.NewFromError(err, elementalError.SignEFI)
+		}
+	}
+
+	if u.spec.UKI {
+		events.start(elementalError.CreateUKI)
+		finalImg := upgradeImg
+		finalImg.File = finalImageFile
+		var extra string
+		extra, err = u.extraCmdline(grubEnvFile, ukiName)
+		if err == nil {
+			err = e.CreateUKI(constants.WorkingImgDir, ukiCmdline(u.upgradePartition(), finalImg, u.spec.UKICmdline, extra), transitionUKI)
+		}
+		if err != nil {
+			u.Error("failed creating unified kernel image")
+			return elementalError.NewFromError(err, elementalError.CreateUKI)
+		}
+	}
+
+	events.start(elementalError.CreateImgFromTree)
 	err = e.CreateImgFromTree(constants.WorkingImgDir, &upgradeImg, treeCleaner)
 	if err != nil {
 		u.Error("failed creating transition image")
@@ -259,16 +328,27 @@ func (u *UpgradeAction) Run() (err error) {
 	if !u.spec.RecoveryUpgrade {
 		//TODO this step could be part of elemental package
 		// backup current active.img to passive.img before overwriting the active.img
-		u.Info("Backing up current active image")
 		source := filepath.Join(u.spec.Partitions.State.MountPoint, "cOS", constants.ActiveImgFile)
+		if u.spec.UKI {
+			// The passive unified kernel image is created from the current active image
+			events.start(elementalError.CreatePassiveUKI)
+			err = u.createPassiveUKI(e, source, grubEnvFile)
+			if err != nil {
+				u.Error("failed creating passive unified kernel image")
+				return elementalError.NewFromError(err, elementalError.CreatePassiveUKI)
+			}
+		}
+		events.start(elementalError.BackupActiveImage)
+		u.Info("Backing up current active image")
 		u.Info("Moving %s to %s", source, u.spec.Passive.File)
 		_, err := u.config.Runner.Run("mv", "-f", source, u.spec.Passive.File)
 		if err != nil {
 			u.Error("Failed to move %s to %s: %s", source, u.spec.Passive.File, err)
-			return elementalError.NewFromError(err, elementalError.MoveFile)
+			return elementalError.NewFromError(err, elementalError.BackupActiveImage)
 		}
 		u.Info("Finished moving %s to %s", source, u.spec.Passive.File)
 		// Label the image to passive!
+		events.start(elementalError.LabelImage)
 		out, err := u.config.Runner.Run("tune2fs", "-L", u.spec.Passive.Label, u.spec.Passive.File)
 		if err != nil {
 			u.Error("Error while labeling the passive image %s: %s", u.spec.Passive.File, err)
@@ -278,6 +358,7 @@ func (u *UpgradeAction) Run() (err error) {
 		_, _ = u.config.Runner.Run("sync")
 	}
 
+	events.start(elementalError.MoveFile)
 	u.Info("Moving %s to %s", upgradeImg.File, finalImageFile)
 	_, err = u.config.Runner.Run("mv", "-f", upgradeImg.File, finalImageFile)
 	if err != nil {
@@ -286,8 +367,18 @@ func (u *UpgradeAction) Run() (err error) {
 	}
 	u.Info("Finished moving %s to %s", upgradeImg.File, finalImageFile)
 
+	if u.spec.UKI {
+		finalUKI := filepath.Join(u.spec.Partitions.EFI.MountPoint, ukiPath(ukiName))
+		_, err = u.config.Runner.Run("mv", "-f", transitionUKI, finalUKI)
+		if err != nil {
+			u.Error("Failed to move %s to %s: %s", transitionUKI, finalUKI, err)
+			return elementalError.NewFromError(err, elementalError.MoveFile)
+		}
+	}
+
 	_, _ = u.config.Runner.Run("sync")
 
+	events.start(elementalError.HookPostUpgrade)
 	err = u.upgradeHook(constants.PostUpgradeHook)
 	if err != nil {
 		u.Error("Error running hook post-upgrade: %s", err)
@@ -295,7 +386,7 @@ func (u *UpgradeAction) Run() (err error) {
 	}
 
 	// Update state.yaml file on recovery and state partitions
-	err = u.upgradeInstallStateYaml(upgradeMeta, upgradeImg)
+	err = u.upgradeInstallStateYaml(upgradeMeta, upgradeImg, ukiName)
 	if err != nil {
 		u.Error("failed upgrading installation metadata")
 		return err
@@ -304,7 +395,8 @@ func (u *UpgradeAction) Run() (err error) {
 	u.Info("Upgrade completed")
 
 	// Do not reboot/poweroff on cleanup errors
-	err = cleanup.Cleanup(err)
+	events.start(elementalError.Cleanup)
+	err = unwind(&u.config.Config, cleanup, err)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.Cleanup)
 	}
@@ -312,6 +404,66 @@ func (u *UpgradeAction) Run() (err error) {
 	return PowerAction(u.config)
 }
 
+// upgradePartition returns the partition including the upgraded image
+func (u *UpgradeAction) upgradePartition() *v1.Partition {
+	if u.spec.RecoveryUpgrade {
+		return u.spec.Partitions.Recovery
+	}
+	return u.spec.Partitions.State
+}
+
+// movePassiveCmdline sets in grubVars the extra kernel command line of the current active image
+// as the passive one. An empty value is only set to clear a previous passive command line.
+func (u *UpgradeAction) movePassiveCmdline(grubEnvFile string, grubVars map[string]string) error {
+	env, err := utils.ReadGrubEnv(u.config.Fs, grubEnvFile)
+	if err != nil {
+		return err
+	}
+	vars := constants.GetGrubCmdlineVars()
+	active, activeSet := env.Get(vars[constants.ActiveImgName])
+	_, passiveSet := env.Get(vars[constants.PassiveImgName])
+	if activeSet || passiveSet {
+		grubVars[vars[constants.PassiveImgName]] = active
+	}
+	return nil
+}
+
+// extraCmdline returns the extra kernel command line of the given image name set in the
+// given grub environment file, which already includes the values of this upgrade
+func (u *UpgradeAction) extraCmdline(grubEnvFile, image string) (string, error) {
+	env, err := utils.ReadGrubEnv(u.config.Fs, grubEnvFile)
+	if err != nil {
+		return "", err
+	}
+	cmdline, _ := env.Get(constants.GetGrubCmdlineVars()[image])
+	return cmdline, nil
+}
+
+// createPassiveUKI creates the passive unified kernel image from the given active image file
+// which is about to become the passive image, including the passive extra command line set
+// in the given grub environment file
+func (u *UpgradeAction) createPassiveUKI(e *elemental.Elemental, activeFile, grubEnvFile string) (err error) {
+	extra, err := u.extraCmdline(grubEnvFile, constants.PassiveImgName)
+	if err != nil {
+		return err
+	}
+	img := &v1.Image{File: activeFile, MountPoint: constants.PassiveDir}
+	err = e.MountImage(img, "ro")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uErr := e.UnmountImage(img); uErr != nil && err == nil {
+			err = uErr
+		}
+	}()
+
+	return createUKI(
+		e, img.MountPoint, u.spec.Partitions.EFI, u.spec.Partitions.State,
+		u.spec.Passive, constants.PassiveImgName, u.spec.UKICmdline, extra,
+	)
+}
+
 // remove attempts to remove the given path. Does nothing if it doesn't exist
 func (u *UpgradeAction) remove(path string) error {
 	if exists, _ := utils.Exists(u.config.Fs, path); exists {
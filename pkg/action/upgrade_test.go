@@ -19,9 +19,7 @@ package action_test
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/jaypipes/ghw/pkg/block"
 	. "github.com/onsi/ginkgo/v2"
@@ -173,14 +171,6 @@ var _ = Describe("Runtime Actions", func() {
 						_ = fs.WriteFile(activeImg, source, constants.FilePerm)
 						_ = fs.RemoveAll(spec.Active.File)
 					}
-					if command == "grub2-editenv" && args[1] == "set" {
-						f, err := fs.OpenFile(args[0], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-						Expect(err).To(BeNil())
-
-						_, err = f.Write([]byte(fmt.Sprintf("%s\n", args[2])))
-						Expect(err).To(BeNil())
-					}
-
 					return []byte{}, nil
 				}
 				config.Runner = runner
@@ -283,6 +273,79 @@ var _ = Describe("Runtime Actions", func() {
 						Images[constants.PassiveImgName].Label).
 					To(Equal("CUSTOM_PASSIVE_LABEL"))
 			})
+			It("Successfully upgrades unified kernel images", Label("uki"), func() {
+				statePath := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+				installState := &v1.InstallState{
+					Partitions: map[string]*v1.PartitionState{
+						constants.StatePartName: {
+							FSLabel: "COS_STATE",
+							Images: map[string]*v1.ImageState{
+								constants.ActiveImgName: {
+									Label: constants.ActiveLabel,
+									FS:    constants.LinuxImgFs,
+									UKI:   "/EFI/Linux/active.efi",
+								},
+								constants.PassiveImgName: {
+									Label: constants.PassiveLabel,
+									FS:    constants.LinuxImgFs,
+									UKI:   "/EFI/Linux/passive.efi",
+								},
+							},
+						},
+					},
+				}
+				err = config.WriteInstallState(installState, statePath, statePath)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// Kernel and initrd of the upgraded and the current active images
+				for _, root := range []string{constants.WorkingImgDir, constants.PassiveDir} {
+					Expect(utils.MkdirAll(fs, filepath.Join(root, "boot"), constants.DirPerm)).To(Succeed())
+					for _, f := range []string{"vmlinuz", "initrd"} {
+						Expect(fs.WriteFile(filepath.Join(root, "boot", f), []byte(f), constants.FilePerm)).To(Succeed())
+					}
+				}
+
+				// The extra kernel command line of the current active image moves to passive
+				env := utils.NewGrubEnv()
+				env.Set("extra_active_cmdline", "console=ttyS0")
+				Expect(utils.WriteGrubEnv(fs, filepath.Join(constants.RunningStateDir, "grub_oem_env"), env)).To(Succeed())
+
+				spec, err = conf.NewUpgradeSpec(config.Config)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(spec.UKI).To(BeTrue())
+				spec.Active.Source = v1.NewDockerSrc("alpine")
+				spec.ExtraCmdline.Active = "quiet"
+				spec.Active.Size = 16
+				Expect(spec.Sanitize()).To(Succeed())
+
+				upgrade = action.NewUpgradeAction(config, spec)
+				Expect(upgrade.Run()).To(Succeed())
+
+				Expect(runner.MatchMilestones([][]string{
+					{
+						"ukify", "build", "--linux", "/run/cos/workingtree/boot/vmlinuz",
+						"--initrd", "/run/cos/workingtree/boot/initrd", "--cmdline",
+						"root=LABEL=COS_ACTIVE cos-img/filename=/cOS/active.img " + constants.UKIDefCmdline + " quiet",
+						"--os-release", "@/run/cos/workingtree/etc/os-release",
+						"--output", "/run/cos/efi/EFI/Linux/transition.efi",
+					},
+					{
+						"ukify", "build", "--linux", "/run/cos/passive/boot/vmlinuz",
+						"--initrd", "/run/cos/passive/boot/initrd", "--cmdline",
+						"root=LABEL=COS_PASSIVE cos-img/filename=/cOS/passive.img " + constants.UKIDefCmdline + " console=ttyS0",
+						"--output", "/run/cos/efi/EFI/Linux/passive.efi",
+					},
+					{"mv", "-f", activeImg, passiveImg},
+					{"mv", "-f", "/run/cos/efi/EFI/Linux/transition.efi", "/run/cos/efi/EFI/Linux/active.efi"},
+				})).To(BeNil())
+
+				state, err := config.LoadInstallState()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(state.Partitions[constants.StatePartName].Images[constants.ActiveImgName].UKI).
+					To(Equal("/EFI/Linux/active.efi"))
+				Expect(state.Partitions[constants.StatePartName].Images[constants.PassiveImgName].UKI).
+					To(Equal("/EFI/Linux/passive.efi"))
+			})
 			It("Writes filesystem labels to GRUB oem env file", Label("grub"), func() {
 				statePath := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
 				installState := &v1.InstallState{
@@ -321,7 +384,7 @@ var _ = Describe("Runtime Actions", func() {
 				err := upgrade.Run()
 				Expect(err).ToNot(HaveOccurred())
 
-				actualBytes, err := fs.ReadFile(filepath.Join(constants.RunningStateDir, "grub_oem_env"))
+				env, err := utils.ReadGrubEnv(fs, filepath.Join(constants.RunningStateDir, "grub_oem_env"))
 				Expect(err).To(BeNil())
 
 				expected := map[string]string{
@@ -331,25 +394,33 @@ var _ = Describe("Runtime Actions", func() {
 					"recovery_label":     "COS_RECOVERY",
 					"system_label":       "CUSTOM_RECOVERYIMG_LABEL",
 					"oem_label":          "COS_OEM",
-					"persistent_label":   "COS_PERSISTENT",
 					"default_menu_entry": "TESTOS",
 				}
 
-				lines := strings.Split(string(actualBytes), "\n")
-
-				By(string(actualBytes))
-
-				Expect(len(lines)).To(Equal(len(expected)))
-
-				for _, line := range lines {
-					if line == "" {
-						continue
-					}
+				Expect(env.Keys()).To(HaveLen(len(expected)))
+				for key, value := range expected {
+					actual, _ := env.Get(key)
+					Expect(actual).To(Equal(value))
+				}
+			})
+			It("Moves the extra kernel command line of the active image to passive", Label("grub"), func() {
+				grubEnvFile := filepath.Join(constants.RunningStateDir, "grub_oem_env")
+				env := utils.NewGrubEnv()
+				env.Set("extra_active_cmdline", "console=ttyS0")
+				env.Set("extra_passive_cmdline", "quiet")
+				Expect(utils.WriteGrubEnv(fs, grubEnvFile, env)).To(Succeed())
 
-					split := strings.SplitN(line, "=", 2)
+				spec.Active.Source = v1.NewDockerSrc("alpine")
+				spec.ExtraCmdline.Active = "console=tty1"
+				upgrade = action.NewUpgradeAction(config, spec)
+				Expect(upgrade.Run()).To(Succeed())
 
-					Expect(split[1]).To(Equal(expected[split[0]]))
-				}
+				env, err := utils.ReadGrubEnv(fs, grubEnvFile)
+				Expect(err).To(BeNil())
+				active, _ := env.Get("extra_active_cmdline")
+				Expect(active).To(Equal("console=tty1"))
+				passive, _ := env.Get("extra_passive_cmdline")
+				Expect(passive).To(Equal("console=ttyS0"))
 			})
 			It("Successfully reboots after upgrade from docker image", Label("docker"), func() {
 				spec.Active.Source = v1.NewDockerSrc("alpine")
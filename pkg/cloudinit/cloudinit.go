@@ -18,6 +18,7 @@ package cloudinit
 
 import (
 	"github.com/mudler/yip/pkg/executor"
+	"github.com/mudler/yip/pkg/logger"
 	"github.com/mudler/yip/pkg/plugins"
 	"github.com/mudler/yip/pkg/schema"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
@@ -28,11 +29,16 @@ type YipCloudInitRunner struct {
 	exec    executor.Executor
 	fs      vfs.FS
 	console plugins.Console
+	layouts *layoutExtensions
 }
 
 // NewYipCloudInitRunner returns a default yip cloud init executor with the Elemental plugin set.
 // It accepts a logger which is used inside the runner.
 func NewYipCloudInitRunner(l v1.Logger, r v1.Runner, fs vfs.FS) *YipCloudInitRunner {
+	layouts := newLayoutExtensions()
+	layout := func(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+		return layoutPlugin(l, s, layouts.get(s), fs, console)
+	}
 	exec := executor.NewExecutor(
 		executor.WithConditionals(
 			plugins.NodeConditional,
@@ -59,21 +65,24 @@ func NewYipCloudInitRunner(l v1.Logger, r v1.Runner, fs vfs.FS) *YipCloudInitRun
 			plugins.Environment,
 			plugins.SystemdFirstboot,
 			plugins.DataSources,
-			layoutPlugin,
+			layout,
 		),
 	)
+	exec.Modifier(layouts.modifier(nil))
 	return &YipCloudInitRunner{
 		exec: exec, fs: fs,
 		console: newCloudInitConsole(l, r),
+		layouts: layouts,
 	}
 }
 
 func (ci YipCloudInitRunner) Run(stage string, args ...string) error {
+	ci.layouts.start(stage)
 	return ci.exec.Run(stage, ci.fs, ci.console, args...)
 }
 
 func (ci *YipCloudInitRunner) SetModifier(m schema.Modifier) {
-	ci.exec.Modifier(m)
+	ci.exec.Modifier(ci.layouts.modifier(m))
 }
 
 // Useful for testing purposes
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/jaypipes/ghw/pkg/block"
 
@@ -194,6 +195,142 @@ stages:
 			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
 			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
 		})
+		Describe("Shrinking last partition", func() {
+			var fsOutput string
+			var ghwTest v1mock.GhwMock
+			BeforeEach(func() {
+				partNum = 3
+				fsOutput = "Block count: 1953024\nFree blocks: 1900000\nBlock size: 4096\n"
+				runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+					switch cmd {
+					case "parted":
+						return []byte(printOutput), nil
+					case "tune2fs":
+						return []byte(fsOutput), nil
+					default:
+						return []byte{}, nil
+					}
+				}
+				_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+				Expect(err).To(BeNil())
+				_, err = afs.Create(fmt.Sprintf("%s%d", device, partNum+1))
+				Expect(err).To(BeNil())
+				ghwTest = v1mock.GhwMock{}
+				ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+					{Name: fmt.Sprintf("device%d", partNum), Type: "ext4"},
+				}})
+			})
+			AfterEach(func() {
+				ghwTest.Clean()
+			})
+			It("Shrinks and moves last partition before adding a new one", func() {
+				err := afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Shrinking last partition
+    layout:
+      device:
+        path: %s
+      shrink_partition:
+        size: 1024
+        move: true
+      add_partitions:
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+`, device)), constants.FilePerm)
+				Expect(err).To(BeNil())
+				ghwTest.CreateDevices()
+				cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+				Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+				Expect(runner.IncludesCmds([][]string{
+					{"resize2fs", fmt.Sprintf("%s%d", device, partNum), "1024M"},
+					{"sfdisk", "--no-reread", "--move-data", "-N", "3", device},
+					{"mkfs.ext4", "-L", "SOMELABEL"},
+				})).To(BeNil())
+			})
+			It("Does not shrink again once the new partitions exist", func() {
+				err := afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Shrinking last partition
+    layout:
+      device:
+        path: %s
+      shrink_partition:
+        size: 1024
+      add_partitions:
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+`, device)), constants.FilePerm)
+				Expect(err).To(BeNil())
+				ghwTest.AddDisk(block.Disk{Name: "otherdevice", Partitions: []*block.Partition{
+					{Name: "otherdevice1", FilesystemLabel: "SOMELABEL"},
+				}})
+				ghwTest.CreateDevices()
+				cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+				Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"tune2fs"}})).NotTo(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(BeNil())
+			})
+			It("Skips the layout stage if shrinking is refused", func() {
+				fsOutput = "Block count: 1953024\nFree blocks: 100000\nBlock size: 4096\n"
+				err := afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Shrinking last partition
+    layout:
+      device:
+        path: %s
+      shrink_partition:
+        size: 1024
+      add_partitions:
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+`, device)), constants.FilePerm)
+				Expect(err).To(BeNil())
+				ghwTest.CreateDevices()
+				cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+				Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"mkfs.ext4"}})).NotTo(BeNil())
+			})
+			It("Fails in strict mode if shrinking is refused", func() {
+				fsOutput = "Block count: 1953024\nFree blocks: 100000\nBlock size: 4096\n"
+				err := afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Shrinking last partition
+    layout:
+      device:
+        path: %s
+      shrink_partition:
+        size: 1024
+        strict: true
+`, device)), constants.FilePerm)
+				Expect(err).To(BeNil())
+				ghwTest.CreateDevices()
+				cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+				Expect(cloudRunner.Run("test", "/some/yip")).NotTo(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(BeNil())
+			})
+			It("Fails to expand last partition to a smaller size", func() {
+				err := afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Expanding last partition
+    layout:
+      device:
+        path: %s
+      expand_partition:
+        size: 1024
+`, device)), constants.FilePerm)
+				Expect(err).To(BeNil())
+				ghwTest.CreateDevices()
+				cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+				Expect(cloudRunner.Run("test", "/some/yip")).NotTo(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(BeNil())
+			})
+		})
 		It("Adds a partition on a MSDOS disk", func() {
 			partNum = 4
 			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
@@ -213,6 +350,148 @@ stages:
 			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
 			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
 		})
+		It("Adds a partition with flags and mkfs options on a disk found by size and model", func() {
+			partNum = 4
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(`
+stages:
+  test:
+  - name: Adding new partition
+    layout:
+      device:
+        size: 24704
+        model: SOMEMODEL
+      add_partitions: 
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+        flags:
+        - boot
+        mkfsOptions:
+        - -m
+        - "0"
+`), constants.FilePerm)
+			Expect(err).To(BeNil())
+			ghwTest := v1mock.GhwMock{}
+			ghwTest.AddDisk(block.Disk{Name: "otherdevice", SizeBytes: 24704 * 1024 * 1024, Model: "OTHERMODEL"})
+			ghwTest.AddDisk(block.Disk{Name: "device", SizeBytes: 24704 * 1024 * 1024, Model: "SOMEMODEL"})
+			ghwTest.CreateDevices()
+			defer ghwTest.Clean()
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{
+					"parted", "--script", "--machine", "--", device, "unit", "s",
+					"mkpart", "primary", "ext4", "45019136", "100%", "set", "4", "boot", "on",
+				},
+				{"mkfs.ext4", "-L", "SOMELABEL", "-m", "0", fmt.Sprintf("%s%d", device, partNum)},
+			})).To(BeNil())
+		})
+		It("Applies the extensions of each stage even if stages only differ in extensions", func() {
+			partNum = 4
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Adding new partition
+    layout:
+      device:
+        path: %[1]s
+      add_partitions:
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+        mkfsOptions:
+        - -m
+        - "0"
+  - name: Adding new partition
+    layout:
+      device:
+        path: %[1]s
+      add_partitions:
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+        mkfsOptions:
+        - -m
+        - "1"
+`, device)), constants.FilePerm)
+			Expect(err).To(BeNil())
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{"mkfs.ext4", "-L", "SOMELABEL", "-m", "0", fmt.Sprintf("%s%d", device, partNum)},
+				{"mkfs.ext4", "-L", "SOMELABEL", "-m", "1", fmt.Sprintf("%s%d", device, partNum)},
+			})).To(BeNil())
+		})
+		It("Sets the partition type GUID of a new partition", func() {
+			partNum = 4
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				switch cmd {
+				case "parted":
+					return []byte(strings.Replace(printOutput, "msdos", "gpt", 1)), nil
+				default:
+					return []byte{}, nil
+				}
+			}
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Adding new partition
+    layout:
+      device:
+        path: %s
+      add_partitions: 
+      - fsLabel: SOMELABEL
+        pLabel: somelabel
+        typeGUID: 0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`, device)), constants.FilePerm)
+			Expect(err).To(BeNil())
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{"sgdisk", "--typecode=4:0FC63DAF-8483-4772-8E79-3D69D8477DE4", device},
+			})).To(BeNil())
+		})
+		It("Fails to find device by size and model", func() {
+			err := afs.WriteFile("/some/yip/layout.yaml", []byte(`
+stages:
+  test:
+  - name: Missing device model
+    layout:
+      device:
+        model: IM_NOT_THERE
+`), constants.FilePerm)
+			Expect(err).To(BeNil())
+			ghwTest := v1mock.GhwMock{}
+			ghwTest.AddDisk(block.Disk{Name: "device", Model: "SOMEMODEL"})
+			ghwTest.CreateDevices()
+			defer ghwTest.Clean()
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(BeNil())
+		})
+		It("Fails if several disks match size and model", func() {
+			err := afs.WriteFile("/some/yip/layout.yaml", []byte(`
+stages:
+  test:
+  - name: Ambiguous device
+    layout:
+      device:
+        size: 24704
+        model: SOMEMODEL
+`), constants.FilePerm)
+			Expect(err).To(BeNil())
+			ghwTest := v1mock.GhwMock{}
+			ghwTest.AddDisk(block.Disk{Name: "device", SizeBytes: 24704 * 1024 * 1024, Model: "SOMEMODEL"})
+			ghwTest.AddDisk(block.Disk{Name: "otherdevice", SizeBytes: 24704 * 1024 * 1024, Model: "SOMEMODEL"})
+			ghwTest.CreateDevices()
+			defer ghwTest.Clean()
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			err = cloudRunner.Run("test", "/some/yip")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("multiple disks"))
+		})
 		It("Fails to add a partition on a MSDOS disk", func() {
 			cmdFail = "mkfs.ext4"
 			partNum = 4
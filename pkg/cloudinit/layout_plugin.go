@@ -32,8 +32,8 @@ import (
 )
 
 // layoutPlugin is the elemental's implementation of Layout yip's plugin based
-// on partitioner package
-func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) (err error) {
+// on partitioner package, ext holds the layout settings not included in yip's schema
+func layoutPlugin(l logger.Interface, s schema.Stage, ext layoutExtension, fs vfs.FS, console plugins.Console) (err error) {
 	if s.Layout.Device == nil {
 		return nil
 	}
@@ -68,16 +68,32 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 			partitioner.WithLogger(log),
 			partitioner.WithFS(fs),
 		)
+	} else if ext.device().Size > 0 || len(strings.TrimSpace(ext.device().Model)) > 0 {
+		diskDevice, err := utils.GetDiskByAttributes(ext.device().Size, strings.TrimSpace(ext.device().Model))
+		if err != nil {
+			l.Errorf("Exiting, disk not found:\n %s", err.Error())
+			return err
+		}
+		dev = partitioner.NewDisk(
+			diskDevice,
+			partitioner.WithRunner(runner),
+			partitioner.WithLogger(log),
+			partitioner.WithFS(fs),
+		)
 	} else {
 		l.Warnf("No target device defined, nothing to do")
 		return nil
 	}
 
 	if !dev.Exists() {
-		l.Errorf("Exiting, disk not found:\n %s", s.Layout.Device.Path)
+		l.Errorf("Exiting, disk not found:\n %s", dev)
 		return errors.New("Target disk not found")
 	}
 
+	if s.Layout.Expand != nil && ext.Shrink != nil {
+		return errors.New("expand_partition and shrink_partition can't be used in the same layout stage")
+	}
+
 	if s.Layout.Expand != nil {
 		l.Infof("Extending last partition up to %d MiB", s.Layout.Expand.Size)
 		out, err := dev.ExpandLastPartition(s.Layout.Expand.Size)
@@ -87,7 +103,33 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 		}
 	}
 
-	for _, part := range s.Layout.Parts {
+	if ext.Shrink != nil {
+		if partitionsExist(runner, s.Layout.Parts) {
+			l.Infof("Partitions to add already exist, not shrinking last partition")
+		} else {
+			l.Infof("Shrinking last partition to %d MiB", ext.Shrink.Size)
+			out, err := dev.ShrinkLastPartition(ext.Shrink.Size)
+			if errors.Is(err, partitioner.ErrShrinkRefused) && !ext.Shrink.Strict {
+				l.Warnf("Skipping layout stage: %s", err.Error())
+				return nil
+			}
+			if err != nil {
+				l.Error(out)
+				return err
+			}
+			if ext.Shrink.Move {
+				l.Infof("Moving last partition to the end of the disk")
+				out, err = dev.MoveLastPartitionToEnd()
+				if err != nil {
+					l.Error(out)
+					return err
+				}
+			}
+		}
+	}
+
+	for i, part := range s.Layout.Parts {
+		partExt := ext.partition(i)
 		_, err := utils.GetFullDeviceByLabel(runner, part.FSLabel, 1)
 		if err == nil {
 			l.Warnf("Partition with FSLabel: %s already exists, ignoring", part.FSLabel)
@@ -100,14 +142,34 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 		}
 
 		l.Infof("Creating %s partition", part.FSLabel)
-		partNum, err := dev.AddPartition(part.Size, part.FileSystem, part.PLabel)
+		partNum, err := dev.AddPartition(part.Size, part.FileSystem, part.PLabel, partExt.Flags...)
 		if err != nil {
 			return fmt.Errorf("Failed creating partitions: %w", err)
 		}
-		out, err := dev.FormatPartition(partNum, part.FileSystem, part.FSLabel)
+		if partExt.TypeGUID != "" {
+			out, err := dev.SetPartitionType(partNum, partExt.TypeGUID)
+			if err != nil {
+				return fmt.Errorf("Setting partition type failed: %s\nError: %w", out, err)
+			}
+		}
+		out, err := dev.FormatPartition(partNum, part.FileSystem, part.FSLabel, partExt.MkfsOptions...)
 		if err != nil {
 			return fmt.Errorf("Formatting partition failed: %s\nError: %w", out, err)
 		}
 	}
 	return nil
 }
+
+// partitionsExist checks if all the given partitions are already created, a shrink followed by
+// adding partitions is only applied once
+func partitionsExist(runner v1.Runner, parts []schema.Partition) bool {
+	if len(parts) == 0 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := utils.GetFullDeviceByLabel(runner, part.FSLabel, 1); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mudler/yip/pkg/schema"
+	"gopkg.in/yaml.v2"
+)
+
+// layoutExtension holds the layout settings elemental supports on top of the yip layout schema
+type layoutExtension struct {
+	Device *deviceExtension     `yaml:"device,omitempty"`
+	Shrink *shrinkExtension     `yaml:"shrink_partition,omitempty"`
+	Parts  []partitionExtension `yaml:"add_partitions,omitempty"`
+}
+
+// deviceExtension selects the target device by its size in MiB and model
+type deviceExtension struct {
+	Size  uint   `yaml:"size,omitempty"`
+	Model string `yaml:"model,omitempty"`
+}
+
+// shrinkExtension shrinks the last partition to the given size in MiB and optionally moves it to
+// the end of the disk. A shrink refused for safety reasons only fails the stage in strict mode,
+// otherwise the rest of the layout stage is skipped.
+type shrinkExtension struct {
+	Size   uint `yaml:"size,omitempty"`
+	Move   bool `yaml:"move,omitempty"`
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// partitionExtension sets the parted flags, the GPT partition type and extra mkfs options of a new partition
+type partitionExtension struct {
+	Flags       []string `yaml:"flags,omitempty"`
+	TypeGUID    string   `yaml:"typeGUID,omitempty"`
+	MkfsOptions []string `yaml:"mkfsOptions,omitempty"`
+}
+
+// layoutExtensions keeps the layout extension of the loaded yip stages. yip drops unknown
+// settings when parsing, so they are read from the raw data in a yip modifier and stored by
+// stage name and index. The layout plugin only gets the parsed stage, so stages are matched
+// back in order among the stages of the running stage name sharing the same yip content.
+type layoutExtensions struct {
+	mutex  sync.Mutex
+	stage  string
+	stages map[string][]layoutStage
+}
+
+// layoutStage is the layout extension of a stage, key is the serialization of the stage
+type layoutStage struct {
+	key     string
+	ext     layoutExtension
+	applied bool
+}
+
+func newLayoutExtensions() *layoutExtensions {
+	return &layoutExtensions{stages: map[string][]layoutStage{}}
+}
+
+// start drops any previously registered extension and sets the stage name about to run
+func (l *layoutExtensions) start(stage string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.stage = stage
+	l.stages = map[string][]layoutStage{}
+}
+
+// modifier returns a yip modifier that applies the given modifier, if any, and registers the
+// layout extensions found in the resulting data. The data itself is not altered.
+func (l *layoutExtensions) modifier(m schema.Modifier) schema.Modifier {
+	return func(data []byte) ([]byte, error) {
+		var err error
+		if m != nil {
+			data, err = m(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		l.register(data)
+		return data, nil
+	}
+}
+
+// register parses the given yip data and stores the layout extension of each stage defining
+// a layout device. Data yip is not able to parse is ignored, yip reports the error on its own.
+func (l *layoutExtensions) register(data []byte) {
+	var config schema.YipConfig
+	var extConfig struct {
+		Stages map[string][]struct {
+			Layout layoutExtension `yaml:"layout,omitempty"`
+		} `yaml:"stages,omitempty"`
+	}
+
+	if yaml.Unmarshal(data, &config) != nil || yaml.Unmarshal(data, &extConfig) != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for name, stages := range config.Stages {
+		for i, s := range stages {
+			if s.Layout.Device == nil {
+				continue
+			}
+			key, err := stageKey(s)
+			if err != nil {
+				continue
+			}
+			var ext layoutExtension
+			if i < len(extConfig.Stages[name]) {
+				ext = extConfig.Stages[name][i].Layout
+			}
+			l.stages[name] = append(l.stages[name], layoutStage{key: key, ext: ext})
+		}
+	}
+}
+
+// get returns the layout extension of the given stage, empty if it has none. Stages are
+// applied in order, so the first registered stage of the running stage name with the same
+// content not applied yet is the given one.
+func (l *layoutExtensions) get(stage schema.Stage) layoutExtension {
+	if stage.Layout.Device == nil {
+		return layoutExtension{}
+	}
+	key, err := stageKey(stage)
+	if err != nil {
+		return layoutExtension{}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stages := l.stages[l.stage]
+	for i := range stages {
+		if !stages[i].applied && stages[i].key == key {
+			stages[i].applied = true
+			return stages[i].ext
+		}
+	}
+	return layoutExtension{}
+}
+
+// device returns the device extension, empty if not set
+func (e layoutExtension) device() deviceExtension {
+	if e.Device == nil {
+		return deviceExtension{}
+	}
+	return *e.Device
+}
+
+// partition returns the extension of the i-th partition to add, empty if not set
+func (e layoutExtension) partition(i int) partitionExtension {
+	if i >= len(e.Parts) {
+		return partitionExtension{}
+	}
+	return e.Parts[i]
+}
+
+func stageKey(stage schema.Stage) (string, error) {
+	b, err := json.Marshal(stage)
+	return string(b), err
+}
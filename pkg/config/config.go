@@ -120,6 +120,7 @@ func NewConfig(opts ...GenericOptions) *v1.Config {
 		Client:                    http.NewClient(),
 		Platform:                  defaultPlatform,
 		SquashFsCompressionConfig: constants.GetDefaultSquashfsCompressionOptions(),
+		ImgOverhead:               constants.ImgOverhead,
 	}
 	for _, o := range opts {
 		err := o(c)
@@ -131,7 +132,7 @@ func NewConfig(opts ...GenericOptions) *v1.Config {
 
 	// delay runner creation after we have run over the options in case we use WithRunner
 	if c.Runner == nil {
-		c.Runner = &v1.RealRunner{Logger: c.Logger}
+		c.Runner = &v1.RealRunner{Logger: c.Logger, Timeouts: constants.GetCommandTimeouts()}
 	}
 
 	// Now check if the runner has a logger inside, otherwise point our logger into it
@@ -140,6 +141,11 @@ func NewConfig(opts ...GenericOptions) *v1.Config {
 		c.Runner.SetLogger(c.Logger)
 	}
 
+	// Point the OCI image extractor to our logger, so it can report the extraction progress
+	if e, ok := c.ImageExtractor.(v1.OCIImageExtractor); ok && e.Logger == nil {
+		c.ImageExtractor = v1.OCIImageExtractor{Logger: c.Logger}
+	}
+
 	// Delay the yip runner creation, so we set the proper logger instead of blindly setting it to the logger we create
 	// at the start of NewRunConfig, as WithLogger can be passed on init, and that would result in 2 different logger
 	// instances, on the config.Logger and the other on config.CloudInitRunner
@@ -209,6 +215,7 @@ func NewInstallSpec(cfg v1.Config) *v1.InstallSpec {
 		Active:     activeImg,
 		Recovery:   recoveryImg,
 		Passive:    passiveImg,
+		UKICmdline: constants.UKIDefCmdline,
 	}
 }
 
@@ -313,12 +320,13 @@ func NewUpgradeSpec(cfg v1.Config) (*v1.UpgradeSpec, error) {
 		}
 
 		recovery = v1.Image{
-			File:       filepath.Join(ep.Recovery.MountPoint, "cOS", constants.TransitionImgFile),
-			Size:       constants.ImgSize,
-			Label:      rState.Label,
-			FS:         rState.FS,
-			MountPoint: constants.TransitionDir,
-			Source:     v1.NewEmptySrc(),
+			File:        filepath.Join(ep.Recovery.MountPoint, "cOS", constants.TransitionImgFile),
+			Size:        constants.ImgSize,
+			Label:       rState.Label,
+			FS:          rState.FS,
+			Compression: rState.Compression,
+			MountPoint:  constants.TransitionDir,
+			Source:      v1.NewEmptySrc(),
 		}
 	}
 
@@ -328,12 +336,13 @@ func NewUpgradeSpec(cfg v1.Config) (*v1.UpgradeSpec, error) {
 		}
 
 		active = v1.Image{
-			File:       filepath.Join(ep.State.MountPoint, "cOS", constants.TransitionImgFile),
-			Size:       constants.ImgSize,
-			Label:      aState.Label,
-			FS:         aState.FS,
-			MountPoint: constants.TransitionDir,
-			Source:     v1.NewEmptySrc(),
+			File:        filepath.Join(ep.State.MountPoint, "cOS", constants.TransitionImgFile),
+			Size:        constants.ImgSize,
+			Label:       aState.Label,
+			FS:          aState.FS,
+			Compression: aState.Compression,
+			MountPoint:  constants.TransitionDir,
+			Source:      v1.NewEmptySrc(),
 		}
 
 		passive = v1.Image{
@@ -344,6 +353,12 @@ func NewUpgradeSpec(cfg v1.Config) (*v1.UpgradeSpec, error) {
 		}
 	}
 
+	// Keep unified kernel images up to date if the current installation includes them
+	uki := aState.UKI != "" || rState.UKI != ""
+	if ep.EFI != nil && ep.EFI.MountPoint == "" {
+		ep.EFI.MountPoint = constants.EfiDir
+	}
+
 	// This is needed if we want to use the persistent as tmpdir for the upgrade images
 	// as tmpfs is 25% of the total RAM, we cannot rely on the tmp dir having enough space for our image
 	// This enables upgrades on low ram devices
@@ -359,6 +374,8 @@ func NewUpgradeSpec(cfg v1.Config) (*v1.UpgradeSpec, error) {
 		Passive:    passive,
 		Partitions: ep,
 		State:      installState,
+		UKI:        uki,
+		UKICmdline: constants.UKIDefCmdline,
 	}, nil
 }
 
@@ -448,12 +465,13 @@ func NewResetSpec(cfg v1.Config) (*v1.ResetSpec, error) {
 		GrubDefEntry: constants.GrubDefEntry,
 		GrubConf:     constants.GrubConf,
 		Active: v1.Image{
-			Label:      aState.Label,
-			Size:       constants.ImgSize,
-			File:       activeFile,
-			FS:         aState.FS,
-			Source:     imgSource,
-			MountPoint: constants.ActiveDir,
+			Label:       aState.Label,
+			Size:        constants.ImgSize,
+			File:        activeFile,
+			FS:          aState.FS,
+			Compression: aState.Compression,
+			Source:      imgSource,
+			MountPoint:  constants.ActiveDir,
 		},
 		Passive: v1.Image{
 			File:   filepath.Join(ep.State.MountPoint, "cOS", constants.PassiveImgFile),
@@ -462,16 +480,45 @@ func NewResetSpec(cfg v1.Config) (*v1.ResetSpec, error) {
 			FS:     aState.FS,
 		},
 		State: installState,
+		// Keep unified kernel images up to date if the current installation includes them
+		UKI:        aState.UKI != "",
+		UKICmdline: constants.UKIDefCmdline,
 	}, nil
 }
 
+// NewStatePartition returns the state partition of the current host, its mountpoint
+// defaults to StateDir if it is not mounted
+func NewStatePartition(cfg v1.Config) (*v1.Partition, error) {
+	installState, err := cfg.LoadInstallState()
+	if err != nil {
+		cfg.Logger.Warnf("failed reading installation state: %s", err.Error())
+	}
+
+	parts, err := utils.GetAllPartitions()
+	if err != nil {
+		return nil, fmt.Errorf("could not read host partitions")
+	}
+	ep := v1.NewElementalPartitionsFromList(parts, installState)
+
+	if ep.State == nil {
+		return nil, fmt.Errorf("state partition not found")
+	}
+	if ep.State.MountPoint == "" {
+		ep.State.MountPoint = constants.StateDir
+	}
+	ep.State.Name = constants.StatePartName
+	return ep.State, nil
+}
+
 func NewISO() *v1.LiveISO {
 	return &v1.LiveISO{
-		Label:     constants.ISOLabel,
-		GrubEntry: constants.GrubDefEntry,
-		UEFI:      []*v1.ImageSource{},
-		Image:     []*v1.ImageSource{},
-		Firmware:  v1.EFI,
+		Label:      constants.ISOLabel,
+		GrubEntry:  constants.GrubDefEntry,
+		BootMenu:   v1.LiveBootMenu{Timeout: constants.ISOGrubTimeout},
+		Bootloader: constants.LiveBootloaderGrub,
+		UEFI:       []*v1.ImageSource{},
+		Image:      []*v1.ImageSource{},
+		Firmware:   v1.EFI,
 	}
 }
 
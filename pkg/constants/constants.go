@@ -19,6 +19,7 @@ package constants
 import (
 	"os"
 	"runtime"
+	"time"
 )
 
 const (
@@ -50,6 +51,7 @@ const (
 	LinuxImgFs         = "ext2"
 	SquashFs           = "squashfs"
 	EfiFs              = "vfat"
+	Btrfs              = "btrfs"
 	BiosFs             = ""
 	EfiSize            = uint(64)
 	OEMSize            = uint(64)
@@ -66,6 +68,36 @@ const (
 	UsrLocalPath       = "/usr/local"
 	OEMPath            = "/oem"
 	ConfigDir          = "/etc/elemental"
+	KernelCmdline      = "/proc/cmdline"
+	DiskByIDPath       = "/dev/disk/by-id"
+
+	// Bootloader profiles directory, relative to the config dir
+	BootloaderProfilesDir = "bootloader.d"
+
+	// Grub environment variables holding the extra kernel command line of each image
+	GrubActiveCmdlineVar   = "extra_active_cmdline"
+	GrubPassiveCmdlineVar  = "extra_passive_cmdline"
+	GrubRecoveryCmdlineVar = "extra_recovery_cmdline"
+
+	// Kernel command line argument including the URL of an install configuration
+	InstallConfigCmdlineArg = "elemental.install.config"
+
+	// Squashfs compression profiles
+	SquashCompressionXzBcj   = "xz-bcj"
+	SquashCompressionZstd19  = "zstd-19"
+	SquashCompressionLz4Fast = "lz4-fast"
+	SquashCompressionNone    = "none"
+
+	// Live ISO bootloaders
+	LiveBootloaderGrub        = "grub"
+	LiveBootloaderSystemdBoot = "systemd-boot"
+
+	// Environment variable setting the timestamp of reproducible builds
+	SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+	// Log output formats
+	LogFormatText = "text"
+	LogFormatJSON = "json"
 
 	// Mountpoints of images and partitions
 	RecoveryDir     = "/run/cos/recovery"
@@ -73,6 +105,7 @@ const (
 	OEMDir          = "/run/cos/oem"
 	PersistentDir   = "/run/cos/persistent"
 	ActiveDir       = "/run/cos/active"
+	PassiveDir      = "/run/cos/passive"
 	TransitionDir   = "/run/cos/transition"
 	EfiDir          = "/run/cos/efi"
 	ImgSrcDir       = "/run/cos/imgsrc"
@@ -114,7 +147,31 @@ const (
 	ISORootFile      = "rootfs.squashfs"
 	ISOEFIImg        = "uefi.img"
 	ISOLabel         = "COS_LIVE"
-	ISOCloudInitPath = LiveDir + "/iso-config"
+	ISOGrubTimeout   = 10
+	ISOConfigDir     = "/iso-config"
+	ISOCloudInitPath = LiveDir + ISOConfigDir
+	// Embedded configuration files within the ISO root tree
+	ISOCloudConfigFile   = ISOConfigDir + "/cloud-config.yaml"
+	ISOAutoInstallFile   = ISOConfigDir + "/auto-install.yaml"
+	ISOInstallConfigFile = "/install-config.yaml"
+	ISOInstallConfigPath = LiveDir + ISOInstallConfigFile
+
+	// Unified kernel images are stored within the EFI partition
+	UKIDir        = "/EFI/Linux"
+	UKIExt        = ".efi"
+	UKIStubPath   = "/usr/lib/systemd/boot/efi"
+	UKIStubX86    = "linuxx64.efi.stub"
+	UKIStubArm64  = "linuxaa64.efi.stub"
+	UKIOSRelease  = "/etc/os-release"
+	UKIDefCmdline = "console=tty1 console=ttyS0 rd.neednet=0"
+	UKITransition = "transition"
+
+	// Netboot artifacts are named after the build name with these suffixes
+	NetbootKernelSuffix   = "-kernel"
+	NetbootInitrdSuffix   = "-initrd"
+	NetbootSquashfsSuffix = ".squashfs"
+	NetbootIPXESuffix     = ".ipxe"
+	NetbootGrubSuffix     = "-grub.cfg"
 
 	// Default directory and file fileModes
 	DirPerm        = os.ModeDir | os.ModePerm
@@ -154,6 +211,57 @@ func GetDefaultSquashfsCompressionOptions() []string {
 	return options
 }
 
+// GetDefaultGrubModules returns the grub modules required to boot from the state partition,
+// used for bootloader profiles not defining their own
+func GetDefaultGrubModules() []string {
+	return []string{"loopback", "squash4", "xzio"}
+}
+
+// GetGrubEFIImageModules returns the modules embedded in the grub EFI binaries built
+// with grub-mkimage, enough to find and load the grub configuration of the state partition
+func GetGrubEFIImageModules() []string {
+	return []string{
+		"all_video", "boot", "btrfs", "cat", "chain", "configfile", "echo", "efi_gop", "ext2",
+		"fat", "font", "gfxterm", "gzio", "halt", "linux", "loadenv", "loopback", "ls", "normal",
+		"part_gpt", "part_msdos", "reboot", "regexp", "search", "search_fs_file", "search_fs_uuid",
+		"search_label", "serial", "sleep", "squash4", "test", "true", "xfs", "xzio",
+	}
+}
+
+// GetSquashfsCompressionProfiles returns the mksquashfs compression options of each
+// compression profile
+func GetSquashfsCompressionProfiles() map[string][]string {
+	return map[string][]string{
+		SquashCompressionXzBcj:   GetDefaultSquashfsCompressionOptions(),
+		SquashCompressionZstd19:  {"-comp", "zstd", "-Xcompression-level", "19"},
+		SquashCompressionLz4Fast: {"-comp", "lz4"},
+		SquashCompressionNone:    {"-noI", "-noD", "-noF", "-noX"},
+	}
+}
+
+// GetCommandTimeouts returns the default timeouts of commands that might hang on faulty
+// devices or broken images, any other command runs without timeout
+func GetCommandTimeouts() map[string]time.Duration {
+	return map[string]time.Duration{
+		"blkdeactivate": 5 * time.Minute,
+		"udevadm":       5 * time.Minute,
+		"grub2-install": 10 * time.Minute,
+		"grub-install":  10 * time.Minute,
+		"xorriso":       time.Hour,
+		"mksquashfs":    2 * time.Hour,
+	}
+}
+
+// GetGrubCmdlineVars returns the grub environment variable holding the extra kernel
+// command line of each image
+func GetGrubCmdlineVars() map[string]string {
+	return map[string]string{
+		ActiveImgName:   GrubActiveCmdlineVar,
+		PassiveImgName:  GrubPassiveCmdlineVar,
+		RecoveryImgName: GrubRecoveryCmdlineVar,
+	}
+}
+
 // GetRunKeyEnvMap returns environment variable bindings to RunConfig data
 func GetRunKeyEnvMap() map[string]string {
 	return map[string]string{
@@ -178,6 +286,9 @@ func GetInstallKeyEnvMap() map[string]string {
 		"tty":                 "TTY",
 		"grub-entry-name":     "GRUB_ENTRY_NAME",
 		"disable-boot-entry":  "DISABLE_BOOT_ENTRY",
+		"grub-host-tools":     "GRUB_HOST_TOOLS",
+		"target-image":        "TARGET_IMAGE",
+		"target-image-size":   "TARGET_IMAGE_SIZE",
 	}
 }
 
@@ -188,6 +299,7 @@ func GetResetKeyEnvMap() map[string]string {
 		"system.uri":      "SYSTEM",
 		"tty":             "TTY",
 		"grub-entry-name": "GRUB_ENTRY_NAME",
+		"grub-host-tools": "GRUB_HOST_TOOLS",
 	}
 }
 
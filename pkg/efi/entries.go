@@ -0,0 +1,141 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package efi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	efi "github.com/canonical/go-efilib"
+)
+
+const bootNextVar = "BootNext"
+
+// BootEntryInfo is a human readable summary of a Boot#### variable
+type BootEntryInfo struct {
+	Number        int    `json:"number"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	DevicePath    string `json:"devicePath"`
+	PartitionGUID string `json:"partitionGUID,omitempty"`
+	Active        bool   `json:"active"`
+}
+
+// Entries returns the summary of all boot entries sorted by their boot number
+func (bm *BootManager) Entries() []BootEntryInfo {
+	var entries []BootEntryInfo
+	for num, entry := range bm.entries {
+		info := BootEntryInfo{Number: num, Name: fmt.Sprintf("Boot%04X", num)}
+		if entry.LoadOption != nil {
+			info.Description = entry.LoadOption.Description
+			info.DevicePath = entry.LoadOption.FilePath.String()
+			info.PartitionGUID = partitionGUID(entry.LoadOption.FilePath)
+			info.Active = entry.LoadOption.Attributes&efi.LoadOptionActive != 0
+		}
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Number < entries[j].Number })
+	return entries
+}
+
+// partitionGUID returns the GPT partition GUID the given device path points to, if any
+func partitionGUID(dp efi.DevicePath) string {
+	for _, node := range dp {
+		hd, ok := node.(*efi.HardDriveDevicePathNode)
+		if !ok {
+			continue
+		}
+		if sig, ok := hd.Signature.(efi.GUIDHardDriveSignature); ok {
+			return efi.GUID(sig).String()
+		}
+	}
+	return ""
+}
+
+// BootOrder returns the current boot order
+func (bm *BootManager) BootOrder() []int {
+	return append([]int(nil), bm.bootOrder...)
+}
+
+// BootNext returns the entry to boot on next boot only, or -1 if unset
+func (bm *BootManager) BootNext() int {
+	data, _, err := bm.efivars.GetVariable(efi.GlobalVariable, bootNextVar)
+	if err != nil || len(data) < 2 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint16(data[0:2]))
+}
+
+// SetBootNext sets the entry to boot on next boot only
+func (bm *BootManager) SetBootNext(num int) error {
+	if _, ok := bm.entries[num]; !ok {
+		return fmt.Errorf("boot entry Boot%04X not found", num)
+	}
+	var data [2]byte
+	binary.LittleEndian.PutUint16(data[0:], uint16(num))
+	return bm.efivars.SetVariable(
+		efi.GlobalVariable, bootNextVar, data[0:],
+		efi.AttributeNonVolatile|efi.AttributeBootserviceAccess|efi.AttributeRuntimeAccess,
+	)
+}
+
+// SetBootOrder commits the given boot order as is, all entries must exist
+func (bm *BootManager) SetBootOrder(order []int) error {
+	var output []byte
+	seen := map[int]bool{}
+	for _, num := range order {
+		if _, ok := bm.entries[num]; !ok {
+			return fmt.Errorf("boot entry Boot%04X not found", num)
+		}
+		if seen[num] {
+			return fmt.Errorf("boot entry Boot%04X is duplicated", num)
+		}
+		seen[num] = true
+		var numBytes [2]byte
+		binary.LittleEndian.PutUint16(numBytes[0:], uint16(num))
+		output = append(output, numBytes[0], numBytes[1])
+	}
+
+	if err := bm.efivars.SetVariable(efi.GlobalVariable, "BootOrder", output, bm.bootOrderAttrs); err != nil {
+		return err
+	}
+	bm.bootOrder = append([]int(nil), order...)
+	return nil
+}
+
+// DeleteEntry removes the given boot entry and drops it from the boot order
+func (bm *BootManager) DeleteEntry(num int) error {
+	if _, ok := bm.entries[num]; !ok {
+		return fmt.Errorf("boot entry Boot%04X not found", num)
+	}
+	if err := bm.efivars.DelVariable(efi.GlobalVariable, fmt.Sprintf("Boot%04X", num)); err != nil {
+		return err
+	}
+	delete(bm.entries, num)
+
+	var order []int
+	for _, n := range bm.bootOrder {
+		if n != num {
+			order = append(order, n)
+		}
+	}
+	if len(order) == len(bm.bootOrder) {
+		return nil
+	}
+	return bm.SetBootOrder(order)
+}
@@ -66,7 +66,8 @@ type MockEFIVariables struct {
 	store map[efi.VariableDescriptor]mockEFIVariable
 }
 
-func (m MockEFIVariables) DelVariable(_ efi.GUID, _ string) error {
+func (m MockEFIVariables) DelVariable(guid efi.GUID, name string) error {
+	delete(m.store, efi.VariableDescriptor{Name: name, GUID: guid})
 	return nil
 }
 
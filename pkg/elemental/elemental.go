@@ -19,6 +19,7 @@ package elemental
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -62,10 +63,29 @@ func (e *Elemental) PartitionAndFormatDevice(i *v1.InstallSpec) error {
 	}
 
 	e.config.Logger.Infof("Partitioning device...")
-	out, err := disk.NewPartitionTable(i.PartTable)
-	if err != nil {
-		e.config.Logger.Errorf("Failed creating new partition table: %s", out)
-		return err
+	if len(i.PreservePartitions) > 0 {
+		// Keep the current partition table and create the layout in the remaining free space.
+		// The partition table type is checked before removing any partition
+		e.config.Logger.Infof("Preserving partitions %s", strings.Join(i.PreservePartitions, ", "))
+		err := disk.Reload()
+		if err != nil {
+			e.config.Logger.Errorf("Failed analyzing disk %s: %v", i.Target, err)
+			return err
+		}
+		if disk.GetLabel() != i.PartTable {
+			return fmt.Errorf("partition table of %s is %s, %s was expected", i.Target, disk.GetLabel(), i.PartTable)
+		}
+		out, err := disk.RemovePartitionsExcept(i.PreservePartitions...)
+		if err != nil {
+			e.config.Logger.Errorf("Failed removing partitions: %s", out)
+			return err
+		}
+	} else {
+		out, err := disk.NewPartitionTable(i.PartTable)
+		if err != nil {
+			e.config.Logger.Errorf("Failed creating new partition table: %s", out)
+			return err
+		}
 	}
 
 	parts := i.Partitions.PartitionsByInstallOrder(i.ExtraPartitions)
@@ -248,8 +268,63 @@ func (e Elemental) UnmountImage(img *v1.Image) error {
 	return err
 }
 
+// AttachTargetImage attaches the given image file to a loop device with partition scanning enabled.
+// If the file does not exist it is created as a sparse file of the given size in MiB. Returns the
+// loop device path.
+func (e Elemental) AttachTargetImage(file string, size uint) (string, error) {
+	fi, err := e.config.Fs.Stat(file)
+	if err == nil && !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("target image %s is not a regular file", file)
+	} else if err != nil {
+		if size == 0 {
+			return "", fmt.Errorf("target image %s does not exist and no size to create it was given", file)
+		}
+		e.config.Logger.Infof("Creating target image %s of %d MiB", file, size)
+		err = utils.MkdirAll(e.config.Fs, filepath.Dir(file), cnst.DirPerm)
+		if err != nil {
+			return "", err
+		}
+		img, err := e.config.Fs.Create(file)
+		if err != nil {
+			return "", err
+		}
+		err = img.Truncate(int64(size * 1024 * 1024))
+		if err != nil {
+			_ = img.Close()
+			_ = e.config.Fs.RemoveAll(file)
+			return "", err
+		}
+		err = img.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	out, err := e.config.Runner.Run("losetup", "--show", "-f", "-P", file)
+	if err != nil {
+		e.config.Logger.Errorf("Failed attaching %s to a loop device: %s", file, string(out))
+		return "", err
+	}
+	loop := strings.TrimSpace(string(out))
+	e.config.Logger.Debugf("Target image %s attached to %s", file, loop)
+	return loop, nil
+}
+
+// DetachTargetImage detaches the given loop device
+func (e Elemental) DetachTargetImage(loop string) error {
+	e.config.Logger.Debugf("Detaching loop device %s", loop)
+	_, err := e.config.Runner.Run("losetup", "-d", loop)
+	return err
+}
+
 // CreateFileSystemImage creates the image file for the given image
 func (e Elemental) CreateFileSystemImage(img *v1.Image) error {
+	return e.createFileSystemImage(img, "")
+}
+
+// createFileSystemImage creates the image file for the given image. Ext and btrfs filesystems
+// are populated with the contents of the given root tree, if any, at creation time.
+func (e Elemental) createFileSystemImage(img *v1.Image, root string) error {
 	e.config.Logger.Infof("Creating file system image %s", img.File)
 	err := utils.MkdirAll(e.config.Fs, filepath.Dir(img.File), cnst.DirPerm)
 	if err != nil {
@@ -272,7 +347,24 @@ func (e Elemental) CreateFileSystemImage(img *v1.Image) error {
 		return err
 	}
 
-	mkfs := partitioner.NewMkfsCall(img.File, img.FS, img.Label, e.config.Runner)
+	var opts []string
+	if root != "" {
+		if root, err = e.config.Fs.RawPath(root); err != nil {
+			_ = e.config.Fs.RemoveAll(img.File)
+			return err
+		}
+		switch {
+		case isExtFS(img.FS):
+			opts = append(opts, "-d", root)
+		case img.FS == cnst.Btrfs:
+			opts = append(opts, "--rootdir", root)
+		}
+	}
+	if img.FS == cnst.EfiFs && e.config.SourceDateEpoch != nil {
+		// FAT volume ID defaults to a random value, derive it from the build timestamp
+		opts = append(opts, "-i", fmt.Sprintf("%08x", uint32(e.config.SourceDateEpoch.Unix())))
+	}
+	mkfs := partitioner.NewMkfsCall(img.File, img.FS, img.Label, e.config.Runner, opts...)
 	_, err = mkfs.Apply()
 	if err != nil {
 		_ = e.config.Fs.RemoveAll(img.File)
@@ -281,6 +373,31 @@ func (e Elemental) CreateFileSystemImage(img *v1.Image) error {
 	return nil
 }
 
+// copyToFatImage copies the contents of the given root tree into the given FAT image
+func (e Elemental) copyToFatImage(root string, img string) error {
+	files, err := e.config.Fs.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-s"}
+	if e.config.SourceDateEpoch != nil {
+		// Keep the clamped modification times instead of using the current time
+		args = append(args, "-m")
+	}
+	for _, f := range files {
+		_, err = e.config.Runner.Run("mcopy", append(args, "-i", img, filepath.Join(root, f.Name()), "::")...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isExtFS(fs string) bool {
+	return strings.HasPrefix(fs, "ext")
+}
+
 // DeployImgTree will deploy the given image into the given root tree. Returns source metadata in info,
 // a tree cleaner function and error. The given root will be a bind mount of a temporary directory into the same
 // filesystem of img.File, this is helpful to make the deployment easily accessible in after-* hooks.
@@ -342,19 +459,36 @@ func (e *Elemental) CreateImgFromTree(root string, img *v1.Image, cleaner func()
 
 	if img.FS == cnst.SquashFs {
 		e.config.Logger.Infof("Creating squashed image: %s", img.File)
-		squashOptions := append(cnst.GetDefaultSquashfsOptions(), e.config.SquashFsCompressionConfig...)
-		err = utils.CreateSquashFS(e.config.Runner, e.config.Logger, root, img.File, squashOptions)
+		squashOptions, err := utils.SquashfsOptions(e.config, img.Compression)
 		if err != nil {
 			return err
 		}
-	} else {
-		e.config.Logger.Infof("Creating filesystem image: %s", img.File)
-		if img.Size == 0 {
-			size, err := utils.DirSizeMB(e.config.Fs, root)
-			if err != nil {
-				return err
-			}
-			img.Size = size + cnst.ImgOverhead
+		return utils.CreateSquashFS(e.config.Runner, e.config.Logger, root, img.File, squashOptions)
+	}
+
+	e.config.Logger.Infof("Creating filesystem image: %s", img.File)
+	if img.Size == 0 {
+		size, err := utils.DirSizeMB(e.config.Fs, root)
+		if err != nil {
+			return err
+		}
+		img.Size = size + e.config.ImgOverhead
+	}
+
+	switch {
+	case isExtFS(img.FS), img.FS == cnst.Btrfs:
+		// Populated by mkfs, no loop device is required
+		return e.createFileSystemImage(img, root)
+	case img.FS == cnst.EfiFs:
+		err = e.CreateFileSystemImage(img)
+		if err != nil {
+			return err
+		}
+		return e.copyToFatImage(root, img.File)
+	default:
+		// Filesystems not supporting to be populated at creation time are loop mounted
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("populating %s images requires root privileges, use ext4, btrfs or squashfs images for unprivileged builds", img.FS)
 		}
 		err = e.CreateFileSystemImage(img)
 		if err != nil {
@@ -371,9 +505,6 @@ func (e *Elemental) CreateImgFromTree(root string, img *v1.Image, cleaner func()
 			}
 		}()
 		err = utils.SyncData(e.config.Logger, e.config.Fs, root, img.MountPoint)
-		if err != nil {
-			return err
-		}
 	}
 	return err
 }
@@ -619,6 +750,56 @@ func (e Elemental) FindKernelInitrd(rootDir string) (kernel string, initrd strin
 	return kernel, initrd, nil
 }
 
+// CreateUKI creates a unified kernel image including the kernel, initrd and os-release of the
+// given root tree and the given kernel command line. The systemd EFI stub of the root tree
+// is used if present.
+func (e Elemental) CreateUKI(rootDir, cmdline, output string) error {
+	kernel, initrd, err := e.FindKernelInitrd(rootDir)
+	if err != nil {
+		return err
+	}
+	err = utils.MkdirAll(e.config.Fs, filepath.Dir(output), cnst.DirPerm)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"build", "--linux", kernel, "--initrd", initrd, "--cmdline", cmdline}
+	osRelease := filepath.Join(rootDir, cnst.UKIOSRelease)
+	if ok, _ := utils.Exists(e.config.Fs, osRelease); ok {
+		args = append(args, "--os-release", "@"+osRelease)
+	}
+	stub := cnst.UKIStubX86
+	if e.config.Platform != nil && e.config.Platform.Arch == cnst.ArchArm64 {
+		stub = cnst.UKIStubArm64
+	}
+	stub = filepath.Join(rootDir, cnst.UKIStubPath, stub)
+	if ok, _ := utils.Exists(e.config.Fs, stub); ok {
+		args = append(args, "--stub", stub)
+	}
+	args = append(args, "--output", output)
+
+	e.config.Logger.Infof("Creating unified kernel image %s", output)
+	out, err := e.config.Runner.Run("ukify", args...)
+	if err != nil {
+		e.config.Logger.Errorf("failed creating unified kernel image: %s", string(out))
+		return err
+	}
+	return utils.SignEFI(e.config, output)
+}
+
+// SignKernel signs the kernel of the given root tree with the configured Secure Boot keys.
+// It does nothing if no signing keys are configured.
+func (e Elemental) SignKernel(rootDir string) error {
+	if !e.config.SecureBoot.Enabled() {
+		return nil
+	}
+	kernel, _, err := e.FindKernelInitrd(rootDir)
+	if err != nil {
+		return err
+	}
+	return utils.SignEFI(e.config, kernel)
+}
+
 // DeactivateDevice deactivates unmounted the block devices present within the system.
 // Useful to deactivate LVM volumes, if any, related to the target device.
 func (e Elemental) DeactivateDevices() error {
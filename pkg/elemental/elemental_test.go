@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/jaypipes/ghw/pkg/block"
 
@@ -280,6 +281,56 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 		})
 	})
 
+	Describe("AttachTargetImage", Label("AttachTargetImage", "image", "loop"), func() {
+		var el *elemental.Elemental
+		BeforeEach(func() {
+			runner.ReturnValue = []byte("/dev/loop0\n")
+			el = elemental.NewElemental(config)
+		})
+
+		It("Creates a sparse image file and attaches it", func() {
+			loop, err := el.AttachTargetImage("/some/disk.img", 1024)
+			Expect(err).To(BeNil())
+			Expect(loop).To(Equal("/dev/loop0"))
+			info, err := fs.Stat("/some/disk.img")
+			Expect(err).To(BeNil())
+			Expect(info.Size()).To(Equal(int64(1024 * 1024 * 1024)))
+			Expect(runner.CmdsMatch([][]string{{"losetup", "--show", "-f", "-P", "/some/disk.img"}})).To(BeNil())
+		})
+
+		It("Attaches an existing image file", func() {
+			Expect(utils.MkdirAll(fs, "/some", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/some/disk.img", []byte("data"), constants.FilePerm)).To(Succeed())
+			loop, err := el.AttachTargetImage("/some/disk.img", 0)
+			Expect(err).To(BeNil())
+			Expect(loop).To(Equal("/dev/loop0"))
+			Expect(runner.CmdsMatch([][]string{{"losetup", "--show", "-f", "-P", "/some/disk.img"}})).To(BeNil())
+		})
+
+		It("Fails if the image file does not exist and no size is given", func() {
+			_, err := el.AttachTargetImage("/some/disk.img", 0)
+			Expect(err).NotTo(BeNil())
+			Expect(runner.CmdsMatch([][]string{})).To(BeNil())
+		})
+
+		It("Fails if the target is not a regular file", func() {
+			Expect(utils.MkdirAll(fs, "/some/dir", constants.DirPerm)).To(Succeed())
+			_, err := el.AttachTargetImage("/some/dir", 1024)
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Fails to attach the image to a loop device", func() {
+			runner.ReturnError = errors.New("failed to set a loop device")
+			_, err := el.AttachTargetImage("/some/disk.img", 1024)
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Detaches a loop device", func() {
+			Expect(el.DetachTargetImage("/dev/loop0")).To(BeNil())
+			Expect(runner.CmdsMatch([][]string{{"losetup", "-d", "/dev/loop0"}})).To(BeNil())
+		})
+	})
+
 	Describe("CreateFileSystemImage", Label("CreateFileSystemImage", "image"), func() {
 		var el *elemental.Elemental
 		var img *v1.Image
@@ -430,6 +481,38 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 				Expect(el.PartitionAndFormatDevice(install)).To(BeNil())
 				Expect(runner.MatchMilestones(biosPartCmds)).To(BeNil())
 			})
+
+			It("Successfully creates partitions in free space preserving existing ones", func() {
+				partNum = 1
+				printOut = printOutput + "\n1:40000000s:50593758s:10593759s:ext4:vendor:;"
+				install.PartTable = v1.GPT
+				install.Firmware = v1.EFI
+				install.PreservePartitions = []string{"vendor"}
+				install.Partitions.SetFirmwarePartitions(v1.EFI, v1.GPT)
+				Expect(el.PartitionAndFormatDevice(install)).To(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "mklabel", "gpt"}})).NotTo(BeNil())
+				Expect(runner.MatchMilestones([][]string{
+					{
+						"parted", "--script", "--machine", "--", "/some/device", "unit", "s",
+						"mkpart", "efi", "fat32", "2048", "133119", "set", "2", "esp", "on",
+					}, {"mkfs.vfat", "-n", "COS_GRUB", "/some/device2"}, {
+						"parted", "--script", "--machine", "--", "/some/device", "unit", "s",
+						"mkpart", "persistent", "ext4", "25430016", "39999999",
+					}, {"mkfs.ext4", "-L", "COS_PERSISTENT", "/some/device6"},
+				})).To(BeNil())
+			})
+
+			It("Fails preserving partitions if the partition table type does not match", func() {
+				printOut = printOutput + "\n1:2048s:39999999s:39997952s:ext4:other:;\n2:40000000s:50593758s:10593759s:ext4:vendor:;"
+				install.PartTable = v1.MSDOS
+				install.PreservePartitions = []string{"2"}
+				Expect(el.PartitionAndFormatDevice(install)).NotTo(BeNil())
+				Expect(partNum).To(Equal(0))
+				// No partition is removed
+				for _, cmd := range runner.GetCmds() {
+					Expect(cmd).NotTo(ContainElement("rm"))
+				}
+			})
 		})
 
 		Describe("Run with failures", func() {
@@ -628,10 +711,34 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			err := e.CreateImgFromTree(root, img, cleaner)
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(img.Size).To(Equal(32 + constants.ImgOverhead + 1))
-			exists, _ := utils.Exists(fs, "/some/mountpoint/somefile")
-			Expect(exists).To(BeTrue())
+			rawRoot, _ := fs.RawPath(root)
+			Expect(runner.CmdsMatch([][]string{{"mkfs.ext2", "-d", rawRoot, imgFile}})).To(Succeed())
+			Expect(mounter.List()).To(BeEmpty())
 			Expect(cleaned).To(BeTrue())
 		})
+		It("Creates a btrfs image including the root tree contents", func() {
+			img.FS = constants.Btrfs
+			err := e.CreateImgFromTree(root, img, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			rawRoot, _ := fs.RawPath(root)
+			Expect(runner.CmdsMatch([][]string{{"mkfs.btrfs", "--rootdir", rawRoot, imgFile}})).To(Succeed())
+			Expect(mounter.List()).To(BeEmpty())
+		})
+		It("Fails to populate an xfs image without root privileges", func() {
+			if os.Geteuid() == 0 {
+				Skip("requires running without root privileges")
+			}
+			img.FS = "xfs"
+			err := e.CreateImgFromTree(root, img, nil)
+			Expect(err).Should(HaveOccurred())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("Creates an image with the configured overhead", func() {
+			config.ImgOverhead = 64
+			err := e.CreateImgFromTree(root, img, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(img.Size).To(Equal(uint(32 + 64 + 1)))
+		})
 		It("Creates an squashfs image", func() {
 			img.FS = constants.SquashFs
 			err := e.CreateImgFromTree(root, img, nil)
@@ -639,8 +746,32 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(img.Size).To(Equal(uint(0)))
 			Expect(runner.IncludesCmds([][]string{{"mksquashfs"}}))
 		})
+		It("Creates a FAT image copying the root tree contents", func() {
+			img.FS = constants.EfiFs
+			err := e.CreateImgFromTree(root, img, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(runner.MatchMilestones([][]string{
+				{"mkfs.vfat", imgFile},
+				{"mcopy", "-s", "-i", imgFile, filepath.Join(root, "somefile"), "::"},
+			})).To(Succeed())
+		})
+		It("Creates a reproducible FAT image if SOURCE_DATE_EPOCH is set", func() {
+			epoch := time.Unix(1672531200, 0)
+			config.SourceDateEpoch = &epoch
+			img.FS = constants.EfiFs
+			err := e.CreateImgFromTree(root, img, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(runner.MatchMilestones([][]string{
+				{"mkfs.vfat", "-i", "63b0cd00", imgFile},
+				{"mcopy", "-s", "-m", "-i", imgFile, filepath.Join(root, "somefile"), "::"},
+			})).To(Succeed())
+		})
 		It("Creates an image of an specific size including including the root tree contents", func() {
+			if os.Geteuid() != 0 {
+				Skip("loop mounting images requires root privileges")
+			}
 			img.Size = 64
+			img.FS = "xfs"
 			err := e.CreateImgFromTree(root, img, nil)
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(img.Size).To(Equal(uint(64)))
@@ -648,6 +779,10 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(exists).To(BeTrue())
 		})
 		It("Fails to mount created filesystem image", func() {
+			if os.Geteuid() != 0 {
+				Skip("loop mounting images requires root privileges")
+			}
+			img.FS = "xfs"
 			mounter.ErrorOnMount = true
 			err := e.CreateImgFromTree(root, img, nil)
 			Expect(err).Should(HaveOccurred())
@@ -655,6 +790,10 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(cleaned).To(BeFalse())
 		})
 		It("Fails to mount created filesystem image", func() {
+			if os.Geteuid() != 0 {
+				Skip("loop mounting images requires root privileges")
+			}
+			img.FS = "xfs"
 			mounter.ErrorOnUnmount = true
 			err := e.CreateImgFromTree(root, img, nil)
 			Expect(err).Should(HaveOccurred())
@@ -981,6 +1120,9 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 		})
 	})
 	Describe("SetDefaultGrubEntry", Label("SetDefaultGrubEntry", "grub"), func() {
+		BeforeEach(func() {
+			Expect(utils.MkdirAll(fs, "/mountpoint", constants.DirPerm)).To(Succeed())
+		})
 		It("Sets the default grub entry without issues", func() {
 			el := elemental.NewElemental(config)
 			Expect(el.SetDefaultGrubEntry("/mountpoint", "/imgMountpoint", "default_entry")).To(BeNil())
@@ -988,8 +1130,8 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 		It("does nothing on empty default entry and no /etc/os-release", func() {
 			el := elemental.NewElemental(config)
 			Expect(el.SetDefaultGrubEntry("/mountpoint", "/imgMountPoint", "")).To(BeNil())
-			// No grub2-editenv command called
-			Expect(runner.CmdsMatch([][]string{{"grub2-editenv"}})).NotTo(BeNil())
+			// No grub environment file written
+			Expect(utils.Exists(fs, "/mountpoint/grub_oem_env")).To(BeFalse())
 		})
 		It("loads /etc/os-release on empty default entry", func() {
 			err := utils.MkdirAll(config.Fs, "/imgMountPoint/etc", constants.DirPerm)
@@ -999,13 +1141,14 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 
 			el := elemental.NewElemental(config)
 			Expect(el.SetDefaultGrubEntry("/mountpoint", "/imgMountPoint", "")).To(BeNil())
-			// Calls grub2-editenv with the loaded content from /etc/os-release
-			Expect(runner.CmdsMatch([][]string{
-				{"grub2-editenv", "/mountpoint/grub_oem_env", "set", "default_menu_entry=test"},
-			})).To(BeNil())
+			// Sets the loaded content from /etc/os-release
+			env, err := utils.ReadGrubEnv(fs, "/mountpoint/grub_oem_env")
+			Expect(err).ShouldNot(HaveOccurred())
+			entry, _ := env.Get("default_menu_entry")
+			Expect(entry).To(Equal("test"))
 		})
 		It("Fails setting grubenv", func() {
-			runner.ReturnError = errors.New("failure")
+			Expect(utils.MkdirAll(fs, "/mountpoint/grub_oem_env", constants.DirPerm)).To(Succeed())
 			el := elemental.NewElemental(config)
 			Expect(el.SetDefaultGrubEntry("/mountpoint", "/imgMountPoint", "default_entry")).NotTo(BeNil())
 		})
@@ -1045,6 +1188,85 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(err).Should(HaveOccurred())
 		})
 	})
+	Describe("CreateUKI", Label("uki"), func() {
+		BeforeEach(func() {
+			for _, dir := range []string{"/path/boot", "/path/etc", "/path/usr/lib/systemd/boot/efi"} {
+				Expect(utils.MkdirAll(fs, dir, constants.DirPerm)).To(Succeed())
+			}
+			for _, f := range []string{"/path/boot/vmlinuz", "/path/boot/initrd"} {
+				_, err := fs.Create(f)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		})
+		It("creates a unified kernel image with the os-release and stub of the tree", func() {
+			for _, f := range []string{"/path/etc/os-release", "/path/usr/lib/systemd/boot/efi/linuxx64.efi.stub"} {
+				_, err := fs.Create(f)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+			config.Platform, _ = v1.NewPlatformFromArch(constants.ArchAmd64)
+
+			el := elemental.NewElemental(config)
+			Expect(el.CreateUKI("/path", "root=LABEL=COS_ACTIVE", "/efi/EFI/Linux/active.efi")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{{
+				"ukify", "build", "--linux", "/path/boot/vmlinuz", "--initrd", "/path/boot/initrd",
+				"--cmdline", "root=LABEL=COS_ACTIVE", "--os-release", "@/path/etc/os-release",
+				"--stub", "/path/usr/lib/systemd/boot/efi/linuxx64.efi.stub",
+				"--output", "/efi/EFI/Linux/active.efi",
+			}})).To(BeNil())
+			Expect(utils.Exists(fs, "/efi/EFI/Linux")).To(BeTrue())
+		})
+		It("creates a unified kernel image with the default stub", func() {
+			el := elemental.NewElemental(config)
+			Expect(el.CreateUKI("/path", "root=LABEL=COS_ACTIVE", "/efi/EFI/Linux/active.efi")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{{
+				"ukify", "build", "--linux", "/path/boot/vmlinuz", "--initrd", "/path/boot/initrd",
+				"--cmdline", "root=LABEL=COS_ACTIVE", "--output", "/efi/EFI/Linux/active.efi",
+			}})).To(BeNil())
+		})
+		It("fails if no kernel is found", func() {
+			Expect(fs.Remove("/path/boot/vmlinuz")).To(Succeed())
+			el := elemental.NewElemental(config)
+			Expect(el.CreateUKI("/path", "", "/efi/EFI/Linux/active.efi")).NotTo(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("fails if ukify fails", func() {
+			runner.ReturnError = errors.New("ukify failed")
+			el := elemental.NewElemental(config)
+			Expect(el.CreateUKI("/path", "", "/efi/EFI/Linux/active.efi")).NotTo(Succeed())
+		})
+	})
+	Describe("SignKernel", Label("secureboot"), func() {
+		BeforeEach(func() {
+			Expect(utils.MkdirAll(fs, "/path/boot", constants.DirPerm)).To(Succeed())
+			for _, f := range []string{"/path/boot/vmlinuz", "/path/boot/initrd"} {
+				_, err := fs.Create(f)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		})
+		It("does nothing without signing keys", func() {
+			el := elemental.NewElemental(config)
+			Expect(el.SignKernel("/path")).To(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("signs the kernel of the given tree", func() {
+			config.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt"}
+			el := elemental.NewElemental(config)
+			Expect(el.SignKernel("/path")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{{
+				"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt",
+				"--output", "/path/boot/vmlinuz", "/path/boot/vmlinuz",
+			}})).To(BeNil())
+		})
+		It("signs unified kernel images", func() {
+			config.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt"}
+			el := elemental.NewElemental(config)
+			Expect(el.CreateUKI("/path", "", "/efi/EFI/Linux/active.efi")).To(Succeed())
+			Expect(runner.MatchMilestones([][]string{
+				{"ukify", "build"},
+				{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", "/efi/EFI/Linux/active.efi"},
+			})).To(BeNil())
+		})
+	})
 	Describe("DeactivateDevices", Label("blkdeactivate"), func() {
 		It("calls blkdeactivat", func() {
 			el := elemental.NewElemental(config)
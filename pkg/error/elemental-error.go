@@ -16,10 +16,21 @@ limitations under the License.
 
 package error
 
+import (
+	"errors"
+	"fmt"
+)
+
 // ElementalError is our custom error to pass around exit codes in the error
 type ElementalError struct {
-	err  string
-	code int
+	err     string
+	code    int
+	wrapped error
+
+	// Context of the failure included in error reports
+	step    string
+	command string
+	output  string
 }
 
 func (e *ElementalError) Error() string {
@@ -30,6 +41,11 @@ func (e *ElementalError) ExitCode() int {
 	return e.code
 }
 
+// Unwrap returns the error wrapped by NewFromError, if any
+func (e *ElementalError) Unwrap() error {
+	return e.wrapped
+}
+
 // NewFromError generates an ElementalError from an existing error,
 // maintaining its error message
 func NewFromError(err error, code int) error {
@@ -41,10 +57,31 @@ func NewFromError(err error, code int) error {
 	if err.Error() != "" {
 		errorMsg = err.Error()
 	}
-	return &ElementalError{err: errorMsg, code: code}
+	return &ElementalError{err: errorMsg, code: code, wrapped: err}
 }
 
 // New generates an ElementalError from a string
 func New(err string, code int) error {
 	return &ElementalError{err: err, code: code}
 }
+
+// Name returns the name of the constant of the given exit code
+func Name(code int) string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+// WithContext sets the failing step and the last command run, including its output, to the
+// ElementalError within the given error. Context already set is not overwritten.
+func WithContext(err error, step, command, output string) error {
+	var eErr *ElementalError
+
+	if errors.As(err, &eErr) && eErr.step == "" && eErr.command == "" {
+		eErr.step = step
+		eErr.command = command
+		eErr.output = output
+	}
+	return err
+}
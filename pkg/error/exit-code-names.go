@@ -0,0 +1,85 @@
+// Code generated by docs/generate_docs.go; DO NOT EDIT.
+
+package error
+
+// codeNames maps exit codes to the name of their constant
+var codeNames = map[int]string{
+	10:  "CloseFile",
+	11:  "CommandRun",
+	12:  "CopyData",
+	13:  "CopyFile",
+	14:  "CosignWrongFlags",
+	15:  "CreateDir",
+	16:  "CreateFile",
+	17:  "CreateTempDir",
+	18:  "DumpSource",
+	19:  "GzipWriter",
+	20:  "IdentifySource",
+	21:  "MKFSCall",
+	22:  "NoPackagesForArch",
+	23:  "NoReposConfigured",
+	24:  "OpenFile",
+	25:  "OutFileExists",
+	26:  "ReadingBuildConfig",
+	27:  "ReadingBuildDiskConfig",
+	28:  "StatFile",
+	29:  "TarHeader",
+	30:  "TruncateFile",
+	31:  "ReadingRunConfig",
+	32:  "ReadingInstallUpgradeFlags",
+	33:  "ReadingSpecConfig",
+	34:  "MountStatePartition",
+	35:  "MountRecoveryPartition",
+	36:  "HookBeforeUpgrade",
+	37:  "HookBeforeUpgradeChroot",
+	38:  "HookAfterUpgrade",
+	39:  "HookAfterUpgradeChroot",
+	40:  "MoveFile",
+	41:  "Cleanup",
+	42:  "Reboot",
+	43:  "PowerOff",
+	44:  "LabelImage",
+	45:  "SetDefaultGrubEntry",
+	46:  "SelinuxRelabel",
+	47:  "InvalidTarget",
+	48:  "DeployImage",
+	49:  "InstallGrub",
+	50:  "HookBeforeInstall",
+	51:  "HookAfterInstall",
+	52:  "HookAfterInstallChroot",
+	53:  "DownloadFile",
+	54:  "MountPartitions",
+	55:  "DeactivatingDevices",
+	56:  "PartitioningDevice",
+	57:  "AlreadyInstalled",
+	58:  "RequiresRoot",
+	59:  "UnmountPartitions",
+	60:  "FormatPartitions",
+	61:  "HookBeforeReset",
+	62:  "HookAfterResetChroot",
+	63:  "HookAfterReset",
+	64:  "UnsupportedFlavor",
+	65:  "CloudInitRunStage",
+	66:  "UnpackImage",
+	67:  "ReadFile",
+	68:  "NoSourceProvided",
+	69:  "RemoveFile",
+	70:  "CalculateChecksum",
+	71:  "UnmountImage",
+	72:  "HookPostUpgrade",
+	73:  "HookPostReset",
+	74:  "HookPostInstall",
+	75:  "DeployImgTree",
+	76:  "CreateImgFromTree",
+	77:  "CopyFileImg",
+	78:  "SetGrubVariables",
+	79:  "FetchCmdlineInstallConfig",
+	80:  "AttachTargetImage",
+	81:  "NonReproducibleBuild",
+	82:  "CreateUKI",
+	83:  "SignEFI",
+	84:  "EFIBootEntries",
+	85:  "CreatePassiveUKI",
+	86:  "BackupActiveImage",
+	255: "Unknown",
+}
@@ -235,5 +235,29 @@ const CopyFileImg = 77
 // Error setting persistent GRUB variables
 const SetGrubVariables = 78
 
+// Error fetching the install configuration set in the kernel command line
+const FetchCmdlineInstallConfig = 79
+
+// Error attaching the target image file to a loop device
+const AttachTargetImage = 80
+
+// Error detecting a non reproducible build
+const NonReproducibleBuild = 81
+
+// Error creating a unified kernel image
+const CreateUKI = 82
+
+// Error signing EFI binaries with the Secure Boot keys
+const SignEFI = 83
+
+// Error managing the UEFI boot entries
+const EFIBootEntries = 84
+
+// Error creating the unified kernel image of the passive system
+const CreatePassiveUKI = 85
+
+// Error backing up the active image as the passive image
+const BackupActiveImage = 86
+
 // Unknown error
 const Unknown int = 255
@@ -0,0 +1,80 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package error
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Report is a machine readable report of a failure
+type Report struct {
+	Code          int      `json:"code"`
+	Name          string   `json:"name"`
+	Step          string   `json:"step,omitempty"`
+	Errors        []string `json:"errors"`
+	Command       string   `json:"command,omitempty"`
+	CommandOutput string   `json:"command-output,omitempty"`
+	CleanupErrors []string `json:"cleanup-errors,omitempty"`
+}
+
+// NewReport returns the report of the given error, the name is empty for errors without exit
+// code. Errors combined with the ones of a cleanup stack are reported separately, the first
+// one being the failure.
+func NewReport(err error) Report {
+	var eErr *ElementalError
+	var cleanupErrs []error
+
+	if mErr, ok := err.(*multierror.Error); ok && len(mErr.Errors) > 0 {
+		err = mErr.Errors[0]
+		cleanupErrs = mErr.Errors[1:]
+	}
+
+	// Errors without exit code make the process exit with 1
+	report := Report{Code: 1}
+	if errors.As(err, &eErr) {
+		report.Code = eErr.code
+		report.Name = Name(eErr.code)
+		report.Step = eErr.step
+		report.Command = eErr.command
+		report.CommandOutput = eErr.output
+	}
+
+	// Walk the error chain without repeating messages of wrapping errors
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if len(report.Errors) == 0 || report.Errors[len(report.Errors)-1] != e.Error() {
+			report.Errors = append(report.Errors, e.Error())
+		}
+		if mErr, ok := e.(*multierror.Error); ok {
+			// Errors of a failed cleanup stack without a previous failure
+			cleanupErrs = append(cleanupErrs, mErr.Errors...)
+			break
+		}
+	}
+
+	for _, e := range cleanupErrs {
+		report.CleanupErrors = append(report.CleanupErrors, e.Error())
+	}
+	return report
+}
+
+// JSON returns the report encoded in JSON
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
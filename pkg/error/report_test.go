@@ -0,0 +1,74 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package error_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+)
+
+var _ = Describe("Error report", Label("error", "report"), func() {
+	It("Names exit codes after their constant", func() {
+		Expect(elementalError.Name(elementalError.CommandRun)).To(Equal("CommandRun"))
+		Expect(elementalError.Name(9999)).To(Equal("9999"))
+	})
+	It("Reports errors without exit code", func() {
+		report := elementalError.NewReport(errors.New("plain failure"))
+		Expect(report.Code).To(Equal(1))
+		Expect(report.Name).To(BeEmpty())
+		Expect(report.Errors).To(Equal([]string{"plain failure"}))
+	})
+	It("Reports the context of the failure and the wrapped errors", func() {
+		cause := fmt.Errorf("formatting: %w", errors.New("mkfs failed"))
+		err := elementalError.NewFromError(cause, elementalError.MKFSCall)
+		err = elementalError.WithContext(err, "MKFSCall", "mkfs.ext4 /dev/sda2", "bad device")
+		// Context already set is kept
+		err = elementalError.WithContext(err, "Other", "other", "")
+
+		report := elementalError.NewReport(err)
+		Expect(report.Code).To(Equal(elementalError.MKFSCall))
+		Expect(report.Name).To(Equal("MKFSCall"))
+		Expect(report.Step).To(Equal("MKFSCall"))
+		Expect(report.Command).To(Equal("mkfs.ext4 /dev/sda2"))
+		Expect(report.CommandOutput).To(Equal("bad device"))
+		Expect(report.Errors).To(Equal([]string{"formatting: mkfs failed", "mkfs failed"}))
+		Expect(report.CleanupErrors).To(BeEmpty())
+	})
+	It("Reports cleanup errors separately", func() {
+		failure := elementalError.New("partitioning failed", elementalError.PartitioningDevice)
+		err := multierror.Append(failure, errors.New("umount failed"), errors.New("detach failed"))
+
+		report := elementalError.NewReport(err)
+		Expect(report.Code).To(Equal(elementalError.PartitioningDevice))
+		Expect(report.Errors).To(Equal([]string{"partitioning failed"}))
+		Expect(report.CleanupErrors).To(Equal([]string{"umount failed", "detach failed"}))
+
+		data, jErr := report.JSON()
+		Expect(jErr).ToNot(HaveOccurred())
+		decoded := map[string]interface{}{}
+		Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+		Expect(decoded["name"]).To(Equal("PartitioningDevice"))
+		Expect(decoded).To(HaveKey("cleanup-errors"))
+	})
+})
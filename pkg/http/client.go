@@ -55,6 +55,7 @@ Loop:
 	for {
 		select {
 		case <-t.C:
+			log.Emit(v1.NewProgressEvent(url, v1.ProgressUnitBytes, resp.BytesComplete(), resp.Size()))
 			log.Debugf("  transferred %v / %v bytes (%.2f%%)\n",
 				resp.BytesComplete(),
 				resp.Size,
@@ -62,6 +63,7 @@ Loop:
 
 		case <-resp.Done:
 			// download is complete
+			log.Emit(v1.NewProgressEvent(url, v1.ProgressUnitBytes, resp.BytesComplete(), resp.Size()))
 			break Loop
 		}
 	}
@@ -17,7 +17,11 @@ limitations under the License.
 package live
 
 import (
+	"bytes"
 	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
 
 	"github.com/rancher/elemental-cli/pkg/constants"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
@@ -37,16 +41,88 @@ const (
 	isoHybridMBR  = isoLoaderPath + "/boot_hybrid.img"
 	isoBootFile   = isoLoaderPath + "/eltorito.img"
 
+	// Kernel command line arguments shared by all live boot methods
+	liveCmdline = "rd.live.dir=/ rd.live.squashimg=" + constants.ISORootFile +
+		" console=tty1 console=ttyS0 rd.cos.disable"
+
+	// Network boot fetches the squashfs image from the given URL, network is configured by DHCP.
+	// There is no live medium, hence no cloud-init configuration to load from it.
+	netbootCmdline = "root=live:%s ip=dhcp rd.neednet=1 " + liveCmdline
+
+	// Rendered with text/template, see IPXEScript. A zero timeout boots the default entry
+	// right away, a negative one waits for the user to choose.
+	ipxeTemplate = `#!ipxe
+set base-url {{.BaseURL}}
+{{- if eq .Menu.Timeout 0}}
+goto entry{{.Menu.Default}}
+{{- else}}
+menu
+{{- range $i, $e := .Entries}}
+item entry{{$i}} {{$e.Title}}
+{{- end}}
+choose --default entry{{.Menu.Default}}{{if gt .Menu.Timeout 0}} --timeout {{.Menu.Timeout}}000{{end}} target && goto ${target}
+{{- end}}
+{{- range $i, $e := .Entries}}
+
+:entry{{$i}}
+echo Loading kernel...
+kernel ${base-url}/{{$.Kernel}} {{$.Cmdline}}{{with $e.Cmdline}} {{.}}{{end}} initrd={{$.Initrd}}
+echo Loading initrd...
+initrd ${base-url}/{{$.Initrd}}
+boot
+{{- end}}
+`
+
+	// Rendered with text/template, see GrubNetbootCfg
+	grubNetbootTemplate = `set default={{.Menu.Default}}
+set timeout={{.Menu.Timeout}}
+set timeout_style=menu
+{{- if .Menu.Serial}}
+serial {{.Menu.Serial}}
+{{- end}}
+{{- if .Menu.Terminal}}
+terminal_input {{.Menu.Terminal}}
+terminal_output {{.Menu.Terminal}}
+{{- end}}
+{{- range .Entries}}
+
+menuentry "{{.Title}}" --class os --unrestricted {
+	echo Loading kernel...
+	linux {{$.Kernel}} {{$.Cmdline}}{{with .Cmdline}} {{.}}{{end}}
+	echo Loading initrd...
+	initrd {{$.Initrd}}
+}
+{{- end}}
+`
+
+	// Runs the installation with the configuration set in the kernel command line, if any
+	autoInstallCloudConfig = `name: "Automated installation"
+stages:
+  network:
+    - name: "Install using the embedded configuration"
+      if: grep -q "` + constants.InstallConfigCmdlineArg + `=" /proc/cmdline
+      commands:
+        - elemental install --from-cmdline
+`
+
 	//TODO use some identifer known to be unique
 	grubEfiCfg = "search --no-floppy --file --set=root " + constants.ISOKernelPath +
 		"\nset prefix=($root)" + grubPrefixDir +
 		"\nconfigfile $prefix/" + grubCfg
 
 	// TODO not convinced having such a config here is the best idea
+	// Rendered with text/template, see GreenLiveBootLoader.grubCfg
 	grubCfgTemplate = `search --no-floppy --file --set=root /boot/kernel
-	set default=0
-	set timeout=10
+	set default={{.Menu.Default}}
+	set timeout={{.Menu.Timeout}}
 	set timeout_style=menu
+{{- if .Menu.Serial}}
+	serial {{.Menu.Serial}}
+{{- end}}
+{{- if .Menu.Terminal}}
+	terminal_input {{.Menu.Terminal}}
+	terminal_output {{.Menu.Terminal}}
+{{- end}}
 	set linux=linux
 	set initrd=initrd
 	if [ "${grub_cpu}" = "x86_64" -o "${grub_cpu}" = "i386" -o "${grub_cpu}" = "arm64" ];then
@@ -60,13 +136,15 @@ const (
 	if [ "${grub_platform}" = "efi" ]; then
 		echo "Please press 't' to show the boot menu on this console"
 	fi
+{{- range .Entries}}
 
-	menuentry "%s" --class os --unrestricted {
+	menuentry "{{.Title}}" --class os --unrestricted {
 		echo Loading kernel...
-		$linux ($root)` + constants.ISOKernelPath + ` cdroot root=live:CDLABEL=%s rd.live.dir=/ rd.live.squashimg=rootfs.squashfs console=tty1 console=ttyS0 rd.cos.disable cos.setup=` + constants.ISOCloudInitPath + `
+		$linux ($root)` + constants.ISOKernelPath + ` {{$.Cmdline}}{{with .Cmdline}} {{.}}{{end}}
 		echo Loading initrd...
 		$initrd ($root)` + constants.ISOInitrdPath + `
-	}                                                                               
+	}
+{{- end}}
 																					
 	if [ "${grub_platform}" = "efi" ]; then                                         
 		hiddenentry "Text mode" --hotkey "t" {                                      
@@ -76,6 +154,112 @@ const (
 	fi`
 )
 
+// AutoInstallCloudConfig returns the cloud-config running the installation with the
+// configuration set in the kernel command line
+func AutoInstallCloudConfig() string {
+	return autoInstallCloudConfig
+}
+
+// menuEntries returns the live boot menu entries of the given LiveISO, including the
+// automated installation entry if enabled
+func menuEntries(spec *v1.LiveISO) []v1.LiveMenuEntry {
+	entries := append([]v1.LiveMenuEntry{}, spec.BootMenu.Entries...)
+	if len(entries) == 0 {
+		entries = []v1.LiveMenuEntry{{Title: spec.GrubEntry}}
+	}
+	if spec.AutoInstall {
+		entries = append(entries, v1.LiveMenuEntry{
+			Title:   "Install " + spec.GrubEntry,
+			Cmdline: fmt.Sprintf("%s=%s", constants.InstallConfigCmdlineArg, constants.ISOInstallConfigPath),
+		})
+	}
+	return entries
+}
+
+// isoCmdline returns the kernel command line to boot the live system from the ISO
+func isoCmdline(spec *v1.LiveISO) string {
+	return fmt.Sprintf("cdroot root=live:CDLABEL=%s %s cos.setup=%s", spec.Label, liveCmdline, constants.ISOCloudInitPath)
+}
+
+// DefaultCmdline returns the kernel command line of the default live boot menu entry
+func DefaultCmdline(spec *v1.LiveISO) string {
+	entries := menuEntries(spec)
+	entry := entries[0]
+	if spec.BootMenu.Default > 0 && spec.BootMenu.Default < len(entries) {
+		entry = entries[spec.BootMenu.Default]
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s", isoCmdline(spec), entry.Cmdline))
+}
+
+// netbootMenu returns the boot menu of the network boot configurations. The automated
+// installation entry is left out, its configuration is only available on the live medium.
+func netbootMenu(spec *v1.LiveISO) (v1.LiveBootMenu, []v1.LiveMenuEntry) {
+	netSpec := *spec
+	netSpec.AutoInstall = false
+	entries := menuEntries(&netSpec)
+	menu := spec.BootMenu
+	if menu.Default < 0 || menu.Default >= len(entries) {
+		menu.Default = 0
+	}
+	return menu, entries
+}
+
+// renderNetbootCfg renders the given network boot configuration template for the netboot
+// artifacts of the given name
+func renderNetbootCfg(spec *v1.LiveISO, name, tmplName, tmplText, baseURL, kernel, initrd string) (string, error) {
+	menu, entries := netbootMenu(spec)
+	squashfs := fmt.Sprintf("%s/%s%s", strings.TrimSuffix(spec.NetbootURL, "/"), name, constants.NetbootSquashfsSuffix)
+
+	tmpl, err := template.New(tmplName).Parse(tmplText)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, struct {
+		Menu    v1.LiveBootMenu
+		Entries []v1.LiveMenuEntry
+		BaseURL string
+		Kernel  string
+		Initrd  string
+		Cmdline string
+	}{
+		Menu:    menu,
+		Entries: entries,
+		BaseURL: baseURL,
+		Kernel:  kernel,
+		Initrd:  initrd,
+		Cmdline: fmt.Sprintf(netbootCmdline, squashfs),
+	})
+	return out.String(), err
+}
+
+// IPXEScript returns an iPXE script booting the netboot artifacts of the given name published
+// at the netboot URL of the given LiveISO, with the entries, timeout and default of its boot menu
+func IPXEScript(spec *v1.LiveISO, name string) (string, error) {
+	return renderNetbootCfg(
+		spec, name, "ipxe", ipxeTemplate, strings.TrimSuffix(spec.NetbootURL, "/"),
+		name+constants.NetbootKernelSuffix, name+constants.NetbootInitrdSuffix,
+	)
+}
+
+// GrubNetbootCfg returns a grub configuration booting the netboot artifacts of the given name
+// published at the netboot URL of the given LiveISO, with the entries, timeout and default of
+// its boot menu. Only the protocols supported by grub are accepted.
+func GrubNetbootCfg(spec *v1.LiveISO, name string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(spec.NetbootURL, "/"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "tftp" {
+		return "", fmt.Errorf("grub does not support network boot over %s", u.Scheme)
+	}
+	grubPath := fmt.Sprintf("(%s,%s)%s/%s", u.Scheme, u.Host, u.Path, name)
+	return renderNetbootCfg(
+		spec, name, "grub-netboot", grubNetbootTemplate, u.String(),
+		grubPath+constants.NetbootKernelSuffix, grubPath+constants.NetbootInitrdSuffix,
+	)
+}
+
 func XorrisoBooloaderArgs(root, efiImg, firmware string) []string {
 	switch firmware {
 	case v1.EFI:
@@ -17,10 +17,11 @@ limitations under the License.
 package live
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
-
 	"strings"
+	"text/template"
 
 	"github.com/rancher/elemental-cli/pkg/constants"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
@@ -84,6 +85,16 @@ func (g *GreenLiveBootLoader) PrepareEFI(rootDir, uefiDir string) error {
 }
 
 func (g *GreenLiveBootLoader) copyEfiFiles(uefiDir, shimImg, mokManager, grubImg, efiImg string) error {
+	// Boot grub directly if the custom Secure Boot keys are enrolled
+	if g.buildCfg.SecureBoot.NoShim {
+		target := filepath.Join(uefiDir, efiBootPath, efiImg)
+		err := utils.CopyFile(g.buildCfg.Fs, grubImg, target)
+		if err != nil {
+			return err
+		}
+		return utils.SignEFI(&g.buildCfg.Config, target)
+	}
+
 	err := utils.CopyFile(g.buildCfg.Fs, shimImg, filepath.Join(uefiDir, efiBootPath, efiImg))
 	if err != nil {
 		return err
@@ -92,6 +103,10 @@ func (g *GreenLiveBootLoader) copyEfiFiles(uefiDir, shimImg, mokManager, grubImg
 	if err != nil {
 		return err
 	}
+	err = utils.SignEFI(&g.buildCfg.Config, filepath.Join(uefiDir, efiBootPath, filepath.Base(grubImg)))
+	if err != nil {
+		return err
+	}
 	return utils.CopyFile(g.buildCfg.Fs, mokManager, filepath.Join(uefiDir, efiBootPath))
 }
 
@@ -138,9 +153,13 @@ func (g *GreenLiveBootLoader) PrepareISO(rootDir, imageDir string) error {
 	}
 
 	// Write grub.cfg file
+	cfg, err := g.grubCfg()
+	if err != nil {
+		return err
+	}
 	err = g.buildCfg.Fs.WriteFile(
 		filepath.Join(imageDir, grubPrefixDir, grubCfg),
-		[]byte(fmt.Sprintf(grubCfgTemplate, g.spec.GrubEntry, g.spec.Label)),
+		[]byte(cfg),
 		constants.FilePerm,
 	)
 	if err != nil {
@@ -155,6 +174,28 @@ func (g *GreenLiveBootLoader) PrepareISO(rootDir, imageDir string) error {
 	return nil
 }
 
+// grubCfg renders the live grub configuration according to the LiveISO boot menu, including
+// an automated installation entry if enabled
+func (g *GreenLiveBootLoader) grubCfg() (string, error) {
+	entries := menuEntries(g.spec)
+
+	tmpl, err := template.New(grubCfg).Parse(grubCfgTemplate)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, struct {
+		Menu    v1.LiveBootMenu
+		Entries []v1.LiveMenuEntry
+		Cmdline string
+	}{
+		Menu:    g.spec.BootMenu,
+		Entries: entries,
+		Cmdline: isoCmdline(g.spec),
+	})
+	return out.String(), err
+}
+
 func (g *GreenLiveBootLoader) BuildEltoritoImg(rootDir string) (string, error) {
 	const (
 		grubBiosTarget  = "i386-pc"
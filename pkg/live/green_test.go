@@ -178,6 +178,29 @@ var _ = Describe("GreenLiveBootloader", Label("green", "live"), func() {
 		exists, _ := utils.Exists(fs, filepath.Join(uefiDir, "EFI/BOOT/grub.cfg"))
 		Expect(exists).To(BeTrue())
 	})
+	It("Signs grub and boots it without shim", Label("secureboot"), func() {
+		cfg.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt", NoShim: true}
+		green := live.NewGreenLiveBootLoader(cfg, iso)
+		Expect(green.PrepareEFI(rootDir, uefiDir)).To(Succeed())
+
+		bootImg := filepath.Join(uefiDir, "EFI/BOOT/bootx64.efi")
+		Expect(runner.CmdsMatch([][]string{
+			{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", bootImg, bootImg},
+		})).To(BeNil())
+		Expect(utils.Exists(fs, filepath.Join(uefiDir, "EFI/BOOT/MokManager.efi"))).To(BeFalse())
+		Expect(utils.Exists(fs, filepath.Join(uefiDir, "EFI/BOOT/grub.cfg"))).To(BeTrue())
+	})
+	It("Signs grub keeping shim", Label("secureboot"), func() {
+		cfg.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt"}
+		green := live.NewGreenLiveBootLoader(cfg, iso)
+		Expect(green.PrepareEFI(rootDir, uefiDir)).To(Succeed())
+
+		grubImg := filepath.Join(uefiDir, "EFI/BOOT/grub.efi")
+		Expect(runner.CmdsMatch([][]string{
+			{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", grubImg, grubImg},
+		})).To(BeNil())
+		Expect(utils.Exists(fs, filepath.Join(uefiDir, "EFI/BOOT/MokManager.efi"))).To(BeTrue())
+	})
 	It("Fails to copy the EFI image binaries if there is no shim", func() {
 		// Missing shim image
 		err := fs.RemoveAll(filepath.Join(rootDir, "/usr/share/efi/x86_64"))
@@ -299,4 +322,42 @@ var _ = Describe("GreenLiveBootloader", Label("green", "live"), func() {
 		exists, _ = utils.Exists(fs, filepath.Join(imageDir, "boot/grub2/grub.cfg"))
 		Expect(exists).To(BeTrue())
 	})
+	It("Renders the configured boot menu in the ISO grub configuration", func() {
+		iso.BootMenu = v1.LiveBootMenu{
+			Timeout:  3,
+			Default:  1,
+			Terminal: "serial console",
+			Serial:   "--unit=0 --speed=115200",
+			Entries: []v1.LiveMenuEntry{
+				{Title: "Live"},
+				{Title: "Live (serial console)", Cmdline: "console=ttyS0,115200 rd.debug"},
+			},
+		}
+		green := live.NewGreenLiveBootLoader(cfg, iso)
+		err := green.PrepareISO(rootDir, imageDir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		grubCfg, err := fs.ReadFile(filepath.Join(imageDir, "boot/grub2/grub.cfg"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(grubCfg)).To(ContainSubstring("set default=1\n\tset timeout=3\n"))
+		Expect(string(grubCfg)).To(ContainSubstring("serial --unit=0 --speed=115200\n"))
+		Expect(string(grubCfg)).To(ContainSubstring("terminal_input serial console\n\tterminal_output serial console\n"))
+		Expect(string(grubCfg)).To(ContainSubstring("menuentry \"Live\""))
+		Expect(string(grubCfg)).To(ContainSubstring("menuentry \"Live (serial console)\""))
+		Expect(string(grubCfg)).To(ContainSubstring("cos.setup=/run/initramfs/live/iso-config console=ttyS0,115200 rd.debug\n"))
+		Expect(string(grubCfg)).NotTo(ContainSubstring(fmt.Sprintf("menuentry \"%s\"", iso.GrubEntry)))
+	})
+	It("Adds an automated installation entry to the ISO grub configuration", func() {
+		iso.AutoInstall = true
+		iso.InstallConfig = "/some/install.yaml"
+		green := live.NewGreenLiveBootLoader(cfg, iso)
+		err := green.PrepareISO(rootDir, imageDir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		grubCfg, err := fs.ReadFile(filepath.Join(imageDir, "boot/grub2/grub.cfg"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(grubCfg)).To(ContainSubstring(fmt.Sprintf("menuentry \"%s\"", iso.GrubEntry)))
+		Expect(string(grubCfg)).To(ContainSubstring(fmt.Sprintf("menuentry \"Install %s\"", iso.GrubEntry)))
+		Expect(string(grubCfg)).To(ContainSubstring("elemental.install.config=/run/initramfs/live/install-config.yaml"))
+	})
 })
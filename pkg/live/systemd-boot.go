@@ -0,0 +1,147 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package live
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/elemental-cli/pkg/constants"
+	"github.com/rancher/elemental-cli/pkg/elemental"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+	"github.com/rancher/elemental-cli/pkg/utils"
+)
+
+const (
+	systemdBootPath     = "/usr/lib/systemd/boot/efi"
+	systemdBootX86      = "systemd-bootx64.efi"
+	systemdBootArm64    = "systemd-bootaa64.efi"
+	loaderConf          = "/loader/loader.conf"
+	loaderEntriesDir    = "/loader/entries"
+	loaderEntryTemplate = "title %s\nlinux %s\ninitrd %s\noptions %s\n"
+)
+
+// SystemdBootLiveBootLoader prepares EFI only live ISOs booting with systemd-boot
+type SystemdBootLiveBootLoader struct {
+	buildCfg *v1.BuildConfig
+	spec     *v1.LiveISO
+}
+
+func NewSystemdBootLiveBootLoader(cfg *v1.BuildConfig, spec *v1.LiveISO) *SystemdBootLiveBootLoader {
+	return &SystemdBootLiveBootLoader{buildCfg: cfg, spec: spec}
+}
+
+// PrepareEFI copies systemd-boot, kernel and initrd into the EFI image root and writes
+// the loader configuration. systemd-boot can only load kernels from its own partition.
+func (s *SystemdBootLiveBootLoader) PrepareEFI(rootDir, uefiDir string) error {
+	var loader, efiImg string
+
+	switch s.buildCfg.Platform.Arch {
+	case constants.ArchAmd64, constants.Archx86:
+		loader, efiImg = systemdBootX86, efiImgX86
+	case constants.ArchArm64:
+		loader, efiImg = systemdBootArm64, efiImgArm64
+	default:
+		return fmt.Errorf("Not supported architecture: %v", s.buildCfg.Platform.Arch)
+	}
+
+	err := utils.MkdirAll(s.buildCfg.Fs, filepath.Join(uefiDir, efiBootPath), constants.DirPerm)
+	if err != nil {
+		return err
+	}
+	err = utils.CopyFile(
+		s.buildCfg.Fs,
+		filepath.Join(rootDir, systemdBootPath, loader),
+		filepath.Join(uefiDir, efiBootPath, efiImg),
+	)
+	if err != nil {
+		return err
+	}
+	err = utils.SignEFI(&s.buildCfg.Config, filepath.Join(uefiDir, efiBootPath, efiImg))
+	if err != nil {
+		return err
+	}
+
+	kernel, initrd, err := elemental.NewElemental(&s.buildCfg.Config).FindKernelInitrd(rootDir)
+	if err != nil {
+		return err
+	}
+	err = utils.MkdirAll(s.buildCfg.Fs, filepath.Join(uefiDir, filepath.Dir(constants.ISOKernelPath)), constants.DirPerm)
+	if err != nil {
+		return err
+	}
+	err = utils.CopyFile(s.buildCfg.Fs, kernel, filepath.Join(uefiDir, constants.ISOKernelPath))
+	if err != nil {
+		return err
+	}
+	err = utils.CopyFile(s.buildCfg.Fs, initrd, filepath.Join(uefiDir, constants.ISOInitrdPath))
+	if err != nil {
+		return err
+	}
+
+	return s.writeLoaderConfig(uefiDir)
+}
+
+// PrepareISO includes the EFI contents in the ISO root tree, only EFI firmware is supported
+func (s *SystemdBootLiveBootLoader) PrepareISO(rootDir, isoDir string) error {
+	if s.spec.Firmware != v1.EFI {
+		return fmt.Errorf("%s does not support %s firmware", constants.LiveBootloaderSystemdBoot, s.spec.Firmware)
+	}
+	return s.PrepareEFI(rootDir, isoDir)
+}
+
+// writeLoaderConfig writes a loader entry for each live boot menu entry
+func (s *SystemdBootLiveBootLoader) writeLoaderConfig(uefiDir string) error {
+	menu := s.spec.BootMenu
+	if menu.Terminal != "" || menu.Serial != "" {
+		s.buildCfg.Logger.Warnf("Terminal settings are not supported by %s, ignoring them", constants.LiveBootloaderSystemdBoot)
+	}
+
+	err := utils.MkdirAll(s.buildCfg.Fs, filepath.Join(uefiDir, loaderEntriesDir), constants.DirPerm)
+	if err != nil {
+		return err
+	}
+
+	var defEntry string
+	for i, entry := range menuEntries(s.spec) {
+		name := fmt.Sprintf("live-%d.conf", i)
+		if i == menu.Default {
+			defEntry = name
+		}
+		options := strings.TrimSpace(fmt.Sprintf("%s %s", isoCmdline(s.spec), entry.Cmdline))
+		err = s.buildCfg.Fs.WriteFile(
+			filepath.Join(uefiDir, loaderEntriesDir, name),
+			[]byte(fmt.Sprintf(loaderEntryTemplate, entry.Title, constants.ISOKernelPath, constants.ISOInitrdPath, options)),
+			constants.FilePerm,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A negative timeout waits for the user selection
+	timeout := fmt.Sprintf("%d", menu.Timeout)
+	if menu.Timeout < 0 {
+		timeout = "menu-force"
+	}
+	return s.buildCfg.Fs.WriteFile(
+		filepath.Join(uefiDir, loaderConf),
+		[]byte(fmt.Sprintf("timeout %s\ndefault %s\n", timeout, defEntry)),
+		constants.FilePerm,
+	)
+}
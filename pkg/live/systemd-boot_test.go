@@ -0,0 +1,158 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package live_test
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs"
+	"github.com/twpayne/go-vfs/vfst"
+
+	"github.com/rancher/elemental-cli/pkg/config"
+	"github.com/rancher/elemental-cli/pkg/constants"
+	"github.com/rancher/elemental-cli/pkg/live"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+	"github.com/rancher/elemental-cli/pkg/utils"
+	v1mock "github.com/rancher/elemental-cli/tests/mocks"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SystemdBootLiveBootLoader", Label("systemd-boot", "live"), func() {
+	var cfg *v1.BuildConfig
+	var fs vfs.FS
+	var cleanup func()
+	var memLog *bytes.Buffer
+	var iso *v1.LiveISO
+	var rootDir, imageDir, uefiDir string
+	BeforeEach(func() {
+		var err error
+		memLog = &bytes.Buffer{}
+		logger := v1.NewBufferLogger(memLog)
+		logger.SetLevel(logrus.DebugLevel)
+		fs, cleanup, _ = vfst.NewTestFS(map[string]interface{}{})
+		cfg = config.NewBuildConfig(
+			config.WithFs(fs),
+			config.WithRunner(v1mock.NewFakeRunner()),
+			config.WithLogger(logger),
+			config.WithMounter(v1mock.NewErrorMounter()),
+			config.WithPlatform("linux/amd64"),
+		)
+		iso = config.NewISO()
+		iso.Bootloader = constants.LiveBootloaderSystemdBoot
+
+		rootDir, err = utils.TempDir(fs, "", "rootDir")
+		Expect(err).ShouldNot(HaveOccurred())
+		imageDir, err = utils.TempDir(fs, "", "imageDir")
+		Expect(err).ShouldNot(HaveOccurred())
+		uefiDir, err = utils.TempDir(fs, "", "uefiDir")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, dir := range []string{"/usr/lib/systemd/boot/efi", "/boot"} {
+			Expect(utils.MkdirAll(fs, filepath.Join(rootDir, dir), constants.DirPerm)).To(Succeed())
+		}
+		for _, f := range []string{
+			"/usr/lib/systemd/boot/efi/systemd-bootx64.efi",
+			"/usr/lib/systemd/boot/efi/systemd-bootaa64.efi",
+			"/boot/vmlinuz", "/boot/initrd",
+		} {
+			Expect(fs.WriteFile(filepath.Join(rootDir, f), []byte(f), constants.FilePerm)).To(Succeed())
+		}
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+	It("Copies systemd-boot, kernel and initrd and writes loader entries", func() {
+		iso.AutoInstall = true
+		iso.InstallConfig = "/some/install.yaml"
+		iso.BootMenu.Timeout = 5
+		iso.BootMenu.Default = 1
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareEFI(rootDir, uefiDir)).To(Succeed())
+
+		data, err := fs.ReadFile(filepath.Join(uefiDir, "EFI/BOOT/bootx64.efi"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(Equal("/usr/lib/systemd/boot/efi/systemd-bootx64.efi"))
+		data, err = fs.ReadFile(filepath.Join(uefiDir, constants.ISOKernelPath))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(Equal("/boot/vmlinuz"))
+		Expect(utils.Exists(fs, filepath.Join(uefiDir, constants.ISOInitrdPath))).To(BeTrue())
+
+		data, err = fs.ReadFile(filepath.Join(uefiDir, "loader/loader.conf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(Equal("timeout 5\ndefault live-1.conf\n"))
+
+		data, err = fs.ReadFile(filepath.Join(uefiDir, "loader/entries/live-0.conf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("title cOS\n"))
+		Expect(string(data)).To(ContainSubstring("linux /boot/kernel\ninitrd /boot/initrd\n"))
+		Expect(string(data)).To(ContainSubstring("options cdroot root=live:CDLABEL=COS_LIVE rd.live.dir=/"))
+
+		data, err = fs.ReadFile(filepath.Join(uefiDir, "loader/entries/live-1.conf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("title Install cOS\n"))
+		Expect(string(data)).To(ContainSubstring("elemental.install.config=/run/initramfs/live/install-config.yaml\n"))
+	})
+	It("Copies the arm64 systemd-boot binary", func() {
+		platform, err := v1.NewPlatformFromArch(constants.ArchArm64)
+		Expect(err).ShouldNot(HaveOccurred())
+		cfg.Platform = platform
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareEFI(rootDir, uefiDir)).To(Succeed())
+		data, err := fs.ReadFile(filepath.Join(uefiDir, "EFI/BOOT/bootaa64.efi"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(Equal("/usr/lib/systemd/boot/efi/systemd-bootaa64.efi"))
+	})
+	It("Waits for the user selection on negative timeouts", func() {
+		iso.BootMenu.Timeout = -1
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareEFI(rootDir, uefiDir)).To(Succeed())
+		data, err := fs.ReadFile(filepath.Join(uefiDir, "loader/loader.conf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("timeout menu-force\n"))
+	})
+	It("Fails if systemd-boot is not found in the rootfs", func() {
+		Expect(fs.RemoveAll(filepath.Join(rootDir, "/usr/lib/systemd"))).To(Succeed())
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareEFI(rootDir, uefiDir)).NotTo(Succeed())
+	})
+	It("Fails if the kernel is not found in the rootfs", func() {
+		Expect(fs.Remove(filepath.Join(rootDir, "/boot/vmlinuz"))).To(Succeed())
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareEFI(rootDir, uefiDir)).NotTo(Succeed())
+	})
+	It("Fails to prepare ISO root for BIOS firmware", func() {
+		iso.Firmware = v1.BIOS
+
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareISO(rootDir, imageDir)).NotTo(Succeed())
+	})
+	It("Prepares ISO root with EFI bootloader files", func() {
+		sdBoot := live.NewSystemdBootLiveBootLoader(cfg, iso)
+		Expect(sdBoot.PrepareISO(rootDir, imageDir)).To(Succeed())
+		Expect(utils.Exists(fs, filepath.Join(imageDir, "EFI/BOOT/bootx64.efi"))).To(BeTrue())
+		Expect(utils.Exists(fs, filepath.Join(imageDir, "loader/loader.conf"))).To(BeTrue())
+	})
+})
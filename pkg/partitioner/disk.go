@@ -17,13 +17,17 @@ limitations under the License.
 package partitioner
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/rancher/elemental-cli/pkg/constants"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 	"github.com/rancher/elemental-cli/pkg/utils"
 	"github.com/twpayne/go-vfs"
@@ -33,10 +37,16 @@ const (
 	partitionTries = 10
 	// Parted warning substring for expanded disks without fixing GPT headers
 	partedWarn = "Not all of the space available"
+	// Percentage of the used space that is required to remain free after shrinking a filesystem
+	shrinkSafetyMargin = 10
 )
 
 var unallocatedRegexp = regexp.MustCompile(partedWarn)
 
+// ErrShrinkRefused is returned when a partition is not shrunk because it is not safe to do so,
+// no change is applied to the disk in that case
+var ErrShrinkRefused = errors.New("refused to shrink partition")
+
 type Disk struct {
 	device  string
 	sectorS uint
@@ -155,6 +165,9 @@ func (dev *Disk) Reload() error {
 		return err
 	}
 	partitions := pc.GetPartitions(prnt)
+	// Partitions are kept in disk order, so the last one is the one at the end of the
+	// disk even if it has been moved there
+	sort.SliceStable(partitions, func(i, j int) bool { return partitions[i].StartS < partitions[j].StartS })
 	dev.sectorS = sectorS
 	dev.lastS = lastS
 	dev.parts = partitions
@@ -225,6 +238,65 @@ func (dev *Disk) NewPartitionTable(label string) (string, error) {
 	return out, nil
 }
 
+// RemovePartitionsExcept deletes all partitions of the disk except the ones matching any of the
+// given references. A reference can be a partition number, a partition label, a filesystem label or
+// a partition GUID. It fails without applying any change if a reference matches no partition.
+func (dev *Disk) RemovePartitionsExcept(refs ...string) (string, error) {
+	pc := NewPartedCall(dev.String(), dev.runner)
+
+	err := dev.Reload()
+	if err != nil {
+		dev.logger.Errorf("Failed analyzing disk: %v\n", err)
+		return "", err
+	}
+
+	pc.SetPartitionTableLabel(dev.label)
+
+	// Filesystem labels and partition GUIDs are not part of the parted output
+	sysParts, err := utils.GetAllPartitions()
+	if err != nil {
+		return "", err
+	}
+
+	keep := map[int]bool{}
+	for _, ref := range refs {
+		found := false
+		for _, part := range dev.parts {
+			pDev := dev.partitionDevicePath(part.Number)
+			if ref == strconv.Itoa(part.Number) || ref == part.PLabel {
+				keep[part.Number] = true
+				found = true
+				continue
+			}
+			for _, sysPart := range sysParts {
+				if sysPart.Path != pDev {
+					continue
+				}
+				if ref == sysPart.FilesystemLabel || strings.EqualFold(ref, sysPart.UUID) {
+					keep[part.Number] = true
+					found = true
+				}
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("no partition in %s matches '%s'", dev.device, ref)
+		}
+	}
+
+	for _, part := range dev.parts {
+		if !keep[part.Number] {
+			dev.logger.Debugf("Removing partition %d of device %s", part.Number, dev.device)
+			pc.DeletePartition(part.Number)
+		}
+	}
+	out, err := pc.WriteChanges()
+	if err != nil {
+		dev.logger.Errorf("Failed removing partitions: %v", err)
+		return out, err
+	}
+	return out, dev.Reload()
+}
+
 // AddPartition adds a partition. Size is expressed in MiB here
 // Size is expressed in MiB here
 func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags ...string) (int, error) {
@@ -241,28 +313,21 @@ func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags
 
 	pc.SetPartitionTableLabel(dev.label)
 
-	var partNum int
-	var startS uint
-	if len(dev.parts) > 0 {
-		lastP := len(dev.parts) - 1
-		partNum = dev.parts[lastP].Number
-		startS = dev.parts[lastP].StartS + dev.parts[lastP].SizeS
-	} else {
-		//First partition is aligned at 1MiB
-		startS = 1024 * 1024 / dev.sectorS
+	sizeS := MiBToSectors(size, dev.sectorS)
+	gap, err := dev.findFreeGap(sizeS)
+	if err != nil {
+		return 0, err
 	}
-
-	size = MiBToSectors(size, dev.sectorS)
-	freeS := dev.computeFreeSpace()
-	if size > freeS {
-		return 0, fmt.Errorf("not enough free space in disk. Required: %d sectors; Available %d sectors", size, freeS)
+	// A partition growing up to a gap which is not at the end of the disk requires an explicit size
+	if sizeS == 0 && gap.endS != dev.lastS {
+		sizeS = gap.endS - gap.startS + 1
 	}
 
-	partNum++
+	partNum := dev.nextPartitionNumber()
 	var part = Partition{
 		Number:     partNum,
-		StartS:     startS,
-		SizeS:      size,
+		StartS:     gap.startS,
+		SizeS:      sizeS,
 		PLabel:     pLabel,
 		FileSystem: fileSystem,
 	}
@@ -288,13 +353,113 @@ func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags
 	return partNum, nil
 }
 
-func (dev Disk) FormatPartition(partNum int, fileSystem string, label string) (string, error) {
+// freeGap is an unallocated region of the disk, start and end sectors are included
+type freeGap struct {
+	startS uint
+	endS   uint
+}
+
+// freeGaps returns the unallocated regions of the disk sorted by their start sector. All gaps
+// start at a 1MiB aligned sector.
+func (dev Disk) freeGaps() []freeGap {
+	var gaps []freeGap
+
+	alignS := 1024 * 1024 / dev.sectorS
+	parts := make([]Partition, len(dev.parts))
+	copy(parts, dev.parts)
+	sort.Slice(parts, func(i, j int) bool { return parts[i].StartS < parts[j].StartS })
+
+	// First partition is aligned at 1MiB
+	startS := alignS
+	for _, part := range parts {
+		if part.StartS > startS {
+			gaps = append(gaps, freeGap{startS: startS, endS: part.StartS - 1})
+		}
+		if endS := part.StartS + part.SizeS; endS > startS {
+			startS = (endS + alignS - 1) / alignS * alignS
+		}
+	}
+	if dev.lastS > startS {
+		gaps = append(gaps, freeGap{startS: startS, endS: dev.lastS})
+	}
+	return gaps
+}
+
+// findFreeGap returns the first gap in disk fitting the given size in sectors. A zero size
+// returns the largest gap.
+func (dev Disk) findFreeGap(sizeS uint) (freeGap, error) {
+	var largest freeGap
+	var largestS uint
+
+	for _, gap := range dev.freeGaps() {
+		gapS := gap.endS - gap.startS + 1
+		if sizeS > 0 && sizeS <= gapS {
+			return gap, nil
+		}
+		if gapS > largestS {
+			largest = gap
+			largestS = gapS
+		}
+	}
+	if sizeS == 0 && largestS > 0 {
+		return largest, nil
+	}
+	return freeGap{}, fmt.Errorf("not enough free space in disk. Required: %d sectors; Available %d sectors", sizeS, largestS)
+}
+
+// nextPartitionNumber returns the lowest partition number not in use, this is
+// the number parted assigns to a new partition
+func (dev Disk) nextPartitionNumber() int {
+	num := 1
+	for {
+		inUse := false
+		for _, part := range dev.parts {
+			if part.Number == num {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			return num
+		}
+		num++
+	}
+}
+
+// SetPartitionType sets the GPT partition type GUID of the given partition number
+func (dev *Disk) SetPartitionType(partNum int, typeGUID string) (string, error) {
+	//Check we have loaded partition table data
+	if dev.sectorS == 0 {
+		err := dev.Reload()
+		if err != nil {
+			dev.logger.Errorf("Failed analyzing disk: %v\n", err)
+			return "", err
+		}
+	}
+
+	if dev.label != constants.GPT {
+		return "", fmt.Errorf("partition type GUIDs are only supported on %s partition tables", constants.GPT)
+	}
+
+	// Parted has no way to set type GUIDs in all major distros, because of that we use sgdisk
+	out, err := dev.runner.Run("sgdisk", fmt.Sprintf("--typecode=%d:%s", partNum, typeGUID), dev.device)
+	dev.logger.Debugf("sgdisk output: %s", out)
+	if err != nil {
+		dev.logger.Errorf("Failed setting partition type: %v", err)
+		return string(out), err
+	}
+	return string(out), nil
+}
+
+// FormatPartition formats the given partition number. Custom mkfs options can be passed
+// as extra arguments
+func (dev Disk) FormatPartition(partNum int, fileSystem string, label string, opts ...string) (string, error) {
 	pDev, err := dev.FindPartitionDevice(partNum)
 	if err != nil {
 		return "", err
 	}
 
-	mkfs := MkfsCall{fileSystem: fileSystem, label: label, customOpts: []string{}, dev: pDev, runner: dev.runner}
+	mkfs := MkfsCall{fileSystem: fileSystem, label: label, customOpts: opts, dev: pDev, runner: dev.runner}
 	return mkfs.Apply()
 }
 
@@ -303,15 +468,18 @@ func (dev Disk) WipeFsOnPartition(device string) error {
 	return err
 }
 
-func (dev Disk) FindPartitionDevice(partNum int) (string, error) {
+// partitionDevicePath returns the expected device path of the given partition number
+func (dev Disk) partitionDevicePath(partNum int) string {
 	re := regexp.MustCompile(`.*\d+$`)
-	var device string
 
 	if match := re.Match([]byte(dev.device)); match {
-		device = fmt.Sprintf("%sp%d", dev.device, partNum)
-	} else {
-		device = fmt.Sprintf("%s%d", dev.device, partNum)
+		return fmt.Sprintf("%sp%d", dev.device, partNum)
 	}
+	return fmt.Sprintf("%s%d", dev.device, partNum)
+}
+
+func (dev Disk) FindPartitionDevice(partNum int) (string, error) {
+	device := dev.partitionDevicePath(partNum)
 
 	for tries := 0; tries <= partitionTries; tries++ {
 		dev.logger.Debugf("Trying to find the partition device %d of device %s (try number %d)", partNum, dev, tries+1)
@@ -325,7 +493,6 @@ func (dev Disk) FindPartitionDevice(partNum int) (string, error) {
 }
 
 // ExpandLastPartition expands the latest partition in the disk. Size is expressed in MiB here
-// Size is expressed in MiB here
 func (dev *Disk) ExpandLastPartition(size uint) (string, error) {
 	pc := NewPartedCall(dev.String(), dev.runner)
 
@@ -347,7 +514,6 @@ func (dev *Disk) ExpandLastPartition(size uint) (string, error) {
 	part := dev.parts[len(dev.parts)-1]
 	if size > 0 {
 		size = MiBToSectors(size, dev.sectorS)
-		part := dev.parts[len(dev.parts)-1]
 		if size < part.SizeS {
 			return "", errors.New("Layout plugin can only expand a partition, not shrink it")
 		}
@@ -374,6 +540,287 @@ func (dev *Disk) ExpandLastPartition(size uint) (string, error) {
 	return dev.expandFilesystem(pDev)
 }
 
+// ShrinkLastPartition shrinks the latest partition in the disk and its filesystem. The filesystem
+// must not be mounted, it is checked and resized before the partition is recreated with the new
+// size. Only ext2, ext3, ext4 and btrfs filesystems are supported. A partition already having the
+// given size is left untouched. Errors wrapping ErrShrinkRefused are returned before applying any
+// change. Size is expressed in MiB here
+func (dev *Disk) ShrinkLastPartition(size uint) (string, error) {
+	pc := NewPartedCall(dev.String(), dev.runner)
+
+	//Check we have loaded partition table data
+	if dev.sectorS == 0 {
+		err := dev.Reload()
+		if err != nil {
+			dev.logger.Errorf("Failed analyzing disk: %v\n", err)
+			return "", err
+		}
+	}
+
+	pc.SetPartitionTableLabel(dev.label)
+
+	if len(dev.parts) == 0 {
+		return "", errors.New("There is no partition to shrink")
+	}
+
+	part := dev.parts[len(dev.parts)-1]
+	sizeS := MiBToSectors(size, dev.sectorS)
+	if sizeS == part.SizeS {
+		dev.logger.Infof("Partition %d already has %d MiB, nothing to shrink", part.Number, size)
+		return "", nil
+	}
+	if size == 0 || sizeS > part.SizeS {
+		return "", fmt.Errorf("%w: can't shrink partition %d to %d sectors, current size is %d sectors", ErrShrinkRefused, part.Number, sizeS, part.SizeS)
+	}
+
+	pDev, err := dev.FindPartitionDevice(part.Number)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := dev.shrinkFilesystem(pDev, size)
+	if err != nil {
+		return out, err
+	}
+
+	part.SizeS = sizeS
+	pc.DeletePartition(part.Number)
+	pc.CreatePartition(&part)
+	out, err = pc.WriteChanges()
+	if err != nil {
+		return out, err
+	}
+	return "", dev.Reload()
+}
+
+// MoveLastPartitionToEnd moves the latest partition in the disk, including its data, to the end
+// of the disk, so the free space left by shrinking it is in front of it. The partition must not
+// be mounted. A partition already at the end of the disk is left untouched.
+func (dev *Disk) MoveLastPartitionToEnd() (string, error) {
+	//Check we have loaded partition table data
+	if dev.sectorS == 0 {
+		err := dev.Reload()
+		if err != nil {
+			dev.logger.Errorf("Failed analyzing disk: %v\n", err)
+			return "", err
+		}
+	}
+
+	if len(dev.parts) == 0 {
+		return "", errors.New("There is no partition to move")
+	}
+
+	// Keep the partition aligned to 1MiB and leave the last MiB free for the GPT backup header
+	part := dev.parts[len(dev.parts)-1]
+	alignS := 1024 * 1024 / dev.sectorS
+	if dev.lastS < part.SizeS+alignS {
+		return "", fmt.Errorf("partition %d does not fit at the end of %s", part.Number, dev.device)
+	}
+	startS := (dev.lastS - alignS - part.SizeS) / alignS * alignS
+	if startS <= part.StartS {
+		dev.logger.Infof("Partition %d is already at the end of %s", part.Number, dev.device)
+		return "", nil
+	}
+
+	err := checkNotMounted(dev.partitionDevicePath(part.Number))
+	if err != nil {
+		return "", err
+	}
+
+	// Parted can't move partitions, sfdisk moves the data and updates the partition table at once.
+	// The disk might be in use, so the kernel is notified about the partition change with partx.
+	cmd := dev.runner.InitCmd("sfdisk", "--no-reread", "--move-data", "-N", strconv.Itoa(part.Number), dev.device)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%d,%d\n", startS, part.SizeS))
+	out, err := dev.runner.RunCmd(cmd)
+	dev.logger.Debugf("sfdisk output: %s", out)
+	if err != nil {
+		dev.logger.Errorf("Failed moving partition: %v", err)
+		return string(out), err
+	}
+	out, err = dev.runner.Run("partx", "-u", dev.device)
+	if err != nil {
+		return string(out), err
+	}
+	return "", dev.Reload()
+}
+
+// checkNotMounted fails if the given partition device is mounted
+func checkNotMounted(device string) error {
+	parts, err := utils.GetAllPartitions()
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if part.Path == device && part.MountPoint != "" {
+			return fmt.Errorf("%s is mounted at %s", device, part.MountPoint)
+		}
+	}
+	return nil
+}
+
+// shrinkFilesystem checks and shrinks the filesystem of the given device to the given size in MiB.
+// It fails before applying any change if the filesystem is mounted or if its used space does not
+// fit in the new size including a safety margin, the used space is read without modifying the
+// filesystem.
+func (dev Disk) shrinkFilesystem(device string, size uint) (string, error) {
+	var out []byte
+	var err error
+
+	fs, err := utils.GetPartitionFS(device)
+	if err != nil {
+		return fs, err
+	}
+
+	err = checkNotMounted(device)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrShrinkRefused, err.Error())
+	}
+
+	switch strings.TrimSpace(fs) {
+	case "ext2", "ext3", "ext4":
+		out, err = dev.runner.Run("tune2fs", "-l", device)
+		if err != nil {
+			return string(out), err
+		}
+		used, err := parseExtUsedBytes(string(out))
+		if err != nil {
+			return string(out), err
+		}
+		err = checkShrinkSafety(device, used, size)
+		if err != nil {
+			return "", err
+		}
+		out, err = dev.checkExtFilesystem(device)
+		if err != nil {
+			return string(out), err
+		}
+		out, err = dev.runner.Run("resize2fs", device, fmt.Sprintf("%dM", size))
+		if err != nil {
+			return string(out), err
+		}
+	case "btrfs":
+		out, err = dev.runner.Run("btrfs", "check", "--readonly", device)
+		if err != nil {
+			return string(out), err
+		}
+		// btrfs can only be resized while mounted, the filesystem is not in use by the system
+		// so it is mounted on a temporary path just for the resize
+		tmpDir, err := utils.TempDir(dev.fs, "", "partitioner")
+		defer func(fs v1.FS, path string) {
+			_ = fs.RemoveAll(path)
+		}(dev.fs, tmpDir)
+
+		if err != nil {
+			return string(out), err
+		}
+		out, err = dev.runner.Run("mount", "-t", "btrfs", device, tmpDir)
+		if err != nil {
+			return string(out), err
+		}
+		out, err = dev.shrinkBtrfs(device, tmpDir, size)
+		if err != nil {
+			// If we error out, try to umount the dir to not leave it hanging
+			umountOut, err2 := dev.runner.Run("umount", tmpDir)
+			if err2 != nil {
+				return string(umountOut), err2
+			}
+			return string(out), err
+		}
+		out, err = dev.runner.Run("umount", tmpDir)
+		if err != nil {
+			return string(out), err
+		}
+	default:
+		return "", fmt.Errorf("%w: shrinking %s filesystem is not supported, not resizing %s", ErrShrinkRefused, fs, device)
+	}
+
+	return "", nil
+}
+
+// shrinkBtrfs shrinks the btrfs filesystem mounted at the given mountpoint to the given size in MiB
+func (dev Disk) shrinkBtrfs(device, mountpoint string, size uint) ([]byte, error) {
+	out, err := dev.runner.Run("btrfs", "filesystem", "usage", "-b", mountpoint)
+	if err != nil {
+		return out, err
+	}
+	used, err := parseBtrfsUsedBytes(string(out))
+	if err != nil {
+		return out, err
+	}
+	err = checkShrinkSafety(device, used, size)
+	if err != nil {
+		return nil, err
+	}
+	return dev.runner.Run("btrfs", "filesystem", "resize", fmt.Sprintf("%dM", size), mountpoint)
+}
+
+// checkExtFilesystem runs a forced e2fsck on the given device fixing any error found. Exit
+// code 1 means filesystem errors were corrected, hence it is not considered a failure.
+func (dev Disk) checkExtFilesystem(device string) ([]byte, error) {
+	var exitErr interface{ ExitCode() int }
+
+	out, err := dev.runner.Run("e2fsck", "-fy", device)
+	if err != nil && errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		dev.logger.Infof("e2fsck corrected filesystem errors on %s", device)
+		return out, nil
+	}
+	return out, err
+}
+
+// checkShrinkSafety fails if the given used bytes plus a safety margin do not fit in the
+// given size in MiB
+func checkShrinkSafety(device string, used uint64, size uint) error {
+	required := used + used*shrinkSafetyMargin/100
+	if required > uint64(size)*1024*1024 {
+		return fmt.Errorf(
+			"%w: not enough space to shrink %s to %d MiB, %d MiB are in use and %d%% must remain free",
+			ErrShrinkRefused, device, size, used/(1024*1024), shrinkSafetyMargin,
+		)
+	}
+	return nil
+}
+
+// parseExtUsedBytes computes the used bytes of an ext filesystem from tune2fs -l output
+func parseExtUsedBytes(tune2fsOut string) (uint64, error) {
+	var count, free, blockSize uint64
+	var err error
+
+	scanner := bufio.NewScanner(strings.NewReader(tune2fsOut))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Block count":
+			count, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		case "Free blocks":
+			free, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		case "Block size":
+			blockSize, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if count == 0 || blockSize == 0 || free > count {
+		return 0, errors.New("failed parsing ext filesystem data")
+	}
+	return (count - free) * blockSize, nil
+}
+
+// parseBtrfsUsedBytes gets the used bytes of a btrfs filesystem from btrfs filesystem usage -b output
+func parseBtrfsUsedBytes(usageOut string) (uint64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(usageOut))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(key) == "Used" {
+			return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return 0, errors.New("failed parsing btrfs filesystem usage")
+}
+
 func (dev Disk) expandFilesystem(device string) (string, error) {
 	var out []byte
 	var err error
@@ -385,7 +832,7 @@ func (dev Disk) expandFilesystem(device string) (string, error) {
 
 	switch strings.TrimSpace(fs) {
 	case "ext2", "ext3", "ext4":
-		out, err = dev.runner.Run("e2fsck", "-fy", device)
+		out, err = dev.checkExtFilesystem(device)
 		if err != nil {
 			return string(out), err
 		}
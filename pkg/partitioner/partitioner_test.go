@@ -18,6 +18,8 @@ package partitioner_test
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/jaypipes/ghw/pkg/block"
@@ -39,6 +41,12 @@ const printOutput = `BYT;
 3:29394944s:45019135s:15624192s:ext4::type=83;
 4:45019136s:50331647s:5312512s:ext4::type=83;`
 
+const gapsPrintOutput = `BYT;
+/dev/loop0:50593792s:loopback:512:512:gpt:Loopback device:;
+1:2048s:98303s:96256s:vfat:efi:boot, esp;
+3:29394944s:45019135s:15624192s:ext4:vendor:;
+4:45019136s:50331647s:5312512s:ext4:data:;`
+
 func TestElementalSuite(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Partitioner test suite")
@@ -195,9 +203,16 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 			cmds := [][]string{{"mkfs.vfat", "-n", "EFI", "/dev/device"}}
 			Expect(runner.CmdsMatch(cmds)).To(BeNil())
 		})
-		It("Fails for unsupported filesystem", func() {
+		It("Successfully formats a partition with btrfs", func() {
 			mkfs := part.NewMkfsCall("/dev/device", "btrfs", "OEM", runner)
 			_, err := mkfs.Apply()
+			Expect(err).To(BeNil())
+			cmds := [][]string{{"mkfs.btrfs", "-L", "OEM", "/dev/device"}}
+			Expect(runner.CmdsMatch(cmds)).To(BeNil())
+		})
+		It("Fails for unsupported filesystem", func() {
+			mkfs := part.NewMkfsCall("/dev/device", "zfs", "OEM", runner)
+			_, err := mkfs.Apply()
 			Expect(err).NotTo(BeNil())
 		})
 	})
@@ -299,6 +314,28 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 				Expect(num).To(Equal(5))
 				Expect(runner.CmdsMatch(cmds)).To(BeNil())
 			})
+			It("Adds a new partition in the first free gap fitting its size", func() {
+				cmds = [][]string{printCmd, {
+					"parted", "--script", "--machine", "--", "/dev/device",
+					"unit", "s", "mkpart", "ignored", "ext4", "98304", "303103",
+				}, printCmd}
+				runner.ReturnValue = []byte(gapsPrintOutput)
+				num, err := dev.AddPartition(100, "ext4", "ignored")
+				Expect(err).To(BeNil())
+				Expect(num).To(Equal(2))
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Adds a new partition growing up to the end of the largest free gap", func() {
+				cmds = [][]string{printCmd, {
+					"parted", "--script", "--machine", "--", "/dev/device",
+					"unit", "s", "mkpart", "ignored", "ext4", "98304", "29394943",
+				}, printCmd}
+				runner.ReturnValue = []byte(gapsPrintOutput)
+				num, err := dev.AddPartition(0, "ext4", "ignored")
+				Expect(err).To(BeNil())
+				Expect(num).To(Equal(2))
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
 			It("Fails to a new partition if there is not enough space available", func() {
 				cmds = [][]string{printCmd}
 				runner.ReturnValue = []byte(printOutput)
@@ -306,6 +343,56 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 				Expect(err).NotTo(BeNil())
 				Expect(runner.CmdsMatch(cmds)).To(BeNil())
 			})
+			Describe("Removing partitions", func() {
+				var ghwTest mocks.GhwMock
+				BeforeEach(func() {
+					runner.ReturnValue = []byte(gapsPrintOutput)
+					ghwTest = mocks.GhwMock{}
+					ghwTest.AddDisk(block.Disk{
+						Name: "device",
+						Partitions: []*block.Partition{
+							{Name: "device1", Label: "efi", FilesystemLabel: "EFI", Type: "vfat"},
+							{Name: "device3", Label: "vendor", FilesystemLabel: "VENDOR", UUID: "0d8e4f5a-7a2b-4c55-9b1e-3f2a6c0e9d11"},
+							{Name: "device4", Label: "data", FilesystemLabel: "DATA"},
+						},
+					})
+					ghwTest.CreateDevices()
+				})
+				AfterEach(func() {
+					ghwTest.Clean()
+				})
+				It("Removes all partitions except the ones matching the given number or label", func() {
+					cmds = [][]string{printCmd, {
+						"parted", "--script", "--machine", "--", "/dev/device",
+						"unit", "s", "rm", "4",
+					}, printCmd}
+					_, err := dev.RemovePartitionsExcept("1", "vendor")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch(cmds)).To(BeNil())
+				})
+				It("Removes all partitions except the ones matching the given filesystem label or GUID", func() {
+					cmds = [][]string{printCmd, {
+						"parted", "--script", "--machine", "--", "/dev/device",
+						"unit", "s", "rm", "1", "rm", "4",
+					}, printCmd}
+					_, err := dev.RemovePartitionsExcept("0D8E4F5A-7A2B-4C55-9B1E-3F2A6C0E9D11")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch(cmds)).To(BeNil())
+					runner.ClearCmds()
+					cmds[1] = []string{
+						"parted", "--script", "--machine", "--", "/dev/device",
+						"unit", "s", "rm", "1", "rm", "3",
+					}
+					_, err = dev.RemovePartitionsExcept("DATA")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch(cmds)).To(BeNil())
+				})
+				It("Fails without removing any partition if a reference matches no partition", func() {
+					_, err := dev.RemovePartitionsExcept("1", "missing")
+					Expect(err).NotTo(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+			})
 			It("Finds device for a given partition number", func() {
 				_, err := fs.Create("/dev/device4")
 				Expect(err).To(BeNil())
@@ -329,6 +416,33 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 				Expect(err).To(BeNil())
 				Expect(runner.CmdsMatch(cmds)).To(BeNil())
 			})
+			It("Formats a partition with custom mkfs options", func() {
+				_, err := fs.Create("/dev/device4")
+				Expect(err).To(BeNil())
+				cmds = [][]string{
+					{"udevadm", "settle"},
+					{"mkfs.ext4", "-L", "DATA", "-m", "0", "/dev/device4"},
+				}
+				_, err = dev.FormatPartition(4, "ext4", "DATA", "-m", "0")
+				Expect(err).To(BeNil())
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Sets the partition type GUID on a GPT disk", func() {
+				runner.ReturnValue = []byte(strings.Replace(printOutput, "msdos", "gpt", 1))
+				cmds = [][]string{printCmd, {
+					"sgdisk", "--typecode=4:0FC63DAF-8483-4772-8E79-3D69D8477DE4", "/dev/device",
+				}}
+				_, err := dev.SetPartitionType(4, "0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+				Expect(err).To(BeNil())
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Fails to set the partition type GUID on a MSDOS disk", func() {
+				runner.ReturnValue = []byte(printOutput)
+				cmds = [][]string{printCmd}
+				_, err := dev.SetPartitionType(4, "0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+				Expect(err).NotTo(BeNil())
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
 			It("Clears filesystem header from a partition", func() {
 				cmds = [][]string{
 					{"wipefs", "--all", "/dev/device1"},
@@ -399,6 +513,177 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 					Expect(runner.CmdsMatch(append(cmds, xfsCmds...))).To(BeNil())
 				})
 			})
+			Describe("Shrinking partitions", func() {
+				var ghwTest mocks.GhwMock
+				var fsOutput string
+				var shrinkCmd []string
+
+				BeforeEach(func() {
+					cmds = [][]string{printCmd, {"udevadm", "settle"}}
+					shrinkCmd = []string{
+						"parted", "--script", "--machine", "--", "/dev/device",
+						"unit", "s", "rm", "4", "mkpart", "primary", "", "45019136", "47116287",
+					}
+					runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+						switch cmd {
+						case "parted":
+							return []byte(printOutput), nil
+						case "tune2fs", "btrfs":
+							return []byte(fsOutput), nil
+						default:
+							return []byte{}, nil
+						}
+					}
+					_, err := fs.Create("/dev/device4")
+					Expect(err).To(BeNil())
+					ghwTest = mocks.GhwMock{}
+				})
+				AfterEach(func() {
+					ghwTest.Clean()
+				})
+				It("Shrinks ext4 partition", func() {
+					fsOutput = "Block count:              664064\nFree blocks:              500000\nBlock size:               4096\n"
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "ext4"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch(append(cmds, [][]string{
+						{"tune2fs", "-l", "/dev/device4"}, {"e2fsck", "-fy", "/dev/device4"},
+						{"resize2fs", "/dev/device4", "1024M"}, shrinkCmd, printCmd,
+					}...))).To(BeNil())
+				})
+				It("Shrinks ext4 partition after e2fsck corrected errors", func() {
+					fsOutput = "Block count:              664064\nFree blocks:              500000\nBlock size:               4096\n"
+					runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+						switch cmd {
+						case "parted":
+							return []byte(printOutput), nil
+						case "tune2fs":
+							return []byte(fsOutput), nil
+						case "e2fsck":
+							return []byte{}, exitCodeError(1)
+						default:
+							return []byte{}, nil
+						}
+					}
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "ext4"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).To(BeNil())
+					Expect(runner.IncludesCmds([][]string{{"resize2fs", "/dev/device4", "1024M"}, shrinkCmd})).To(BeNil())
+				})
+				It("Fails to shrink ext4 partition if e2fsck can't correct errors", func() {
+					fsOutput = "Block count:              664064\nFree blocks:              500000\nBlock size:               4096\n"
+					runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+						switch cmd {
+						case "parted":
+							return []byte(printOutput), nil
+						case "tune2fs":
+							return []byte(fsOutput), nil
+						case "e2fsck":
+							return []byte{}, exitCodeError(4)
+						default:
+							return []byte{}, nil
+						}
+					}
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "ext4"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).NotTo(BeNil())
+					Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(BeNil())
+				})
+				It("Shrinks btrfs partition", func() {
+					fsOutput = "Overall:\n    Device size:        2785017856\n    Used:               104857600\n"
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "btrfs"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).To(BeNil())
+					Expect(runner.MatchMilestones([][]string{
+						{"btrfs", "check", "--readonly", "/dev/device4"},
+						{"mount", "-t", "btrfs", "/dev/device4"},
+						{"btrfs", "filesystem", "usage", "-b"},
+						{"btrfs", "filesystem", "resize", "1024M"},
+						{"umount"}, shrinkCmd, printCmd,
+					})).To(BeNil())
+				})
+				It("Refuses to shrink a filesystem if the used space does not fit", func() {
+					fsOutput = "Block count:              664064\nFree blocks:              400000\nBlock size:               4096\n"
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "ext4"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).NotTo(BeNil())
+					// Nothing is repaired nor resized
+					Expect(runner.CmdsMatch(append(cmds, [][]string{
+						{"tune2fs", "-l", "/dev/device4"},
+					}...))).To(BeNil())
+				})
+				It("Refuses to shrink a mounted filesystem", func() {
+					fsOutput = "Block count:              664064\nFree blocks:              500000\nBlock size:               4096\n"
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "ext4", MountPoint: "/usr/local"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(errors.Is(err, part.ErrShrinkRefused)).To(BeTrue())
+					Expect(runner.CmdsMatch(cmds)).To(BeNil())
+				})
+				It("Refuses to shrink an unsupported filesystem", func() {
+					ghwTest.AddDisk(block.Disk{Name: "device", Partitions: []*block.Partition{
+						{Name: "device4", Type: "xfs"},
+					}})
+					ghwTest.CreateDevices()
+					_, err := dev.ShrinkLastPartition(1024)
+					Expect(err).NotTo(BeNil())
+					Expect(runner.CmdsMatch(cmds)).To(BeNil())
+				})
+				It("Fails to shrink to a bigger size", func() {
+					_, err := dev.ShrinkLastPartition(4096)
+					Expect(err).NotTo(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+				It("Does not expand to a smaller size", func() {
+					_, err := dev.ExpandLastPartition(1024)
+					Expect(err).NotTo(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+				It("Moves the last partition to the end of the disk", func() {
+					_, err := dev.MoveLastPartitionToEnd()
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch([][]string{
+						printCmd, {"sfdisk", "--no-reread", "--move-data", "-N", "4", "/dev/device"},
+						{"partx", "-u", "/dev/device"}, printCmd,
+					})).To(BeNil())
+				})
+				It("Does not move a partition already at the end of the disk", func() {
+					runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+						return []byte(strings.Replace(printOutput, "4:45019136s:50331647s", "4:45279232s:50591743s", 1)), nil
+					}
+					_, err := dev.MoveLastPartitionToEnd()
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+			})
 		})
 	})
 })
+
+// exitCodeError mimics the error of a command exiting with the given code
+type exitCodeError int
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitCodeError) ExitCode() int {
+	return int(e)
+}
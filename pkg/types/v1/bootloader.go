@@ -0,0 +1,89 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BootloaderProfile describes the EFI bootloader binaries and grub modules a distribution
+// ships. Profiles are matched against the ID and ID_LIKE values of the os-release file.
+type BootloaderProfile struct {
+	Name    string                 `yaml:"name" mapstructure:"name"`
+	IDs     []string               `yaml:"ids" mapstructure:"ids"`
+	EFI     map[string]EFIBinaries `yaml:"efi" mapstructure:"efi"`
+	Modules []string               `yaml:"modules,omitempty" mapstructure:"modules"`
+}
+
+// EFIBinaries are the EFI binaries of a bootloader profile for a given architecture.
+// An empty shim means grub is booted directly.
+type EFIBinaries struct {
+	Shim       EFIBinary `yaml:"shim,omitempty" mapstructure:"shim"`
+	MokManager EFIBinary `yaml:"mokmanager,omitempty" mapstructure:"mokmanager"`
+	Grub       EFIBinary `yaml:"grub" mapstructure:"grub"`
+}
+
+// EFIBinary is an EFI binary installed in the EFI partition as Name. Sources are the
+// candidate file names to look for in the OS image, the first one found is used. Unsigned
+// grub binaries are not looked for, they are built with grub-mkimage from the OS image.
+type EFIBinary struct {
+	Name     string   `yaml:"name" mapstructure:"name"`
+	Sources  []string `yaml:"sources,omitempty" mapstructure:"sources"`
+	Unsigned bool     `yaml:"unsigned,omitempty" mapstructure:"unsigned"`
+}
+
+// Candidates returns the file names to look for in the OS image, in order of preference
+func (b EFIBinary) Candidates() []string {
+	if len(b.Sources) == 0 {
+		return []string{b.Name}
+	}
+	return b.Sources
+}
+
+// Matches returns true if the profile applies to the given os-release ID
+func (p BootloaderProfile) Matches(id string) bool {
+	for _, i := range p.IDs {
+		if strings.EqualFold(i, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (p BootloaderProfile) Sanitize() error {
+	if p.Name == "" {
+		return fmt.Errorf("bootloader profile without name")
+	}
+	if len(p.IDs) == 0 {
+		return fmt.Errorf("bootloader profile '%s' does not define any os-release ID", p.Name)
+	}
+	for arch, bins := range p.EFI {
+		if bins.Grub.Name == "" {
+			return fmt.Errorf("bootloader profile '%s' does not define grub for %s", p.Name, arch)
+		}
+		if bins.Shim.Name == "" && bins.MokManager.Name != "" {
+			return fmt.Errorf("bootloader profile '%s' defines MokManager without shim for %s", p.Name, arch)
+		}
+		if bins.Shim.Unsigned || bins.MokManager.Unsigned {
+			return fmt.Errorf("bootloader profile '%s' defines an unsigned shim or MokManager for %s, only grub can be built", p.Name, arch)
+		}
+	}
+	return nil
+}
@@ -55,6 +55,20 @@ func (i ImageSource) IsFile() bool {
 	return i.srcType == file
 }
 
+// IsPinned checks the source always resolves to the same content, images must be referenced
+// by digest. Local sources are considered pinned.
+func (i ImageSource) IsPinned() bool {
+	if !i.IsImage() {
+		return true
+	}
+	n, err := reference.ParseNormalizedNamed(i.source)
+	if err != nil {
+		return false
+	}
+	_, ok := n.(reference.Digested)
+	return ok
+}
+
 func (i ImageSource) IsEmpty() bool {
 	if i.srcType == "" {
 		return true
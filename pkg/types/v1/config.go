@@ -18,9 +18,13 @@ package v1
 
 import (
 	"fmt"
+	"net/url"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"k8s.io/mount-utils"
@@ -49,16 +53,58 @@ type Config struct {
 	CloudInitRunner           CloudInitRunner
 	ImageExtractor            ImageExtractor
 	Client                    HTTPClient
-	Platform                  *Platform `yaml:"platform,omitempty" mapstructure:"platform"`
-	Cosign                    bool      `yaml:"cosign,omitempty" mapstructure:"cosign"`
-	Verify                    bool      `yaml:"verify,omitempty" mapstructure:"verify"`
-	CosignPubKey              string    `yaml:"cosign-key,omitempty" mapstructure:"cosign-key"`
-	LocalImage                bool      `yaml:"local,omitempty" mapstructure:"local"`
-	Arch                      string    `yaml:"arch,omitempty" mapstructure:"arch"`
-	SquashFsCompressionConfig []string  `yaml:"squash-compression,omitempty" mapstructure:"squash-compression"`
-	SquashFsNoCompression     bool      `yaml:"squash-no-compression,omitempty" mapstructure:"squash-no-compression"`
-	CloudInitPaths            []string  `yaml:"cloud-init-paths,omitempty" mapstructure:"cloud-init-paths"`
-	Strict                    bool      `yaml:"strict,omitempty" mapstructure:"strict"`
+	Platform                  *Platform  `yaml:"platform,omitempty" mapstructure:"platform"`
+	Cosign                    bool       `yaml:"cosign,omitempty" mapstructure:"cosign"`
+	Verify                    bool       `yaml:"verify,omitempty" mapstructure:"verify"`
+	CosignPubKey              string     `yaml:"cosign-key,omitempty" mapstructure:"cosign-key"`
+	LocalImage                bool       `yaml:"local,omitempty" mapstructure:"local"`
+	Arch                      string     `yaml:"arch,omitempty" mapstructure:"arch"`
+	SquashFsCompressionConfig []string   `yaml:"squash-compression,omitempty" mapstructure:"squash-compression"`
+	SquashFsNoCompression     bool       `yaml:"squash-no-compression,omitempty" mapstructure:"squash-no-compression"`
+	ImgOverhead               uint       `yaml:"img-overhead,omitempty" mapstructure:"img-overhead"`
+	CloudInitPaths            []string   `yaml:"cloud-init-paths,omitempty" mapstructure:"cloud-init-paths"`
+	Strict                    bool       `yaml:"strict,omitempty" mapstructure:"strict"`
+	SecureBoot                SecureBoot `yaml:"secure-boot,omitempty" mapstructure:"secure-boot"`
+	// SourceDateEpoch pins all timestamps of generated artifacts, set from SOURCE_DATE_EPOCH
+	SourceDateEpoch *time.Time `yaml:"-" mapstructure:"-"`
+	// BootloaderProfiles are custom bootloader profiles, preferred over the built-in ones
+	BootloaderProfiles []BootloaderProfile `yaml:"bootloader-profiles,omitempty" mapstructure:"bootloader-profiles"`
+	// CommandTimeouts by command name, merged over the defaults. A zero timeout disables it
+	CommandTimeouts map[string]time.Duration `yaml:"command-timeouts,omitempty" mapstructure:"command-timeouts"`
+}
+
+// SecureBoot defines the Secure Boot db key and certificate used to sign bootloaders,
+// kernels and unified kernel images. NoShim drops shim and MokManager, booting the
+// signed bootloader directly, which requires the custom keys to be enrolled.
+type SecureBoot struct {
+	Key    string `yaml:"key,omitempty" mapstructure:"key"`
+	Cert   string `yaml:"cert,omitempty" mapstructure:"cert"`
+	NoShim bool   `yaml:"no-shim,omitempty" mapstructure:"no-shim"`
+}
+
+// Enabled returns true if EFI binaries have to be signed
+func (s SecureBoot) Enabled() bool {
+	return s.Key != "" && s.Cert != ""
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (s SecureBoot) Sanitize() error {
+	if (s.Key == "") != (s.Cert == "") {
+		return fmt.Errorf("secure boot signing requires both a key and a certificate")
+	}
+	if s.NoShim && !s.Enabled() {
+		return fmt.Errorf("booting without shim requires secure boot signing keys")
+	}
+	return nil
+}
+
+// Now returns the SOURCE_DATE_EPOCH time if set, the current time otherwise
+func (c Config) Now() time.Time {
+	if c.SourceDateEpoch != nil {
+		return *c.SourceDateEpoch
+	}
+	return time.Now()
 }
 
 // WriteInstallState writes the state.yaml file to the given state and recovery paths
@@ -123,7 +169,26 @@ func (c *Config) Sanitize() error {
 		c.Platform = p
 	}
 
-	return nil
+	// Merge the configured command timeouts over the default ones
+	timeouts := constants.GetCommandTimeouts()
+	for cmd, timeout := range c.CommandTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("invalid negative timeout for command '%s': %s", cmd, timeout)
+		}
+		timeouts[cmd] = timeout
+	}
+	if r, ok := c.Runner.(*RealRunner); ok {
+		r.Timeouts = timeouts
+	}
+
+	for _, p := range c.BootloaderProfiles {
+		err := p.Sanitize()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.SecureBoot.Sanitize()
 }
 
 type RunConfig struct {
@@ -146,21 +211,60 @@ func (r *RunConfig) Sanitize() error {
 
 // InstallSpec struct represents all the installation action details
 type InstallSpec struct {
-	Target           string              `yaml:"target,omitempty" mapstructure:"target"`
-	Firmware         string              `yaml:"firmware,omitempty" mapstructure:"firmware"`
-	PartTable        string              `yaml:"part-table,omitempty" mapstructure:"part-table"`
-	Partitions       ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
-	ExtraPartitions  PartitionList       `yaml:"extra-partitions,omitempty" mapstructure:"extra-partitions"`
-	NoFormat         bool                `yaml:"no-format,omitempty" mapstructure:"no-format"`
-	Force            bool                `yaml:"force,omitempty" mapstructure:"force"`
-	CloudInit        []string            `yaml:"cloud-init,omitempty" mapstructure:"cloud-init"`
-	Iso              string              `yaml:"iso,omitempty" mapstructure:"iso"`
-	GrubDefEntry     string              `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
-	Active           Image               `yaml:"system,omitempty" mapstructure:"system"`
-	Recovery         Image               `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
-	Passive          Image
-	GrubConf         string
-	DisableBootEntry bool `yaml:"disable-boot-entry,omitempty" mapstructure:"disable-boot-entry"`
+	Target             string              `yaml:"target,omitempty" mapstructure:"target"`
+	Firmware           string              `yaml:"firmware,omitempty" mapstructure:"firmware"`
+	PartTable          string              `yaml:"part-table,omitempty" mapstructure:"part-table"`
+	Partitions         ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
+	ExtraPartitions    PartitionList       `yaml:"extra-partitions,omitempty" mapstructure:"extra-partitions"`
+	NoFormat           bool                `yaml:"no-format,omitempty" mapstructure:"no-format"`
+	Force              bool                `yaml:"force,omitempty" mapstructure:"force"`
+	CloudInit          []string            `yaml:"cloud-init,omitempty" mapstructure:"cloud-init"`
+	Iso                string              `yaml:"iso,omitempty" mapstructure:"iso"`
+	GrubDefEntry       string              `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
+	Active             Image               `yaml:"system,omitempty" mapstructure:"system"`
+	Recovery           Image               `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
+	Passive            Image
+	GrubConf           string
+	DisableBootEntry   bool          `yaml:"disable-boot-entry,omitempty" mapstructure:"disable-boot-entry"`
+	GrubHostTools      bool          `yaml:"grub-host-tools,omitempty" mapstructure:"grub-host-tools"`
+	TargetSelector     *DiskSelector `yaml:"target-selector,omitempty" mapstructure:"target-selector"`
+	PreservePartitions []string      `yaml:"preserve-partitions,omitempty" mapstructure:"preserve-partitions"`
+	TargetImage        bool          `yaml:"target-image,omitempty" mapstructure:"target-image"`
+	TargetImageSize    uint          `yaml:"target-image-size,omitempty" mapstructure:"target-image-size"`
+	UKI                bool          `yaml:"uki,omitempty" mapstructure:"uki"`
+	UKICmdline         string        `yaml:"uki-cmdline,omitempty" mapstructure:"uki-cmdline"`
+	ExtraCmdline       ExtraCmdline  `yaml:"extra-cmdline,omitempty" mapstructure:"extra-cmdline"`
+}
+
+// DiskSelector defines a set of rules to select a disk, a disk must match all the defined rules.
+// Sizes are expressed in MiB.
+type DiskSelector struct {
+	MinSize      uint   `yaml:"min-size,omitempty" mapstructure:"min-size"`
+	MaxSize      uint   `yaml:"max-size,omitempty" mapstructure:"max-size"`
+	Rotational   *bool  `yaml:"rotational,omitempty" mapstructure:"rotational"`
+	Transport    string `yaml:"transport,omitempty" mapstructure:"transport"`
+	Model        string `yaml:"model,omitempty" mapstructure:"model"`
+	ByID         string `yaml:"by-id,omitempty" mapstructure:"by-id"`
+	LargestEmpty bool   `yaml:"largest-empty,omitempty" mapstructure:"largest-empty"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (d *DiskSelector) Sanitize() error {
+	if d.MaxSize > 0 && d.MinSize > d.MaxSize {
+		return fmt.Errorf("invalid target selector, min-size is bigger than max-size")
+	}
+	if d.Model != "" {
+		if _, err := regexp.Compile(d.Model); err != nil {
+			return fmt.Errorf("invalid target selector model regular expression: %w", err)
+		}
+	}
+	if d.ByID != "" {
+		if _, err := filepath.Match(d.ByID, ""); err != nil {
+			return fmt.Errorf("invalid target selector by-id pattern: %w", err)
+		}
+	}
+	return nil
 }
 
 // Sanitize checks the consistency of the struct, returns error
@@ -181,6 +285,26 @@ func (i *InstallSpec) Sanitize() error {
 	if i.Recovery.FS == constants.SquashFs {
 		i.Recovery.Label = ""
 	}
+	for _, img := range []Image{i.Active, i.Recovery} {
+		if err := checkCompression(img.Compression); err != nil {
+			return err
+		}
+	}
+
+	if i.TargetSelector != nil {
+		if err := i.TargetSelector.Sanitize(); err != nil {
+			return err
+		}
+	}
+
+	// Setting an image size implies the target is an image file
+	if i.TargetImageSize > 0 {
+		i.TargetImage = true
+	}
+
+	if i.NoFormat && len(i.PreservePartitions) > 0 {
+		return fmt.Errorf("preserving partitions requires partitioning the target device, it can't be used together with no-format")
+	}
 
 	// Check for extra partitions having set its size to 0
 	extraPartsSizeCheck := 0
@@ -197,6 +321,10 @@ func (i *InstallSpec) Sanitize() error {
 	if extraPartsSizeCheck == 1 && i.Partitions.Persistent.Size == 0 {
 		return fmt.Errorf("both persistent partition and extra partitions have size set to 0. Only one partition can have its size set to 0 which means that it will take all the available disk space in the device")
 	}
+	// Unified kernel images are stored in the EFI partition
+	if i.UKI && i.Firmware != EFI {
+		return fmt.Errorf("unified kernel images require %s firmware", EFI)
+	}
 	return i.Partitions.SetFirmwarePartitions(i.Firmware, i.PartTable)
 }
 
@@ -213,7 +341,11 @@ type ResetSpec struct {
 	Efi              bool
 	GrubConf         string
 	State            *InstallState
-	DisableBootEntry bool `yaml:"disable-boot-entry,omitempty" mapstructure:"disable-boot-entry"`
+	DisableBootEntry bool         `yaml:"disable-boot-entry,omitempty" mapstructure:"disable-boot-entry"`
+	GrubHostTools    bool         `yaml:"grub-host-tools,omitempty" mapstructure:"grub-host-tools"`
+	ExtraCmdline     ExtraCmdline `yaml:"extra-cmdline,omitempty" mapstructure:"extra-cmdline"`
+	UKI              bool         `yaml:"uki,omitempty" mapstructure:"uki"`
+	UKICmdline       string       `yaml:"uki-cmdline,omitempty" mapstructure:"uki-cmdline"`
 }
 
 // Sanitize checks the consistency of the struct, returns error
@@ -225,19 +357,25 @@ func (r *ResetSpec) Sanitize() error {
 	if r.Partitions.State == nil || r.Partitions.State.MountPoint == "" {
 		return fmt.Errorf("undefined state partition")
 	}
+	if r.UKI && (r.Partitions.EFI == nil || r.Partitions.EFI.MountPoint == "") {
+		return fmt.Errorf("undefined EFI partition to store unified kernel images")
+	}
 	// Unset labels for squashfs filesystem
 	if r.Active.FS == constants.SquashFs {
 		r.Active.Label = ""
 		r.Passive.Label = ""
 	}
-	return nil
+	return checkCompression(r.Active.Compression)
 }
 
 type UpgradeSpec struct {
-	RecoveryUpgrade bool   `yaml:"recovery,omitempty" mapstructure:"recovery"`
-	Active          Image  `yaml:"system,omitempty" mapstructure:"system"`
-	Recovery        Image  `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
-	GrubDefEntry    string `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
+	RecoveryUpgrade bool         `yaml:"recovery,omitempty" mapstructure:"recovery"`
+	Active          Image        `yaml:"system,omitempty" mapstructure:"system"`
+	Recovery        Image        `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
+	GrubDefEntry    string       `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
+	UKI             bool         `yaml:"uki,omitempty" mapstructure:"uki"`
+	UKICmdline      string       `yaml:"uki-cmdline,omitempty" mapstructure:"uki-cmdline"`
+	ExtraCmdline    ExtraCmdline `yaml:"extra-cmdline,omitempty" mapstructure:"extra-cmdline"`
 	Passive         Image
 	Partitions      ElementalPartitions
 	State           *InstallState
@@ -261,6 +399,9 @@ func (u *UpgradeSpec) Sanitize() error {
 			return fmt.Errorf("undefined upgrade source")
 		}
 	}
+	if u.UKI && (u.Partitions.EFI == nil || u.Partitions.EFI.MountPoint == "") {
+		return fmt.Errorf("undefined EFI partition to store unified kernel images")
+	}
 	// Unset labels for squashfs filesystem
 	if u.Active.FS == constants.SquashFs {
 		u.Active.Label = ""
@@ -269,6 +410,11 @@ func (u *UpgradeSpec) Sanitize() error {
 	if u.Recovery.FS == constants.SquashFs {
 		u.Recovery.Label = ""
 	}
+	for _, img := range []Image{u.Active, u.Recovery} {
+		if err := checkCompression(img.Compression); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -282,6 +428,7 @@ type Partition struct {
 	MountPoint      string
 	Path            string
 	Disk            string
+	UUID            string
 }
 
 type PartitionList []*Partition
@@ -325,6 +472,16 @@ func (pl PartitionList) GetByNameOrLabel(name, label string) *Partition {
 	return part
 }
 
+// GetByUUID gets a partition by its partition UUID from the PartitionList, the match is case insensitive
+func (pl PartitionList) GetByUUID(uuid string) *Partition {
+	for _, p := range pl {
+		if strings.EqualFold(p.UUID, uuid) {
+			return p
+		}
+	}
+	return nil
+}
+
 type ElementalPartitions struct {
 	BIOS       *Partition
 	EFI        *Partition
@@ -488,13 +645,23 @@ func (ep ElementalPartitions) PartitionsByMountPoint(descending bool, excludes .
 
 // Image struct represents a file system image with its commonly configurable values, size in MiB
 type Image struct {
-	File       string
-	Label      string       `yaml:"label,omitempty" mapstructure:"label"`
-	Size       uint         `yaml:"size,omitempty" mapstructure:"size"`
-	FS         string       `yaml:"fs,omitempty" mapstructure:"fs"`
-	Source     *ImageSource `yaml:"uri,omitempty" mapstructure:"uri"`
-	MountPoint string
-	LoopDevice string
+	File   string
+	Label  string       `yaml:"label,omitempty" mapstructure:"label"`
+	Size   uint         `yaml:"size,omitempty" mapstructure:"size"`
+	FS     string       `yaml:"fs,omitempty" mapstructure:"fs"`
+	Source *ImageSource `yaml:"uri,omitempty" mapstructure:"uri"`
+	// Squashfs compression profile, the global compression options apply if empty
+	Compression string `yaml:"compression,omitempty" mapstructure:"compression"`
+	MountPoint  string
+	LoopDevice  string
+}
+
+// checkCompression checks the given squashfs compression profile is known, if set
+func checkCompression(profile string) error {
+	if _, ok := constants.GetSquashfsCompressionProfiles()[profile]; profile != "" && !ok {
+		return fmt.Errorf("unknown squashfs compression profile '%s'", profile)
+	}
+	return nil
 }
 
 // LiveISO represents the configurations needed for a live ISO image
@@ -506,11 +673,96 @@ type LiveISO struct {
 	GrubEntry          string         `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
 	BootloaderInRootFs bool           `yaml:"bootloader-in-rootfs" mapstructure:"bootloader-in-rootfs"`
 	Firmware           string         `yaml:"firmware,omitempty" mapstructure:"firmware"`
+	Compression        string         `yaml:"compression,omitempty" mapstructure:"compression"`
+	Netboot            bool           `yaml:"netboot,omitempty" mapstructure:"netboot"`
+	NetbootURL         string         `yaml:"netboot-url,omitempty" mapstructure:"netboot-url"`
+	CloudConfig        string         `yaml:"cloud-config,omitempty" mapstructure:"cloud-config"`
+	InstallConfig      string         `yaml:"install-config,omitempty" mapstructure:"install-config"`
+	AutoInstall        bool           `yaml:"auto-install,omitempty" mapstructure:"auto-install"`
+	BootMenu           LiveBootMenu   `yaml:"boot-menu,omitempty" mapstructure:"boot-menu"`
+	Bootloader         string         `yaml:"bootloader,omitempty" mapstructure:"bootloader"`
+	UKI                bool           `yaml:"uki,omitempty" mapstructure:"uki"`
+}
+
+// LiveBootMenu defines the grub boot menu of the live ISO. If no entries are defined
+// a single entry named after the LiveISO grub entry name is used.
+type LiveBootMenu struct {
+	Timeout  int             `yaml:"timeout" mapstructure:"timeout"`
+	Default  int             `yaml:"default" mapstructure:"default"`
+	Terminal string          `yaml:"terminal,omitempty" mapstructure:"terminal"`
+	Serial   string          `yaml:"serial,omitempty" mapstructure:"serial"`
+	Entries  []LiveMenuEntry `yaml:"entries,omitempty" mapstructure:"entries"`
+}
+
+// LiveMenuEntry is a live ISO boot menu entry, the command line is appended to the
+// kernel arguments required to boot the live system
+type LiveMenuEntry struct {
+	Title   string `yaml:"title" mapstructure:"title"`
+	Cmdline string `yaml:"cmdline,omitempty" mapstructure:"cmdline"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (m LiveBootMenu) Sanitize() error {
+	if m.Timeout < -1 {
+		return fmt.Errorf("invalid boot menu timeout %d", m.Timeout)
+	}
+	for _, entry := range m.Entries {
+		if entry.Title == "" || strings.Contains(entry.Title, `"`) {
+			return fmt.Errorf("invalid boot menu entry title '%s'", entry.Title)
+		}
+		if strings.ContainsAny(entry.Cmdline, "\n\"") {
+			return fmt.Errorf("invalid kernel command line for boot menu entry '%s'", entry.Title)
+		}
+	}
+	return nil
 }
 
 // Sanitize checks the consistency of the struct, returns error
 // if unsolvable inconsistencies are found
 func (i *LiveISO) Sanitize() error {
+	if err := checkCompression(i.Compression); err != nil {
+		return err
+	}
+	if err := i.BootMenu.Sanitize(); err != nil {
+		return err
+	}
+	switch i.Bootloader {
+	case "", constants.LiveBootloaderGrub:
+	case constants.LiveBootloaderSystemdBoot:
+		if i.Firmware != EFI {
+			return fmt.Errorf("%s bootloader requires %s firmware", i.Bootloader, EFI)
+		}
+	default:
+		return fmt.Errorf("unknown live bootloader '%s'", i.Bootloader)
+	}
+	if i.UKI && i.Firmware != EFI {
+		return fmt.Errorf("unified kernel images require %s firmware", EFI)
+	}
+	if i.AutoInstall && i.InstallConfig == "" {
+		return fmt.Errorf("automated installation requires an install configuration")
+	}
+	// The automated installation entry is appended to the boot menu entries
+	entries := len(i.BootMenu.Entries)
+	if entries == 0 {
+		entries = 1
+	}
+	if i.AutoInstall {
+		entries++
+	}
+	if i.BootMenu.Default < 0 || i.BootMenu.Default >= entries {
+		return fmt.Errorf("default boot menu entry %d is out of range", i.BootMenu.Default)
+	}
+	if i.Netboot {
+		u, err := url.Parse(i.NetbootURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("netboot requires a valid base URL, got '%s'", i.NetbootURL)
+		}
+		// Both iPXE and grub configurations are written, grub only supports these protocols
+		if u.Scheme != "http" && u.Scheme != "tftp" {
+			return fmt.Errorf("netboot base URL must use http or tftp, got '%s'", u.Scheme)
+		}
+	}
 	for _, src := range i.RootFS {
 		if src == nil {
 			return fmt.Errorf("wrong name of source package for rootfs")
@@ -542,9 +794,10 @@ type Repository struct {
 
 // BuildConfig represents the config we need for building isos, raw images, artifacts
 type BuildConfig struct {
-	Date   bool   `yaml:"date,omitempty" mapstructure:"date"`
-	Name   string `yaml:"name,omitempty" mapstructure:"name"`
-	OutDir string `yaml:"output,omitempty" mapstructure:"output"`
+	Date         bool   `yaml:"date,omitempty" mapstructure:"date"`
+	Name         string `yaml:"name,omitempty" mapstructure:"name"`
+	OutDir       string `yaml:"output,omitempty" mapstructure:"output"`
+	Reproducible bool   `yaml:"reproducible,omitempty" mapstructure:"reproducible"`
 
 	// 'inline' and 'squash' labels ensure config fields
 	// are embedded from a yaml and map PoV
@@ -554,6 +807,9 @@ type BuildConfig struct {
 // Sanitize checks the consistency of the struct, returns error
 // if unsolvable inconsistencies are found
 func (b *BuildConfig) Sanitize() error {
+	if b.Reproducible && b.SourceDateEpoch == nil {
+		return fmt.Errorf("reproducible builds require setting %s", constants.SourceDateEpochEnv)
+	}
 	return b.Config.Sanitize()
 }
 
@@ -575,6 +831,8 @@ type ImageState struct {
 	SourceMetadata interface{}  `yaml:"source-metadata,omitempty"`
 	Label          string       `yaml:"label,omitempty"`
 	FS             string       `yaml:"fs,omitempty"`
+	Compression    string       `yaml:"compression,omitempty"`
+	UKI            string       `yaml:"uki,omitempty"`
 }
 
 func (i *ImageState) UnmarshalYAML(value *yaml.Node) error {
@@ -335,6 +335,28 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(p.GetByName("nonexistent")).To(BeNil())
 		})
 	})
+	Describe("SecureBoot", func() {
+		It("runs sanitize method", func() {
+			sb := v1.SecureBoot{}
+			Expect(sb.Sanitize()).To(Succeed())
+			Expect(sb.Enabled()).To(BeFalse())
+
+			// Fails without certificate
+			sb.Key = "/keys/db.key"
+			Expect(sb.Sanitize()).NotTo(Succeed())
+
+			sb.Cert = "/keys/db.crt"
+			Expect(sb.Sanitize()).To(Succeed())
+			Expect(sb.Enabled()).To(BeTrue())
+
+			// Dropping shim requires signing keys
+			sb.NoShim = true
+			Expect(sb.Sanitize()).To(Succeed())
+			sb.Key = ""
+			sb.Cert = ""
+			Expect(sb.Sanitize()).NotTo(Succeed())
+		})
+	})
 	Describe("InstallSpec", func() {
 		var spec *v1.InstallSpec
 
@@ -373,6 +395,28 @@ var _ = Describe("Types", Label("types", "config"), func() {
 				err = spec.Sanitize()
 				Expect(err).Should(HaveOccurred())
 			})
+			It("fails to create unified kernel images without EFI firmware", func() {
+				spec.Active.Source = v1.NewDirSrc("/dir")
+				spec.UKI = true
+				spec.Firmware = v1.BIOS
+				Expect(spec.Sanitize()).Should(HaveOccurred())
+				spec.Firmware = v1.EFI
+				Expect(spec.Sanitize()).Should(Succeed())
+			})
+			It("fails with an invalid target selector", func() {
+				spec.Active.Source = v1.NewDirSrc("/dir")
+				spec.TargetSelector = &v1.DiskSelector{MinSize: 2048, MaxSize: 1024}
+				Expect(spec.Sanitize()).Should(HaveOccurred())
+
+				spec.TargetSelector = &v1.DiskSelector{Model: "[invalid"}
+				Expect(spec.Sanitize()).Should(HaveOccurred())
+
+				spec.TargetSelector = &v1.DiskSelector{ByID: "[invalid"}
+				Expect(spec.Sanitize()).Should(HaveOccurred())
+
+				spec.TargetSelector = &v1.DiskSelector{MinSize: 1024, Model: "^Samsung", ByID: "nvme-*"}
+				Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			})
 			Describe("with extra partitions", func() {
 				BeforeEach(func() {
 					// Set a source for the install
@@ -455,6 +499,12 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			err := spec.Sanitize()
 			Expect(err).ShouldNot(HaveOccurred())
 
+			// Unified kernel images require the EFI partition
+			spec.UKI = true
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.Partitions.EFI = &v1.Partition{MountPoint: "mountpoint"}
+			Expect(spec.Sanitize()).Should(Succeed())
+
 			// Sets image labels to empty string on squashfs
 			spec.Active.FS = constants.SquashFs
 			spec.Recovery.FS = constants.SquashFs
@@ -501,6 +551,53 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
 			Expect(iso.Sanitize()).ShouldNot(HaveOccurred())
 
+			//Fails on unknown compression profiles
+			spec.Compression = constants.SquashCompressionZstd19
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.Compression = "brotli"
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.Compression = ""
+
+			//Fails on netboot without a valid base URL
+			spec.Netboot = true
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.NetbootURL = "boot/images"
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.NetbootURL = "https://boot.example.com/images"
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.NetbootURL = "http://boot.example.com/images"
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.Netboot = false
+
+			//Fails on automated installation without an install configuration
+			spec.AutoInstall = true
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.InstallConfig = "/some/install.yaml"
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+
+			//Fails on inconsistent boot menus
+			spec.BootMenu.Default = 1
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.BootMenu.Default = 2
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.BootMenu.Default = 0
+			spec.BootMenu.Entries = []v1.LiveMenuEntry{{Title: `Quoted "title"`}}
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.BootMenu.Entries = []v1.LiveMenuEntry{{Title: "Debug", Cmdline: "rd.debug"}}
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.BootMenu.Timeout = -2
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.BootMenu.Timeout = 0
+
+			//Fails on unknown bootloaders or systemd-boot without EFI firmware
+			spec.Firmware = v1.EFI
+			spec.Bootloader = constants.LiveBootloaderSystemdBoot
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.Firmware = v1.BIOS
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+			spec.Bootloader = "lilo"
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+
 			//Fails when packages were provided in incorrect format
 			spec = &v1.LiveISO{
 				RootFS: []*v1.ImageSource{
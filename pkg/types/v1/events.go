@@ -0,0 +1,124 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	ActionStartedEvent  EventType = "action-started"
+	ActionFinishedEvent EventType = "action-finished"
+	StepStartedEvent    EventType = "step-started"
+	StepFinishedEvent   EventType = "step-finished"
+	ProgressEvent       EventType = "progress"
+	HookEvent           EventType = "hook"
+
+	EventStatusSuccess = "success"
+	EventStatusFailed  = "failed"
+
+	ProgressUnitBytes = "bytes"
+	ProgressUnitFiles = "files"
+)
+
+// Event is a machine readable progress event. Steps are named after the pkg/error exit code
+// returned when they fail.
+type Event struct {
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action,omitempty"`
+	Step   string    `json:"step,omitempty"`
+	Hook   string    `json:"hook,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Unit   string    `json:"unit,omitempty"`
+	Done   int64     `json:"done,omitempty"`
+	Total  int64     `json:"total,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Code   int       `json:"code,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// EventEmitter is the interface to publish progress events
+type EventEmitter interface {
+	Emit(Event)
+}
+
+type jsonEventEmitter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONEventEmitter returns an EventEmitter writing each event as a JSON line to the given writer
+func NewJSONEventEmitter(w io.Writer) EventEmitter {
+	return &jsonEventEmitter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonEventEmitter) Emit(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_ = j.enc.Encode(e)
+}
+
+// NewProgressEvent returns a progress event of the given source measured in the given unit,
+// total is zero if unknown
+func NewProgressEvent(source, unit string, done, total int64) Event {
+	return Event{Type: ProgressEvent, Source: source, Unit: unit, Done: done, Total: total}
+}
+
+// NewActionEvent returns an action started event, or an action finished event if finished is set
+func NewActionEvent(action string, finished bool, err error) Event {
+	if !finished {
+		return Event{Type: ActionStartedEvent, Action: action}
+	}
+	return withStatus(Event{Type: ActionFinishedEvent, Action: action}, err)
+}
+
+// NewStepEvent returns a step started event, or a step finished event if finished is set
+func NewStepEvent(step string, finished bool, err error) Event {
+	if !finished {
+		return Event{Type: StepStartedEvent, Step: step}
+	}
+	return withStatus(Event{Type: StepFinishedEvent, Step: step}, err)
+}
+
+// NewHookEvent returns the event reporting the result of the given hook
+func NewHookEvent(hook string, err error) Event {
+	return withStatus(Event{Type: HookEvent, Hook: hook}, err)
+}
+
+// withStatus sets the status of the event according to the given error, the exit code
+// is also included for errors carrying one
+func withStatus(e Event, err error) Event {
+	var exitErr interface{ ExitCode() int }
+
+	if err == nil {
+		e.Status = EventStatusSuccess
+		return e
+	}
+	e.Status = EventStatusFailed
+	e.Error = err.Error()
+	if errors.As(err, &exitErr) {
+		e.Code = exitErr.ExitCode()
+	}
+	return e
+}
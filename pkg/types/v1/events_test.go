@@ -0,0 +1,88 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	elementalError "github.com/rancher/elemental-cli/pkg/error"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+)
+
+var _ = Describe("events", Label("events", "logger", "types"), func() {
+	var buf *bytes.Buffer
+	var logger v1.Logger
+
+	decode := func() []v1.Event {
+		var events []v1.Event
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var e v1.Event
+			Expect(json.Unmarshal([]byte(line), &e)).To(Succeed())
+			events = append(events, e)
+		}
+		return events
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		logger = v1.NewNullLogger()
+	})
+	It("Discards events if no emitter is set", func() {
+		logger.Emit(v1.NewActionEvent("install", false, nil))
+		Expect(buf.Len()).To(Equal(0))
+	})
+	It("Writes events as JSON lines", func() {
+		logger.SetEventEmitter(v1.NewJSONEventEmitter(buf))
+		logger.Emit(v1.NewActionEvent("install", false, nil))
+		logger.Emit(v1.NewProgressEvent("/some/source", v1.ProgressUnitBytes, 512, 1024))
+		logger.Emit(v1.NewActionEvent("install", true, nil))
+
+		events := decode()
+		Expect(len(events)).To(Equal(3))
+		Expect(events[0].Type).To(Equal(v1.ActionStartedEvent))
+		Expect(events[0].Action).To(Equal("install"))
+		Expect(events[0].Time.IsZero()).To(BeFalse())
+		Expect(events[1].Type).To(Equal(v1.ProgressEvent))
+		Expect(events[1].Unit).To(Equal(v1.ProgressUnitBytes))
+		Expect(events[1].Done).To(Equal(int64(512)))
+		Expect(events[1].Total).To(Equal(int64(1024)))
+		Expect(events[2].Type).To(Equal(v1.ActionFinishedEvent))
+		Expect(events[2].Status).To(Equal(v1.EventStatusSuccess))
+	})
+	It("Includes the error and its exit code on failed events", func() {
+		logger.SetEventEmitter(v1.NewJSONEventEmitter(buf))
+		logger.Emit(v1.NewStepEvent("InstallGrub", true, elementalError.New("grub failed", elementalError.InstallGrub)))
+		logger.Emit(v1.NewHookEvent("after-install", errors.New("hook failed")))
+
+		events := decode()
+		Expect(len(events)).To(Equal(2))
+		Expect(events[0].Type).To(Equal(v1.StepFinishedEvent))
+		Expect(events[0].Step).To(Equal("InstallGrub"))
+		Expect(events[0].Status).To(Equal(v1.EventStatusFailed))
+		Expect(events[0].Error).To(Equal("grub failed"))
+		Expect(events[0].Code).To(Equal(elementalError.InstallGrub))
+		Expect(events[1].Type).To(Equal(v1.HookEvent))
+		Expect(events[1].Hook).To(Equal("after-install"))
+		Expect(events[1].Status).To(Equal(v1.EventStatusFailed))
+		Expect(events[1].Code).To(Equal(0))
+	})
+})
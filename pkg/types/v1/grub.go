@@ -20,6 +20,31 @@ import (
 	"github.com/rancher/elemental-cli/pkg/constants"
 )
 
+// ExtraCmdline holds the extra kernel command line arguments of each image, they are
+// persisted in the grub OEM environment file
+type ExtraCmdline struct {
+	Active   string `yaml:"active,omitempty" mapstructure:"active"`
+	Passive  string `yaml:"passive,omitempty" mapstructure:"passive"`
+	Recovery string `yaml:"recovery,omitempty" mapstructure:"recovery"`
+}
+
+// GetGrubVars returns the grub environment variables of the images with an extra kernel
+// command line, images without it keep any previously set value
+func (c ExtraCmdline) GetGrubVars() map[string]string {
+	grubVars := map[string]string{}
+	vars := constants.GetGrubCmdlineVars()
+	for img, cmdline := range map[string]string{
+		constants.ActiveImgName:   c.Active,
+		constants.PassiveImgName:  c.Passive,
+		constants.RecoveryImgName: c.Recovery,
+	} {
+		if cmdline != "" {
+			grubVars[vars[img]] = cmdline
+		}
+	}
+	return grubVars
+}
+
 func (i InstallSpec) GetGrubLabels() map[string]string {
 	grubEnv := map[string]string{
 		"state_label":    i.Partitions.State.FilesystemLabel,
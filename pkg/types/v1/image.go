@@ -18,7 +18,9 @@ package v1
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/containerd/containerd/archive"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -33,7 +35,11 @@ type ImageExtractor interface {
 	ExtractImage(imageRef, destination, platformRef string, local bool) error
 }
 
-type OCIImageExtractor struct{}
+// OCIImageExtractor extracts OCI images, the extraction progress is reported to
+// the Logger if set
+type OCIImageExtractor struct {
+	Logger Logger
+}
 
 var _ ImageExtractor = OCIImageExtractor{}
 
@@ -54,11 +60,37 @@ func (e OCIImageExtractor) ExtractImage(imageRef, destination, platformRef strin
 	}
 
 	reader := mutate.Extract(image)
+	defer reader.Close()
+
+	var src io.Reader = reader
+	if e.Logger != nil {
+		src = &progressReader{reader: reader, source: imageRef, log: e.Logger}
+	}
 
-	_, err = archive.Apply(context.Background(), destination, reader)
+	_, err = archive.Apply(context.Background(), destination, src)
 	return err
 }
 
+// progressReader emits progress events of the bytes read, at most once per second
+type progressReader struct {
+	reader io.Reader
+	source string
+	log    Logger
+	done   int64
+	last   time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.done += int64(n)
+	// The uncompressed size of the image is unknown, hence no total is reported
+	if err == io.EOF || time.Since(p.last) >= time.Second {
+		p.log.Emit(NewProgressEvent(p.source, ProgressUnitBytes, p.done, 0))
+		p.last = time.Now()
+	}
+	return n, err
+}
+
 func image(ref name.Reference, platform v1.Platform, local bool) (v1.Image, error) {
 	if local {
 		return daemon.Image(ref)
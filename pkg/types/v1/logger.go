@@ -22,6 +22,7 @@ import (
 	"io"
 	"regexp"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -54,6 +55,9 @@ type Logger interface {
 	Spinner()
 	Ask() bool
 	Screen(string)
+
+	SetEventEmitter(EventEmitter)
+	Emit(Event)
 }
 
 func DebugLevel() log.Level {
@@ -87,6 +91,7 @@ func NewBufferLogger(b *bytes.Buffer) Logger {
 
 type logrusWrapper struct {
 	*log.Logger
+	events EventEmitter
 }
 
 func newLogrusWrapper(l *log.Logger) Logger {
@@ -155,6 +160,22 @@ func convert(args []interface{}) string {
 	return strings.Join(together, " ") // return them nicely joined with spaces like a normal phrase
 }
 
+// SetEventEmitter sets the emitter of progress events, events are discarded if unset
+func (w *logrusWrapper) SetEventEmitter(e EventEmitter) {
+	w.events = e
+}
+
+// Emit publishes the given event to the event emitter if any
+func (w *logrusWrapper) Emit(e Event) {
+	if w.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	w.events.Emit(e)
+}
+
 func (w *logrusWrapper) SetContext(string) {}
 func (w *logrusWrapper) Spinner()          {}
 func (w *logrusWrapper) SpinnerStop()      {}
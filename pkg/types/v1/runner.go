@@ -17,22 +17,41 @@ limitations under the License.
 package v1
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Runner interface {
 	InitCmd(string, ...string) *exec.Cmd
 	Run(string, ...string) ([]byte, error)
 	RunCmd(cmd *exec.Cmd) ([]byte, error)
+	RunContext(context.Context, string, ...string) ([]byte, error)
+	RunCmdContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error)
 	CommandExists(command string) bool
 	GetLogger() Logger
 	SetLogger(logger Logger)
+	SetContext(ctx context.Context)
+	LastCommand() (string, []byte)
 }
 
 type RealRunner struct {
 	Logger Logger
+	// Context bounding the commands run without an explicit context, when cancelled
+	// the running command is killed and further commands fail
+	Context context.Context
+	// Timeouts by command name, commands not listed run without timeout
+	Timeouts map[string]time.Duration
+	// Last command run and its output
+	lastCmd string
+	lastOut []byte
 }
 
 func (r RealRunner) CommandExists(command string) bool {
@@ -44,20 +63,80 @@ func (r RealRunner) InitCmd(command string, args ...string) *exec.Cmd {
 	return exec.Command(command, args...)
 }
 
-func (r RealRunner) RunCmd(cmd *exec.Cmd) ([]byte, error) {
-	return cmd.CombinedOutput()
+func (r *RealRunner) RunCmd(cmd *exec.Cmd) ([]byte, error) {
+	return r.RunCmdContext(r.context(), cmd)
 }
 
-func (r RealRunner) Run(command string, args ...string) ([]byte, error) {
+// RunCmdContext runs the given command streaming its output lines to the debug log. The command
+// and its child processes are killed if the given context is done or once the timeout of the
+// command expires. The command and its output are kept as the last command run.
+func (r *RealRunner) RunCmdContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	r.lastCmd = strings.Join(cmd.Args, " ")
+	r.lastOut = nil
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, errors.New("exec: Stdout or Stderr already set")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if timeout, ok := r.Timeouts[filepath.Base(cmd.Args[0])]; ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	out := &lineLogger{log: r.Logger}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Run the command in its own process group, so killing it also kills the children
+	// holding its output pipes, otherwise Wait blocks until they exit
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	out.flush()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %s", ctx.Err(), err.Error())
+	}
+	r.lastOut = out.buf.Bytes()
+	return r.lastOut, err
+}
+
+func (r *RealRunner) Run(command string, args ...string) ([]byte, error) {
+	return r.RunContext(r.context(), command, args...)
+}
+
+// RunContext runs the given command bound to the given context, see RunCmdContext
+func (r *RealRunner) RunContext(ctx context.Context, command string, args ...string) ([]byte, error) {
 	r.debug(fmt.Sprintf("Running cmd: '%s %s'", command, strings.Join(args, " ")))
 	cmd := r.InitCmd(command, args...)
-	out, err := r.RunCmd(cmd)
+	out, err := r.RunCmdContext(ctx, cmd)
 	if err != nil {
 		r.error(fmt.Sprintf("Error running command: %s", err.Error()))
 	}
 	return out, err
 }
 
+// LastCommand returns the last command run and its output
+func (r RealRunner) LastCommand() (string, []byte) {
+	return r.lastCmd, r.lastOut
+}
+
 func (r RealRunner) GetLogger() Logger {
 	return r.Logger
 }
@@ -66,6 +145,18 @@ func (r *RealRunner) SetLogger(logger Logger) {
 	r.Logger = logger
 }
 
+// SetContext sets the context bounding the commands run without an explicit context
+func (r *RealRunner) SetContext(ctx context.Context) {
+	r.Context = ctx
+}
+
+func (r RealRunner) context() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
+}
+
 func (r RealRunner) error(msg string) {
 	if r.Logger != nil {
 		r.Logger.Error(msg)
@@ -77,3 +168,45 @@ func (r RealRunner) debug(msg string) {
 		r.Logger.Debug(msg)
 	}
 }
+
+// lineLogger collects the combined output of a command and logs each line as soon as it
+// is complete
+type lineLogger struct {
+	buf     bytes.Buffer
+	mu      sync.Mutex
+	log     Logger
+	partial []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.logLine(l.partial[:i])
+		l.partial = l.partial[i+1:]
+	}
+	return l.buf.Write(p)
+}
+
+// flush logs the last line of the output if it has no line break
+func (l *lineLogger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.partial) > 0 {
+		l.logLine(l.partial)
+		l.partial = nil
+	}
+}
+
+func (l *lineLogger) logLine(line []byte) {
+	if l.log != nil {
+		l.log.Debug(strings.TrimRight(string(line), "\r"))
+	}
+}
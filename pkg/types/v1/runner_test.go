@@ -18,6 +18,9 @@ package v1_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -69,6 +72,62 @@ var _ = Describe("Runner", Label("types", "runner"), func() {
 		Expect(err).NotTo(BeNil())
 		Expect(memLog.String()).To(ContainSubstring("not found"))
 	})
+	It("streams the command output lines to the debug log", func() {
+		memLog := &bytes.Buffer{}
+		logger := v1.NewBufferLogger(memLog)
+		logger.SetLevel(logrus.DebugLevel)
+		r := v1.RealRunner{Logger: logger}
+		out, err := r.Run("sh", "-c", "echo first; echo second >&2; printf last")
+		Expect(err).To(BeNil())
+		Expect(string(out)).To(Equal("first\nsecond\nlast"))
+		Expect(memLog.String()).To(ContainSubstring("msg=first"))
+		Expect(memLog.String()).To(ContainSubstring("msg=second"))
+		Expect(memLog.String()).To(ContainSubstring("msg=last"))
+	})
+	It("keeps the last command run and its output", func() {
+		r := v1.RealRunner{}
+		_, err := r.Run("echo", "-n", "first")
+		Expect(err).To(BeNil())
+		_, err = r.RunCmd(r.InitCmd("sh", "-c", "printf second; exit 1"))
+		Expect(err).NotTo(BeNil())
+		cmd, out := r.LastCommand()
+		Expect(cmd).To(Equal("sh -c printf second; exit 1"))
+		Expect(string(out)).To(Equal("second"))
+	})
+	It("kills commands exceeding their timeout", func() {
+		r := v1.RealRunner{Timeouts: map[string]time.Duration{"sleep": 100 * time.Millisecond}}
+		start := time.Now()
+		_, err := r.Run("sleep", "10")
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+	It("kills the children of commands exceeding their timeout", func() {
+		r := v1.RealRunner{Timeouts: map[string]time.Duration{"sh": 100 * time.Millisecond}}
+		start := time.Now()
+		_, err := r.Run("sh", "-c", "sleep 100 & wait")
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+	It("kills the running command and refuses new ones once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := v1.RealRunner{}
+		r.SetContext(ctx)
+		time.AfterFunc(100*time.Millisecond, cancel)
+		_, err := r.Run("sleep", "10")
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = r.Run("true")
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		_, err = r.RunContext(context.Background(), "true")
+		Expect(err).To(BeNil())
+	})
+	It("does not run commands on the fake runner once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := v1mock.NewFakeRunner()
+		_, err := r.RunContext(ctx, "pwd")
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		Expect(r.CmdsMatch([][]string{{"pwd"}})).To(Succeed())
+	})
 	It("returns false if command does not exists", func() {
 		r := v1.RealRunner{}
 		exists := r.CommandExists("THISCOMMANDSHOULDNOTBETHERECOMEON")
@@ -0,0 +1,152 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	cnst "github.com/rancher/elemental-cli/pkg/constants"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+)
+
+// GetBuiltinBootloaderProfiles returns the bootloader profiles of the distributions supported out of the box
+func GetBuiltinBootloaderProfiles() []v1.BootloaderProfile {
+	return []v1.BootloaderProfile{
+		{
+			Name: "suse",
+			IDs:  []string{"suse", "opensuse", "opensuse-leap", "opensuse-tumbleweed", "opensuse-microos", "sles", "sle-micro", "sle-micro-for-rancher", "sl-micro"},
+			EFI: map[string]v1.EFIBinaries{
+				cnst.Archx86: {
+					Shim:       v1.EFIBinary{Name: "shim.efi"},
+					MokManager: v1.EFIBinary{Name: "MokManager.efi"},
+					Grub:       v1.EFIBinary{Name: "grub.efi"},
+				},
+				cnst.ArchArm64: {
+					Shim:       v1.EFIBinary{Name: "shim.efi"},
+					MokManager: v1.EFIBinary{Name: "MokManager.efi"},
+					Grub:       v1.EFIBinary{Name: "grub.efi"},
+				},
+			},
+		}, {
+			Name: "fedora",
+			IDs:  []string{cnst.Fedora, "rhel", "centos", "rocky", "almalinux"},
+			EFI: map[string]v1.EFIBinaries{
+				cnst.Archx86: {
+					Shim:       v1.EFIBinary{Name: "shimx64.efi"},
+					MokManager: v1.EFIBinary{Name: "mmx64.efi"},
+					Grub:       v1.EFIBinary{Name: "grubx64.efi"},
+				},
+				cnst.ArchArm64: {
+					Shim:       v1.EFIBinary{Name: "shimaa64.efi"},
+					MokManager: v1.EFIBinary{Name: "mmaa64.efi"},
+					Grub:       v1.EFIBinary{Name: "grubaa64.efi"},
+				},
+			},
+		}, {
+			// Signed binaries are shipped with a .signed suffix, shim looks for them without it
+			Name: "debian",
+			IDs:  []string{"debian", cnst.Ubuntu},
+			EFI: map[string]v1.EFIBinaries{
+				cnst.Archx86: {
+					Shim:       v1.EFIBinary{Name: "shimx64.efi", Sources: []string{"shimx64.efi.signed", "shimx64.efi"}},
+					MokManager: v1.EFIBinary{Name: "mmx64.efi", Sources: []string{"mmx64.efi.signed", "mmx64.efi"}},
+					Grub:       v1.EFIBinary{Name: "grubx64.efi", Sources: []string{"grubx64.efi.signed", "grubx64.efi"}},
+				},
+				cnst.ArchArm64: {
+					Shim:       v1.EFIBinary{Name: "shimaa64.efi", Sources: []string{"shimaa64.efi.signed", "shimaa64.efi"}},
+					MokManager: v1.EFIBinary{Name: "mmaa64.efi", Sources: []string{"mmaa64.efi.signed", "mmaa64.efi"}},
+					Grub:       v1.EFIBinary{Name: "grubaa64.efi", Sources: []string{"grubaa64.efi.signed", "grubaa64.efi"}},
+				},
+			},
+		}, {
+			// Arch does not ship a shim nor a prebuilt grub, grub is built and booted directly
+			Name: "arch",
+			IDs:  []string{"arch", "archarm"},
+			EFI: map[string]v1.EFIBinaries{
+				cnst.Archx86: {
+					Grub: v1.EFIBinary{Name: "grubx64.efi", Unsigned: true},
+				},
+				cnst.ArchArm64: {
+					Grub: v1.EFIBinary{Name: "grubaa64.efi", Unsigned: true},
+				},
+			},
+		},
+	}
+}
+
+// LoadBootloaderProfiles reads all the bootloader profiles from the YAML files of the given
+// directory, one profile per file. A non existing directory results in no profiles.
+func LoadBootloaderProfiles(fs v1.FS, dir string) ([]v1.BootloaderProfile, error) {
+	profiles := []v1.BootloaderProfile{}
+
+	if exists, _ := Exists(fs, dir); !exists {
+		return profiles, nil
+	}
+
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".yaml" {
+			continue
+		}
+		data, err := fs.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var profile v1.BootloaderProfile
+		err = yaml.Unmarshal(data, &profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing bootloader profile %s: %w", f.Name(), err)
+		}
+		err = profile.Sanitize()
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootloader profile %s: %w", f.Name(), err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// GetBootloaderProfile returns the bootloader profile matching the given os-release data. The
+// ID is matched first and then each ID_LIKE value in order, custom profiles of the configuration
+// are preferred over the built-in ones.
+func GetBootloaderProfile(cfg *v1.Config, osRelease map[string]string) (*v1.BootloaderProfile, error) {
+	profiles := append([]v1.BootloaderProfile{}, cfg.BootloaderProfiles...)
+	profiles = append(profiles, GetBuiltinBootloaderProfiles()...)
+
+	ids := append([]string{osRelease["ID"]}, strings.Fields(osRelease["ID_LIKE"])...)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		for _, p := range profiles {
+			if p.Matches(id) {
+				return &p, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no bootloader profile found for ID '%s' ID_LIKE '%s'", osRelease["ID"], osRelease["ID_LIKE"])
+}
@@ -33,7 +33,6 @@ import (
 
 	"github.com/distribution/distribution/reference"
 	"github.com/joho/godotenv"
-	"github.com/rancher-sandbox/grsync"
 	"github.com/twpayne/go-vfs"
 
 	cnst "github.com/rancher/elemental-cli/pkg/constants"
@@ -156,68 +155,6 @@ func CreateDirStructure(fs v1.FS, target string) error {
 	return nil
 }
 
-// SyncData rsync's source folder contents to a target folder content,
-// both are expected to exist before hand.
-func SyncData(log v1.Logger, fs v1.FS, source string, target string, excludes ...string) error {
-	if fs != nil {
-		if s, err := fs.RawPath(source); err == nil {
-			source = s
-		}
-		if t, err := fs.RawPath(target); err == nil {
-			target = t
-		}
-	}
-
-	if !strings.HasSuffix(source, "/") {
-		source = fmt.Sprintf("%s/", source)
-	}
-
-	if !strings.HasSuffix(target, "/") {
-		target = fmt.Sprintf("%s/", target)
-	}
-
-	task := grsync.NewTask(
-		source,
-		target,
-		grsync.RsyncOptions{
-			Quiet:   false,
-			Archive: true,
-			XAttrs:  true,
-			ACLs:    true,
-			Exclude: excludes,
-		},
-	)
-
-	quit := make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-quit:
-				return
-			case <-time.After(5 * time.Second):
-				state := task.State()
-				log.Debugf(
-					"progress rsync %s to %s: %.2f / rem. %d / tot. %d / sp. %s",
-					source,
-					target,
-					state.Progress,
-					state.Remain,
-					state.Total,
-					state.Speed,
-				)
-			}
-		}
-	}()
-
-	err := task.Run()
-	quit <- true
-	if err != nil {
-		return fmt.Errorf("%w: %s", err, strings.Join([]string{task.Log().Stderr, task.Log().Stdout}, "\n"))
-	}
-
-	return nil
-}
-
 // Reboot reboots the system afater the given delay (in seconds) time passed.
 func Reboot(runner v1.Runner, delay time.Duration) error {
 	time.Sleep(delay * time.Second)
@@ -286,6 +223,75 @@ func CreateSquashFS(runner v1.Runner, logger v1.Logger, source string, destinati
 	return nil
 }
 
+// SquashfsOptions returns the mksquashfs options for the given compression profile, the
+// compression options of the configuration are used if no profile is given. It fails if the
+// profile is unknown or its compressor is not supported by the available mksquashfs.
+// All timestamps are fixed to SOURCE_DATE_EPOCH, if set.
+func SquashfsOptions(cfg *v1.Config, profile string) ([]string, error) {
+	options := cnst.GetDefaultSquashfsOptions()
+	if cfg.SourceDateEpoch != nil {
+		epoch := strconv.FormatInt(cfg.SourceDateEpoch.Unix(), 10)
+		options = append(options, "-mkfs-time", epoch, "-all-time", epoch)
+	}
+
+	if profile == "" {
+		return append(options, cfg.SquashFsCompressionConfig...), nil
+	}
+
+	compression, ok := cnst.GetSquashfsCompressionProfiles()[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown squashfs compression profile '%s'", profile)
+	}
+
+	if profile != cnst.SquashCompressionNone {
+		compressor := compression[1]
+		supported := squashfsCompressors(cfg.Runner)
+		if len(supported) == 0 {
+			cfg.Logger.Warnf("could not determine the compressors supported by mksquashfs, assuming %s is", compressor)
+			return append(options, compression...), nil
+		}
+		found := false
+		for _, c := range supported {
+			found = found || c == compressor
+		}
+		if !found {
+			return nil, fmt.Errorf("compression profile '%s' requires %s, not supported by mksquashfs", profile, compressor)
+		}
+	}
+	return append(options, compression...), nil
+}
+
+// SquashfsSupportsFixedTimes checks the available mksquashfs allows fixing the
+// filesystem and files timestamps, required for reproducible images
+func SquashfsSupportsFixedTimes(runner v1.Runner) bool {
+	// mksquashfs exits with an error code after printing the help
+	out, _ := runner.Run("mksquashfs", "-help")
+	return strings.Contains(string(out), "-mkfs-time") && strings.Contains(string(out), "-all-time")
+}
+
+// squashfsCompressors returns the compressors listed in the mksquashfs help
+func squashfsCompressors(runner v1.Runner) []string {
+	var compressors []string
+
+	// mksquashfs exits with an error code after printing the help
+	out, _ := runner.Run("mksquashfs", "-help")
+	inList := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "Compressors available") {
+			inList = true
+			continue
+		}
+		// Compressors are indented by one tab, their options by more
+		if !inList || !strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "\t\t") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			compressors = append(compressors, fields[0])
+		}
+	}
+	return compressors
+}
+
 // LoadEnvFile will try to parse the file given and return a map with the kye/values
 func LoadEnvFile(fs v1.FS, file string) (map[string]string, error) {
 	var envMap map[string]string
@@ -492,33 +498,43 @@ func CalcFileChecksum(fs v1.FS, fileName string) (string, error) {
 
 // IdentifySourceSystem tries to find the os-release file in a given dir and identify the system based on the data in there
 func IdentifySourceSystem(vfs v1.FS, path string) (string, error) {
-	var system string
-	var found bool
+	osRelease, err := ReadOsRelease(vfs, path)
+	if err != nil {
+		return "", err
+	}
+	switch osRelease["ID"] {
+	case cnst.Fedora:
+		return cnst.Fedora, nil
+	case cnst.Ubuntu:
+		return cnst.Ubuntu, nil
+	default:
+		return cnst.Suse, nil
+	}
+}
+
+// ReadOsRelease parses the os-release file of the system at the given dir. The standard locations
+// are checked first, otherwise the dir is walked looking for any os-release file.
+func ReadOsRelease(vfs v1.FS, path string) (map[string]string, error) {
+	for _, f := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if exists, _ := Exists(vfs, filepath.Join(path, f)); exists {
+			return parseOsRelease(vfs, filepath.Join(path, f))
+		}
+	}
+
+	var osRelease map[string]string
 	err := WalkDirFs(vfs, path, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		if d.Name() == "os-release" {
-			osRelease, err := parseOsRelease(vfs, path)
-			if err != nil {
-				return err
-			}
-			switch osRelease["ID"] {
-			case cnst.Fedora:
-				system = cnst.Fedora
-			case cnst.Ubuntu:
-				system = cnst.Ubuntu
-			default:
-				system = cnst.Suse
-			}
-			found = true
+			osRelease, err = parseOsRelease(vfs, path)
 		}
 		return err
 	})
-	if !found {
+	if osRelease == nil {
 		err = fmt.Errorf("could not find os-release file under %s", path)
 	}
-	return system, err
+	return osRelease, err
 }
 
 func parseOsRelease(fs v1.FS, filename string) (osrelease map[string]string, err error) {
@@ -99,6 +99,20 @@ func MkdirAll(fs v1.FS, name string, mode os.FileMode) (err error) {
 	return os.MkdirAll(name, mode)
 }
 
+// Rename renames (moves) oldpath to newpath, replacing newpath if it already exists
+func Rename(fs v1.FS, oldpath, newpath string) (err error) {
+	if _, isReadOnly := fs.(*vfs.ReadOnlyFS); isReadOnly {
+		return permError("rename", oldpath)
+	}
+	if oldpath, err = fs.RawPath(oldpath); err != nil {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: err}
+	}
+	if newpath, err = fs.RawPath(newpath); err != nil {
+		return &os.PathError{Op: "rename", Path: newpath, Err: err}
+	}
+	return os.Rename(oldpath, newpath)
+}
+
 // permError returns an *os.PathError with Err syscall.EPERM.
 func permError(op, path string) error {
 	return &os.PathError{
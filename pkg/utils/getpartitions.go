@@ -19,11 +19,13 @@ package utils
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/jaypipes/ghw"
 	"github.com/jaypipes/ghw/pkg/block"
 	ghwUtil "github.com/jaypipes/ghw/pkg/util"
+	cnst "github.com/rancher/elemental-cli/pkg/constants"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
 )
 
@@ -38,6 +40,7 @@ func ghwPartitionToInternalPartition(partition *block.Partition) *v1.Partition {
 		MountPoint:      partition.MountPoint,
 		Path:            filepath.Join("/dev", partition.Name),
 		Disk:            filepath.Join("/dev", partition.Disk.Name),
+		UUID:            partition.UUID,
 	}
 }
 
@@ -56,6 +59,208 @@ func GetAllPartitions() (v1.PartitionList, error) {
 	return parts, nil
 }
 
+// GetDiskByAttributes returns the device path of the disk matching the given size in MiB
+// and model. A zero size or an empty model are not considered for the match. It fails if
+// no disk or more than one disk match.
+func GetDiskByAttributes(size uint, model string) (string, error) {
+	var names []string
+
+	if size == 0 && model == "" {
+		return "", fmt.Errorf("no disk attributes given")
+	}
+	blockDevices, err := block.New(ghw.WithDisableTools(), ghw.WithDisableWarnings())
+	if err != nil {
+		return "", err
+	}
+	for _, disk := range blockDevices.Disks {
+		if size > 0 && uint(disk.SizeBytes/(1024*1024)) != size {
+			continue
+		}
+		if model != "" && disk.Model != model {
+			continue
+		}
+		names = append(names, filepath.Join("/dev", disk.Name))
+	}
+
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("could not find a disk of %d MiB and model '%s'", size, model)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("multiple disks of %d MiB and model '%s' found: %s", size, model, strings.Join(names, ", "))
+	}
+}
+
+// SelectDisk returns the device path of the disk matching all the rules of the given selector.
+// It fails if no disk or more than one disk match.
+func SelectDisk(fs v1.FS, runner v1.Runner, selector *v1.DiskSelector) (string, error) {
+	var candidates []*block.Disk
+	var byID []string
+	var err error
+
+	if selector == nil {
+		return "", fmt.Errorf("no disk selector given")
+	}
+
+	if selector.ByID != "" {
+		byID, err = disksByID(fs, selector.ByID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	blockDevices, err := block.New(ghw.WithDisableTools(), ghw.WithDisableWarnings())
+	if err != nil {
+		return "", err
+	}
+	for _, disk := range blockDevices.Disks {
+		if matchesDiskSelector(disk, selector, byID) {
+			candidates = append(candidates, disk)
+		}
+	}
+
+	if selector.LargestEmpty {
+		var empty []*block.Disk
+		var largest uint64
+		for _, disk := range candidates {
+			if len(disk.Partitions) > 0 {
+				continue
+			}
+			inUse, err := diskHasSignatures(runner, filepath.Join("/dev", disk.Name))
+			if err != nil {
+				return "", err
+			}
+			if inUse {
+				continue
+			}
+			empty = append(empty, disk)
+			if disk.SizeBytes > largest {
+				largest = disk.SizeBytes
+			}
+		}
+		// Several empty disks of the same size are reported as multiple matches
+		candidates = nil
+		for _, disk := range empty {
+			if disk.SizeBytes == largest {
+				candidates = append(candidates, disk)
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no disk matches the target selector")
+	case 1:
+		return filepath.Join("/dev", candidates[0].Name), nil
+	default:
+		var names []string
+		for _, disk := range candidates {
+			names = append(names, filepath.Join("/dev", disk.Name))
+		}
+		return "", fmt.Errorf("multiple disks match the target selector: %s", strings.Join(names, ", "))
+	}
+}
+
+// matchesDiskSelector checks if the given disk matches all the rules of the given selector,
+// byID includes the disk names pointed by the selector by-id pattern
+func matchesDiskSelector(disk *block.Disk, selector *v1.DiskSelector, byID []string) bool {
+	sizeMiB := uint(disk.SizeBytes / (1024 * 1024))
+	if selector.MinSize > 0 && sizeMiB < selector.MinSize {
+		return false
+	}
+	if selector.MaxSize > 0 && sizeMiB > selector.MaxSize {
+		return false
+	}
+	if selector.Rotational != nil {
+		driveType := block.DRIVE_TYPE_SSD
+		if *selector.Rotational {
+			driveType = block.DRIVE_TYPE_HDD
+		}
+		if disk.DriveType != driveType {
+			return false
+		}
+	}
+	if selector.Transport != "" && !strings.EqualFold(selector.Transport, diskTransport(disk)) {
+		return false
+	}
+	if selector.Model != "" {
+		if match, _ := regexp.MatchString(selector.Model, disk.Model); !match {
+			return false
+		}
+	}
+	if selector.ByID != "" {
+		found := false
+		for _, name := range byID {
+			if name == disk.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// diskHasSignatures checks if the given disk holds any filesystem, RAID, LVM or other signature
+// apart from a partition table. Those disks have no partitions but are not empty.
+func diskHasSignatures(runner v1.Runner, device string) (bool, error) {
+	out, err := runner.Run("wipefs", "--noheadings", "--output", "USAGE", device)
+	if err != nil {
+		return false, fmt.Errorf("failed reading signatures of %s: %w", device, err)
+	}
+	for _, usage := range strings.Split(string(out), "\n") {
+		usage = strings.TrimSpace(usage)
+		if usage != "" && usage != "partition table" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// diskTransport returns the transport used by the given disk (e.g. nvme, sata, usb...)
+func diskTransport(disk *block.Disk) string {
+	switch {
+	case disk.StorageController == block.STORAGE_CONTROLLER_NVME:
+		return "nvme"
+	case strings.Contains(disk.BusPath, "-usb-"):
+		return "usb"
+	case strings.Contains(disk.BusPath, "-ata-"):
+		return "sata"
+	case strings.Contains(disk.BusPath, "-sas-"):
+		return "sas"
+	default:
+		return strings.ToLower(disk.StorageController.String())
+	}
+}
+
+// disksByID returns the disk names pointed by the /dev/disk/by-id links matching the given pattern
+func disksByID(fs v1.FS, pattern string) ([]string, error) {
+	var names []string
+
+	links, err := fs.ReadDir(cnst.DiskByIDPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		match, err := filepath.Match(pattern, link.Name())
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
+		dev, err := fs.Readlink(filepath.Join(cnst.DiskByIDPath, link.Name()))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, filepath.Base(dev))
+	}
+	return names, nil
+}
+
 // GetPartitionFS gets the FS of a partition given
 func GetPartitionFS(partition string) (string, error) {
 	// We want to have the device always prefixed with a /dev
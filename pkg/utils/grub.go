@@ -17,15 +17,12 @@ limitations under the License.
 package utils
 
 import (
-	"bytes"
 	"fmt"
 	"io/fs"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 
-	efilib "github.com/canonical/go-efilib"
-
 	cnst "github.com/rancher/elemental-cli/pkg/constants"
 	eleefi "github.com/rancher/elemental-cli/pkg/efi"
 	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
@@ -45,38 +42,94 @@ configfile ($root)/` + grubConfDir + `/%s
 `
 )
 
+// grubModulesDirs returns the candidate locations of the grub modules of the given target
+// platform (e.g. i386-pc) within an OS image
+func grubModulesDirs(target string) []string {
+	var dirs []string
+	for _, dir := range []string{"/usr/share/grub2", "/usr/lib/grub2", "/usr/lib/grub", "/usr/share/grub"} {
+		dirs = append(dirs, filepath.Join(dir, target))
+	}
+	return dirs
+}
+
 // Grub is the struct that will allow us to install grub to the target device
 type Grub struct {
-	config *v1.Config
+	config    *v1.Config
+	hostTools bool
 }
 
-func NewGrub(config *v1.Config) *Grub {
+// GrubOptions are functional options to customize the Grub installer
+type GrubOptions func(g *Grub)
+
+// WithGrubHostTools sets whether BIOS installation uses the host grub tools instead
+// of the ones shipped in the OS image
+func WithGrubHostTools(hostTools bool) GrubOptions {
+	return func(g *Grub) {
+		g.hostTools = hostTools
+	}
+}
+
+func NewGrub(config *v1.Config, opts ...GrubOptions) *Grub {
 	g := &Grub{
 		config: config,
 	}
 
+	for _, o := range opts {
+		o(g)
+	}
+
 	return g
 }
 
-// InstallBIOS runs grub2-install for legacy BIOS firmware
+// InstallBIOS runs grub-install for legacy BIOS firmware. By default the grub-install binary
+// and the i386-pc modules of the OS image at rootDir are used from within a chroot, so host
+// and image grub versions can't mismatch. Host tools are only used if explicitly requested.
 func (g Grub) InstallBIOS(target, rootDir, bootDir string) error {
 	var grubargs []string
+	var err error
+	var out []byte
 
 	g.config.Logger.Info("Installing GRUB..")
 
-	grubargs = append(
-		grubargs,
-		fmt.Sprintf("--root-directory=%s", rootDir),
-		fmt.Sprintf("--boot-directory=%s", bootDir),
-		"--target=i386-pc",
-		target,
-	)
-	g.config.Logger.Debugf("Running grub with the following args: %s", grubargs)
+	if g.hostTools {
+		grubInstall := "grub2-install"
+		if !g.config.Runner.CommandExists(grubInstall) && g.config.Runner.CommandExists("grub-install") {
+			grubInstall = "grub-install"
+		}
+		grubargs = append(
+			grubargs,
+			fmt.Sprintf("--root-directory=%s", rootDir),
+			fmt.Sprintf("--boot-directory=%s", bootDir),
+			"--target=i386-pc",
+			target,
+		)
+		g.config.Logger.Debugf("Running host %s with the following args: %s", grubInstall, grubargs)
+		out, err = g.config.Runner.Run(grubInstall, grubargs...)
+	} else {
+		var grubInstall, modulesDir string
 
-	// TODOS:
-	//   * should be executed in a chroot (host might have a different grub version or different bootloader)
-	//   * should find the proper binary grub2-install vs grub-install
-	out, err := g.config.Runner.Run("grub2-install", grubargs...)
+		grubInstall, err = g.findInImage(rootDir, grubToolPaths("grub2-install", "grub-install"))
+		if err != nil {
+			g.config.Logger.Errorf("Could not find grub-install binary in %s", rootDir)
+			return err
+		}
+		modulesDir, err = g.findInImage(rootDir, grubModulesDirs("i386-pc"))
+		if err != nil {
+			g.config.Logger.Errorf("Could not find grub i386-pc modules in %s", rootDir)
+			return err
+		}
+		grubargs = append(
+			grubargs,
+			fmt.Sprintf("--directory=%s", modulesDir),
+			fmt.Sprintf("--boot-directory=%s", bootDir),
+			"--target=i386-pc",
+			target,
+		)
+		g.config.Logger.Debugf("Running %s in chroot %s with the following args: %s", grubInstall, rootDir, grubargs)
+
+		// bootDir is bind mounted at the same path within the chroot, /dev is already part of the default mounts
+		out, err = g.runInChroot(rootDir, bootDir, grubInstall, grubargs...)
+	}
 	if err != nil {
 		g.config.Logger.Errorf(string(out))
 		return err
@@ -86,6 +139,126 @@ func (g Grub) InstallBIOS(target, rootDir, bootDir string) error {
 	return nil
 }
 
+// findInImage returns the first of the given paths that exists within rootDir. The returned
+// path is relative to rootDir, so it is suitable to be used within a chroot
+func (g Grub) findInImage(rootDir string, paths []string) (string, error) {
+	for _, p := range paths {
+		if exists, _ := Exists(g.config.Fs, filepath.Join(rootDir, p)); exists {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("none of %v found in %s", paths, rootDir)
+}
+
+// copyEFIBinary looks for the first candidate file of the given EFI binary in rootDir and copies
+// it as the binary name into both the fallback and the elemental EFI dirs
+func (g Grub) copyEFIBinary(rootDir, efiDir string, bin v1.EFIBinary) error {
+	var source string
+	for _, f := range bin.Candidates() {
+		_ = WalkDirFs(g.config.Fs, rootDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && source == "" && !d.IsDir() && d.Name() == f {
+				source = path
+			}
+			return err
+		})
+		if source != "" {
+			break
+		}
+	}
+	if source == "" {
+		return fmt.Errorf("did not find efi artifacts under %s: missing %s (%v)", rootDir, bin.Name, bin.Candidates())
+	}
+
+	for _, dir := range []string{fallbackEFIPath, entryEFIPath} {
+		fileWriteName := filepath.Join(efiDir, dir, bin.Name)
+		g.config.Logger.Debugf("Copying %s to %s", source, fileWriteName)
+		err := CopyFile(g.config.Fs, source, fileWriteName)
+		if err != nil {
+			return fmt.Errorf("failed copying %s to %s: %s", source, fileWriteName, err.Error())
+		}
+	}
+	return nil
+}
+
+// buildEFIGrub builds the given grub EFI binary with the grub-mkimage tool and modules of the OS
+// image and installs it into both the fallback and the elemental EFI dirs. The binary loads the
+// grub.cfg of the fallback EFI dir.
+func (g Grub) buildEFIGrub(rootDir, efiDir string, bin v1.EFIBinary, modules []string) error {
+	target := fmt.Sprintf("%s-efi", g.config.Platform.Arch)
+
+	mkimage, err := g.findInImage(rootDir, grubToolPaths("grub2-mkimage", "grub-mkimage"))
+	if err != nil {
+		g.config.Logger.Errorf("Could not find grub-mkimage binary in %s", rootDir)
+		return err
+	}
+	modulesDir, err := g.findInImage(rootDir, grubModulesDirs(target))
+	if err != nil {
+		g.config.Logger.Errorf("Could not find grub %s modules in %s", target, rootDir)
+		return err
+	}
+
+	output := filepath.Join(efiDir, fallbackEFIPath, bin.Name)
+	args := []string{
+		fmt.Sprintf("--format=%s", target),
+		fmt.Sprintf("--directory=%s", modulesDir),
+		fmt.Sprintf("--prefix=%s", fallbackEFIPath),
+		fmt.Sprintf("--output=%s", output),
+	}
+	args = append(args, cnst.GetGrubEFIImageModules()...)
+	args = append(args, modules...)
+	g.config.Logger.Debugf("Running %s in chroot %s with the following args: %s", mkimage, rootDir, args)
+
+	// efiDir is bind mounted at the same path within the chroot
+	out, err := g.runInChroot(rootDir, efiDir, mkimage, args...)
+	if err != nil {
+		g.config.Logger.Errorf(string(out))
+		return fmt.Errorf("failed building %s: %s", bin.Name, err.Error())
+	}
+
+	fileWriteName := filepath.Join(efiDir, entryEFIPath, bin.Name)
+	err = CopyFile(g.config.Fs, output, fileWriteName)
+	if err != nil {
+		return fmt.Errorf("failed copying %s to %s: %s", output, fileWriteName, err.Error())
+	}
+	return nil
+}
+
+// runInChroot runs the given command in a chroot of rootDir with bindDir bind mounted at the
+// same path. The mountpoint dirs created within rootDir are removed once unmounted, so they
+// do not end up in the OS image built from rootDir.
+func (g Grub) runInChroot(rootDir, bindDir, command string, args ...string) ([]byte, error) {
+	var created []string
+	for dir := filepath.Clean(bindDir); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if exists, _ := Exists(g.config.Fs, filepath.Join(rootDir, dir)); exists {
+			break
+		}
+		created = append(created, filepath.Join(rootDir, dir))
+	}
+
+	chroot := NewChroot(rootDir, g.config)
+	chroot.SetExtraMounts(map[string]string{bindDir: bindDir})
+	out, err := chroot.Run(command, args...)
+
+	// Deepest dirs first, Remove refuses to delete dirs which are not empty
+	for _, dir := range created {
+		if rErr := g.config.Fs.Remove(dir); rErr != nil && err == nil {
+			err = rErr
+		}
+	}
+	return out, err
+}
+
+// grubToolPaths returns all the candidate locations of the given grub tool names within an OS image
+func grubToolPaths(names ...string) []string {
+	var paths []string
+	for _, bin := range names {
+		for _, dir := range []string{"/usr/sbin", "/usr/bin", "/sbin", "/bin"} {
+			paths = append(paths, filepath.Join(dir, bin))
+		}
+	}
+	return paths
+}
+
 // InstallConfig installs grub configuraton files to the expected location.  rootDir is the root
 // of the OS image, bootDir is the folder grub read the configuration from, usually state partition mountpoint
 func (g Grub) InstallConfig(rootDir, bootDir, grubConf string) error {
@@ -108,11 +281,11 @@ func (g Grub) InstallConfig(rootDir, bootDir, grubConf string) error {
 	return err
 }
 
-// DoEFIEntries creates clears any previous entry if requested and creates a new one with the given shim name.
-func (g Grub) DoEFIEntries(shimName, efiDir string, clearBootEntries bool) error {
+// DoEFIEntries creates clears any previous entry of the target disk if requested and creates a new one with the given shim name.
+func (g Grub) DoEFIEntries(shimName, efiDir, target string, clearBootEntries bool) error {
 	efivars := eleefi.RealEFIVariables{}
 	if clearBootEntries {
-		err := g.ClearBootEntry()
+		err := g.ClearBootEntry(target, efivars)
 		if err != nil {
 			return err
 		}
@@ -129,15 +302,35 @@ func (g Grub) InstallEFI(rootDir, bootDir, efiDir, deviceLabel string) (string,
 	var err error
 	g.config.Logger.Infof("Generating grub files for efi on %s", efiDir)
 
+	// Find the bootloader profile of the source system
+	osRelease, err := ReadOsRelease(g.config.Fs, rootDir)
+	if err != nil {
+		return "", err
+	}
+	profile, err := GetBootloaderProfile(g.config, osRelease)
+	if err != nil {
+		return "", err
+	}
+	g.config.Logger.Infof("Using bootloader profile %s", profile.Name)
+
+	bins, ok := profile.EFI[g.config.Platform.Arch]
+	if !ok {
+		return "", fmt.Errorf("bootloader profile %s does not define EFI binaries for %s", profile.Name, g.config.Platform.Arch)
+	}
+
 	// Create Needed dir under state partition to store the grub.cfg and any needed modules
 	err = MkdirAll(g.config.Fs, filepath.Join(bootDir, grubConfDir, fmt.Sprintf("%s-efi", g.config.Platform.Arch)), cnst.DirPerm)
 	if err != nil {
 		return "", fmt.Errorf("error creating grub dir: %s", err)
 	}
 
-	var foundModules bool
-	var foundEfi bool
-	for _, m := range []string{"loopback.mod", "squash4.mod", "xzio.mod"} {
+	modules := profile.Modules
+	if len(modules) == 0 {
+		modules = cnst.GetDefaultGrubModules()
+	}
+	for _, m := range modules {
+		var foundModule bool
+		m = fmt.Sprintf("%s.mod", m)
 		err = WalkDirFs(g.config.Fs, rootDir, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
@@ -149,13 +342,13 @@ func (g Grub) InstallEFI(rootDir, bootDir, efiDir, deviceLabel string) (string,
 				if err != nil {
 					return fmt.Errorf("error copying %s to %s: %s", path, fileWriteName, err.Error())
 				}
-				foundModules = true
+				foundModule = true
 				return nil
 			}
 			return err
 		})
-		if !foundModules {
-			return "", fmt.Errorf("did not find grub modules under %s (err: %s)", rootDir, err)
+		if !foundModule {
+			return "", fmt.Errorf("did not find grub modules under %s: missing %s (err: %v)", rootDir, m, err)
 		}
 	}
 
@@ -171,70 +364,38 @@ func (g Grub) InstallEFI(rootDir, bootDir, efiDir, deviceLabel string) (string,
 	}
 
 	// Copy needed files for efi boot
-	system, err := IdentifySourceSystem(g.config.Fs, rootDir)
-	if err != nil {
-		return "", err
+	efiBins := []v1.EFIBinary{bins.Shim, bins.MokManager, bins.Grub}
+	shimName := bins.Shim.Name
+	grubName := bins.Grub.Name
+
+	// Boot grub directly if the custom Secure Boot keys are enrolled or the profile has no shim
+	if g.config.SecureBoot.NoShim || shimName == "" {
+		g.config.Logger.Infof("Skipping shim and MokManager, booting grub directly")
+		efiBins = []v1.EFIBinary{bins.Grub}
+		shimName = grubName
 	}
-	g.config.Logger.Infof("Identified source system as %s", system)
 
-	var shimFiles []string
-	var shimName string
-
-	switch system {
-	case cnst.Fedora:
-		switch g.config.Platform.Arch {
-		case cnst.ArchArm64:
-			shimFiles = []string{"shimaa64.efi", "mmaa64.efi", "grubx64.efi"}
-			shimName = "shimaa64.efi"
-		default:
-			shimFiles = []string{"shimx64.efi", "mmx64.efi", "grubx64.efi"}
-			shimName = "shimx64.efi"
+	for _, b := range efiBins {
+		if b.Name == "" {
+			continue
 		}
-	case cnst.Ubuntu:
-		switch g.config.Platform.Arch {
-		case cnst.ArchArm64:
-			shimFiles = []string{"shimaa64.efi.signed", "mmaa64.efi", "grubx64.efi.signed"}
-			shimName = "shimaa64.efi.signed"
-		default:
-			shimFiles = []string{"shimx64.efi.signed", "mmx64.efi", "grubx64.efi.signed"}
-			shimName = "shimx64.efi.signed"
+		if b.Unsigned {
+			err = g.buildEFIGrub(rootDir, efiDir, b, profile.Modules)
+		} else {
+			err = g.copyEFIBinary(rootDir, efiDir, b)
+		}
+		if err != nil {
+			return "", err
 		}
-	case cnst.Suse:
-		shimFiles = []string{"shim.efi", "MokManager.efi", "grub.efi"}
-		shimName = "shim.efi"
 	}
 
-	for _, f := range shimFiles {
-		_ = WalkDirFs(g.config.Fs, rootDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if d.Name() == f {
-				// Copy to fallback dir
-				fileWriteName := filepath.Join(efiDir, fallbackEFIPath, f)
-				g.config.Logger.Debugf("Copying %s to %s", path, fileWriteName)
-				err = CopyFile(g.config.Fs, path, fileWriteName)
-				if err != nil {
-					return fmt.Errorf("failed copying %s to %s: %s", path, fileWriteName, err.Error())
-				}
-
-				// Copy to proper dir
-				fileWriteName = filepath.Join(efiDir, entryEFIPath, f)
-				g.config.Logger.Debugf("Copying %s to %s", path, fileWriteName)
-				err = CopyFile(g.config.Fs, path, fileWriteName)
-				if err != nil {
-					return fmt.Errorf("failed copying %s to %s: %s", path, fileWriteName, err.Error())
-				}
-
-				foundEfi = true
-				return nil
-			}
-			return err
-		})
-		if !foundEfi {
-			return "", fmt.Errorf("did not find efi artifacts under %s", rootDir)
-		}
+	err = SignEFI(
+		g.config,
+		filepath.Join(efiDir, fallbackEFIPath, grubName),
+		filepath.Join(efiDir, entryEFIPath, grubName),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed signing grub: %s", err.Error())
 	}
 
 	// Rename the shimName to the fallback name so the system boots from fallback. This means that we do not create
@@ -279,7 +440,7 @@ func (g Grub) Install(target, rootDir, bootDir, grubConf string, efi bool, state
 		}
 
 		if !disableBootEntry {
-			err = g.DoEFIEntries(shimName, cnst.EfiDir, clearBootEntries)
+			err = g.DoEFIEntries(shimName, cnst.EfiDir, target, clearBootEntries)
 			if err != nil {
 				return err
 			}
@@ -294,32 +455,38 @@ func (g Grub) Install(target, rootDir, bootDir, grubConf string, efi bool, state
 	return g.InstallConfig(rootDir, bootDir, grubConf)
 }
 
-// ClearBootEntry will go over the BootXXXX efi vars and remove any that matches our name
-// Used in install as we re-create the partitions, so the UUID of those partitions is no longer valid for the old entry
-// And we don't want to leave a broken entry around
-func (g Grub) ClearBootEntry() error {
-	variables, _ := efilib.ListVariables()
-	for _, v := range variables {
-		if regexp.MustCompile(`Boot[0-9a-fA-F]{4}`).MatchString(v.Name) {
-			variable, _, _ := efilib.ReadVariable(v.Name, v.GUID)
-			option, err := efilib.ReadLoadOption(bytes.NewReader(variable))
-			if err != nil {
-				continue
-			}
-			// TODO: Find a way to identify the old VS new partition UUID and compare them before removing?
-			if option.Description == bootEntryName {
-				g.config.Logger.Debugf("Entry for %s already exists, removing it: %s", bootEntryName, option.String())
-				_, attrs, err := efilib.ReadVariable(v.Name, v.GUID)
-				if err != nil {
-					g.config.Logger.Errorf("failed to remove efi entry %s: %s", v.Name, err.Error())
-					return err
-				}
-				err = efilib.WriteVariable(v.Name, v.GUID, attrs, nil)
-				if err != nil {
-					g.config.Logger.Errorf("failed to remove efi entry %s: %s", v.Name, err.Error())
-					return err
-				}
-			}
+// ClearBootEntry removes the elemental boot entries pointing to a partition of the target disk
+// or to a partition that no longer exists. Used in install as we re-create the partitions, so the
+// UUID of those partitions is no longer valid for the old entry and we don't want to leave a broken
+// entry around. Entries of other disks, e.g. other installations, are kept.
+func (g Grub) ClearBootEntry(target string, efiVariables eleefi.Variables) error {
+	bm, err := eleefi.NewBootManagerForVariables(efiVariables)
+	if err != nil {
+		return err
+	}
+	parts, err := GetAllPartitions()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range bm.Entries() {
+		if entry.Description != bootEntryName {
+			continue
+		}
+		if entry.PartitionGUID == "" {
+			g.config.Logger.Debugf("Entry %s does not point to a GPT partition, keeping it", entry.Name)
+			continue
+		}
+		part := parts.GetByUUID(entry.PartitionGUID)
+		if part != nil && part.Disk != filepath.Clean(target) {
+			g.config.Logger.Debugf("Entry %s points to disk %s, keeping it", entry.Name, part.Disk)
+			continue
+		}
+		g.config.Logger.Debugf("Removing stale entry %s: %s", entry.Name, entry.DevicePath)
+		err = bm.DeleteEntry(entry.Number)
+		if err != nil {
+			g.config.Logger.Errorf("failed to remove efi entry %s: %s", entry.Name, err.Error())
+			return err
 		}
 	}
 	return nil
@@ -355,13 +522,25 @@ func (g Grub) CreateBootEntry(shimName string, relativeTo string, efiVariables e
 
 // Sets the given key value pairs into as grub variables into the given file
 func (g Grub) SetPersistentVariables(grubEnvFile string, vars map[string]string) error {
-	for key, value := range vars {
-		g.config.Logger.Debugf("Running grub2-editenv with params: %s set %s=%s", grubEnvFile, key, value)
-		out, err := g.config.Runner.Run("grub2-editenv", grubEnvFile, "set", fmt.Sprintf("%s=%s", key, value))
-		if err != nil {
-			g.config.Logger.Errorf(fmt.Sprintf("Failed setting grub variables: %s", out))
-			return err
-		}
+	env, err := ReadGrubEnv(g.config.Fs, grubEnvFile)
+	if err != nil {
+		g.config.Logger.Errorf("Failed reading grub environment %s: %s", grubEnvFile, err)
+		return err
 	}
-	return nil
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g.config.Logger.Debugf("Setting grub variable %s=%s in %s", key, vars[key], grubEnvFile)
+		env.Set(key, vars[key])
+	}
+
+	err = WriteGrubEnv(g.config.Fs, grubEnvFile, env)
+	if err != nil {
+		g.config.Logger.Errorf("Failed setting grub variables: %s", err)
+	}
+	return err
 }
@@ -0,0 +1,179 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cnst "github.com/rancher/elemental-cli/pkg/constants"
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+)
+
+const (
+	// grubEnvSize is the fixed size of a grub environment block, grub pads it with '#'
+	grubEnvSize   = 1024
+	grubEnvHeader = "# GRUB Environment Block\n"
+)
+
+// GrubEnv is an in memory grub environment block, variables keep the order of the file
+type GrubEnv struct {
+	keys []string
+	vars map[string]string
+}
+
+// NewGrubEnv returns an empty grub environment block
+func NewGrubEnv() *GrubEnv {
+	return &GrubEnv{vars: map[string]string{}}
+}
+
+// ReadGrubEnv parses the given grub environment block file, a missing file is an empty block
+func ReadGrubEnv(fs v1.FS, file string) (*GrubEnv, error) {
+	env := NewGrubEnv()
+
+	data, err := fs.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return env, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(grubEnvHeader)) {
+		return nil, fmt.Errorf("invalid grub environment block %s: missing header", file)
+	}
+
+	lines := splitGrubEnvLines(string(data[len(grubEnvHeader):]))
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		env.Set(key, unescapeGrubEnv(value))
+	}
+	return env, nil
+}
+
+// splitGrubEnvLines splits the block in lines, newlines escaped with a backslash are part of the value
+func splitGrubEnvLines(data string) []string {
+	var lines []string
+	var line strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			line.WriteByte(data[i])
+			if i+1 < len(data) {
+				i++
+				line.WriteByte(data[i])
+			}
+		case '\n':
+			lines = append(lines, line.String())
+			line.Reset()
+		default:
+			line.WriteByte(data[i])
+		}
+	}
+	return append(lines, line.String())
+}
+
+func unescapeGrubEnv(value string) string {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		out.WriteByte(value[i])
+	}
+	return out.String()
+}
+
+func escapeGrubEnv(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\\n").Replace(value)
+}
+
+// Get returns the value of the given variable and whether it is set
+func (e *GrubEnv) Get(key string) (string, bool) {
+	value, ok := e.vars[key]
+	return value, ok
+}
+
+// Set sets the given variable, new variables are appended at the end of the block
+func (e *GrubEnv) Set(key, value string) {
+	if _, ok := e.vars[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.vars[key] = value
+}
+
+// Unset removes the given variable, if set
+func (e *GrubEnv) Unset(key string) {
+	if _, ok := e.vars[key]; !ok {
+		return
+	}
+	delete(e.vars, key)
+	for i, k := range e.keys {
+		if k == key {
+			e.keys = append(e.keys[:i], e.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the names of the set variables in the block order
+func (e *GrubEnv) Keys() []string {
+	return append([]string(nil), e.keys...)
+}
+
+// Bytes renders the 1024 bytes grub environment block
+func (e *GrubEnv) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(grubEnvHeader)
+	for _, key := range e.keys {
+		if key == "" || strings.ContainsAny(key, "=\n") {
+			return nil, fmt.Errorf("invalid grub environment variable name '%s'", key)
+		}
+		buf.WriteString(fmt.Sprintf("%s=%s\n", key, escapeGrubEnv(e.vars[key])))
+	}
+	if buf.Len() > grubEnvSize {
+		return nil, fmt.Errorf("grub environment block exceeds %d bytes", grubEnvSize)
+	}
+	buf.Write(bytes.Repeat([]byte("#"), grubEnvSize-buf.Len()))
+	return buf.Bytes(), nil
+}
+
+// WriteGrubEnv atomically writes the grub environment block to the given file
+func WriteGrubEnv(fs v1.FS, file string, env *GrubEnv) error {
+	data, err := env.Bytes()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(file), fmt.Sprintf(".%s.%s", filepath.Base(file), nextRandom()))
+	err = fs.WriteFile(tmp, data, cnst.FilePerm)
+	if err != nil {
+		return err
+	}
+	err = Rename(fs, tmp, file)
+	if err != nil {
+		_ = fs.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+)
+
+// SignEFI signs the given EFI binaries in place with the configured Secure Boot key
+// and certificate. It does nothing if no signing keys are configured.
+func SignEFI(cfg *v1.Config, files ...string) error {
+	if !cfg.SecureBoot.Enabled() {
+		return nil
+	}
+	for _, f := range files {
+		cfg.Logger.Infof("Signing %s", f)
+		out, err := cfg.Runner.Run(
+			"sbsign", "--key", cfg.SecureBoot.Key, "--cert", cfg.SecureBoot.Cert, "--output", f, f,
+		)
+		if err != nil {
+			cfg.Logger.Errorf("failed signing %s: %s", f, string(out))
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2022 - 2023 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sys/unix"
+
+	v1 "github.com/rancher/elemental-cli/pkg/types/v1"
+)
+
+// syncBufferSize is the size of the chunks copied at once, chunks only including
+// zeros are skipped to keep files sparse
+const syncBufferSize = 64 * 1024
+
+// SyncData copies the contents of the source folder to the target folder, which must exist.
+// Ownership, permissions, timestamps, hard links, sparse files, extended attributes (including
+// SELinux labels and ACLs) and device nodes are preserved. Existing files in target are
+// overwritten. Excludes follow the rsync exclude patterns, a leading "/" anchors the pattern
+// to the source folder and a trailing "/" only matches directories.
+func SyncData(log v1.Logger, fs v1.FS, source string, target string, excludes ...string) error {
+	if fs != nil {
+		if s, err := fs.RawPath(source); err == nil {
+			source = s
+		}
+		if t, err := fs.RawPath(target); err == nil {
+			target = t
+		}
+	}
+
+	for _, dir := range []string{source, target} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+
+	s := &syncer{log: log, source: filepath.Clean(source), target: filepath.Clean(target), excludes: excludes}
+	return s.run()
+}
+
+// syncEntry is a source path and its target path
+type syncEntry struct {
+	src  string
+	dst  string
+	info fs.FileInfo
+}
+
+// fileID identifies a file within the source tree to find hard links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+type syncer struct {
+	log      v1.Logger
+	source   string
+	target   string
+	excludes []string
+	total    int64
+	done     int64
+}
+
+func (s *syncer) run() error {
+	var dirs, files, specials []syncEntry
+	var links [][2]string
+	inodes := map[fileID]string{}
+
+	// Create the target tree first and collect the files to copy
+	err := filepath.WalkDir(s.source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.source, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && s.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := syncEntry{src: path, dst: filepath.Join(s.target, rel), info: info}
+
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, entry)
+			return makeDir(entry.dst)
+		case info.Mode().IsRegular():
+			st := info.Sys().(*syscall.Stat_t)
+			if st.Nlink > 1 {
+				id := fileID{dev: uint64(st.Dev), ino: st.Ino}
+				if first, ok := inodes[id]; ok {
+					links = append(links, [2]string{first, entry.dst})
+					return nil
+				}
+				inodes[id] = entry.dst
+			}
+			files = append(files, entry)
+			s.total += info.Size()
+		default:
+			specials = append(specials, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stop := s.reportProgress()
+	defer stop()
+
+	for _, entry := range specials {
+		if err = s.copySpecial(entry); err != nil {
+			return err
+		}
+	}
+
+	g := errgroup.Group{}
+	g.SetLimit(runtime.NumCPU())
+	for _, entry := range files {
+		entry := entry
+		g.Go(func() error { return s.copyFile(entry) })
+	}
+	if err = g.Wait(); err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err = removeExisting(link[1]); err != nil {
+			return err
+		}
+		if err = os.Link(link[0], link[1]); err != nil {
+			return err
+		}
+	}
+
+	// Directories metadata is set once their content is in place, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = s.setMetadata(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excluded checks if the given path relative to the source folder matches any exclude
+func (s syncer) excluded(rel string, isDir bool) bool {
+	for _, pattern := range s.excludes {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		var match bool
+		switch {
+		case strings.HasPrefix(pattern, "/"):
+			match, _ = filepath.Match(strings.TrimPrefix(pattern, "/"), rel)
+		case strings.Contains(pattern, "/"):
+			// Unanchored patterns including a "/" match the trailing elements of the path
+			elems := strings.Split(rel, "/")
+			n := strings.Count(pattern, "/") + 1
+			if len(elems) >= n {
+				match, _ = filepath.Match(pattern, strings.Join(elems[len(elems)-n:], "/"))
+			}
+		default:
+			match, _ = filepath.Match(pattern, filepath.Base(rel))
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// reportProgress reports the copied bytes every second until the returned function is called
+func (s *syncer) reportProgress() func() {
+	quit := make(chan bool)
+	var once sync.Once
+	report := func() {
+		done := atomic.LoadInt64(&s.done)
+		s.log.Emit(v1.NewProgressEvent(s.source, v1.ProgressUnitBytes, done, s.total))
+		s.log.Debugf("progress copying %s to %s: %d / %d bytes", s.source, s.target, done, s.total)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(quit)
+			report()
+		})
+	}
+}
+
+// copyFile copies a regular file skipping chunks of zeros, so they become holes
+func (s *syncer) copyFile(entry syncEntry) error {
+	in, err := os.Open(entry.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err = removeExisting(entry.dst); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(entry.dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, syncBufferSize)
+	for {
+		n, rErr := io.ReadFull(in, buf)
+		if n > 0 {
+			if isZeros(buf[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				out.Close()
+				return err
+			}
+			atomic.AddInt64(&s.done, int64(n))
+		}
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rErr != nil {
+			out.Close()
+			return rErr
+		}
+	}
+
+	// Set the size in case the file ends with a hole
+	if err = out.Truncate(entry.info.Size()); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return s.setMetadata(entry)
+}
+
+// copySpecial copies symlinks, device nodes, fifos and sockets
+func (s *syncer) copySpecial(entry syncEntry) error {
+	if err := removeExisting(entry.dst); err != nil {
+		return err
+	}
+
+	if entry.info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(entry.src)
+		if err != nil {
+			return err
+		}
+		if err = os.Symlink(link, entry.dst); err != nil {
+			return err
+		}
+		return s.setMetadata(entry)
+	}
+
+	st := entry.info.Sys().(*syscall.Stat_t)
+	err := unix.Mknod(entry.dst, st.Mode, int(st.Rdev))
+	if errors.Is(err, unix.EPERM) && os.Geteuid() != 0 {
+		// Same as rsync, device nodes can only be created by the super user
+		s.log.Warnf("skipping %s, not enough privileges to create device nodes", entry.src)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.setMetadata(entry)
+}
+
+// setMetadata sets ownership, permissions, extended attributes and timestamps of the
+// target path as in the source path. Ownership is only set by the super user.
+func (s *syncer) setMetadata(entry syncEntry) error {
+	st := entry.info.Sys().(*syscall.Stat_t)
+	isLink := entry.info.Mode()&fs.ModeSymlink != 0
+
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(entry.dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	// Symlinks permissions are meaningless on Linux
+	if !isLink {
+		if err := os.Chmod(entry.dst, entry.info.Mode()); err != nil {
+			return err
+		}
+	}
+	if err := s.copyXattrs(entry.src, entry.dst); err != nil {
+		return err
+	}
+
+	times := []unix.Timespec{unix.NsecToTimespec(st.Atim.Nano()), unix.NsecToTimespec(st.Mtim.Nano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, entry.dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// copyXattrs copies all extended attributes, ACLs are stored as system.posix_acl_* attributes.
+// Attributes not supported by the target filesystem are skipped.
+func (s *syncer) copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if errors.Is(err, unix.ENOTSUP) || size == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := make([]byte, size)
+	size, err = unix.Llistxattr(src, list)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		vSize, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, vSize)
+		vSize, err = unix.Lgetxattr(src, name, value)
+		if err != nil {
+			return err
+		}
+		err = unix.Lsetxattr(dst, name, value[:vSize], 0)
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			s.log.Debugf("skipping extended attribute %s of %s: %s", name, src, err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeDir creates the given directory replacing any existing non directory path
+func makeDir(path string) error {
+	info, err := os.Lstat(path)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if err = removeExisting(path); err != nil {
+		return err
+	}
+	return os.Mkdir(path, 0700)
+}
+
+// removeExisting removes the given path if it exists and is not a directory
+func removeExisting(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return os.Remove(path)
+}
+
+func isZeros(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
+
+// ClampMtimes sets the access and modification times of any file or directory within root
+// newer than the given time to that time. Symlinks are not followed.
+func ClampMtimes(fs v1.FS, root string, t time.Time) error {
+	if fs != nil {
+		if r, err := fs.RawPath(root); err == nil {
+			root = r
+		}
+	}
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().After(t) {
+			return nil
+		}
+		return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
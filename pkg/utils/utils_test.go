@@ -35,6 +35,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/twpayne/go-vfs"
 	"github.com/twpayne/go-vfs/vfst"
+	"golang.org/x/sys/unix"
 
 	conf "github.com/rancher/elemental-cli/pkg/config"
 	"github.com/rancher/elemental-cli/pkg/constants"
@@ -297,6 +298,116 @@ var _ = Describe("Utils", Label("utils"), func() {
 			Expect(err).NotTo(BeNil())
 		})
 	})
+	Describe("SelectDisk", Label("lsblk", "disks"), func() {
+		var ghwTest v1mock.GhwMock
+		var selector *v1.DiskSelector
+		BeforeEach(func() {
+			ghwTest = v1mock.GhwMock{}
+			ghwTest.AddDisk(block.Disk{
+				Name: "sda", SizeBytes: 1024 * 1024 * 1024 * 1024, DriveType: block.DRIVE_TYPE_HDD,
+				Model: "ST1000DM010", BusPath: "pci-0000:00:17.0-ata-1",
+				Partitions: []*block.Partition{{Name: "sda1"}},
+			})
+			ghwTest.AddDisk(block.Disk{
+				Name: "sdb", SizeBytes: 256 * 1024 * 1024 * 1024,
+				Model: "Samsung SSD 860", BusPath: "pci-0000:00:17.0-ata-2",
+			})
+			ghwTest.AddDisk(block.Disk{
+				Name: "nvme0n1", SizeBytes: 512 * 1024 * 1024 * 1024,
+				Model: "Samsung SSD 970", BusPath: "pci-0000:01:00.0-nvme-1",
+			})
+			ghwTest.AddDisk(block.Disk{
+				Name: "sdc", SizeBytes: 32 * 1024 * 1024 * 1024,
+				Model: "Cruzer", BusPath: "pci-0000:00:14.0-usb-0:1:1.0-scsi-0:0:0:0",
+			})
+			// Optical drive, the mock only flags HDD drive types as rotational
+			ghwTest.AddDisk(block.Disk{
+				Name: "sr0", SizeBytes: 4 * 1024 * 1024 * 1024, DriveType: block.DRIVE_TYPE_HDD,
+			})
+			ghwTest.CreateDevices()
+			selector = &v1.DiskSelector{}
+		})
+		AfterEach(func() {
+			ghwTest.Clean()
+		})
+		It("selects a rotational disk", func() {
+			rotational := true
+			selector.Rotational = &rotational
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sda"))
+		})
+		It("selects a non rotational disk only if it is an SSD", func() {
+			rotational := false
+			selector.Rotational = &rotational
+			selector.MaxSize = 8 * 1024
+			_, err := utils.SelectDisk(fs, runner, selector)
+			Expect(err).To(HaveOccurred())
+			selector.MaxSize = 64 * 1024
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sdc"))
+		})
+		It("selects a disk by transport", func() {
+			selector.Transport = "nvme"
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/nvme0n1"))
+			selector.Transport = "USB"
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sdc"))
+		})
+		It("selects a disk by size range", func() {
+			selector.MinSize = 300 * 1024
+			selector.MaxSize = 600 * 1024
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/nvme0n1"))
+		})
+		It("selects the largest empty disk among the matching ones", func() {
+			selector.Model = "^Samsung"
+			_, err := utils.SelectDisk(fs, runner, selector)
+			Expect(err).To(HaveOccurred())
+			selector.LargestEmpty = true
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/nvme0n1"))
+		})
+		It("selects the largest empty disk", func() {
+			selector.LargestEmpty = true
+			selector.Transport = "sata"
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sdb"))
+		})
+		It("does not select a disk without partitions holding signatures", func() {
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "wipefs" && args[len(args)-1] == "/dev/nvme0n1" {
+					return []byte("raid\n"), nil
+				}
+				return []byte("partition table\n"), nil
+			}
+			selector.LargestEmpty = true
+			selector.Model = "^Samsung"
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sdb"))
+		})
+		It("fails if several largest empty disks have the same size", func() {
+			ghwTest.AddDisk(block.Disk{
+				Name: "sdd", SizeBytes: 256 * 1024 * 1024 * 1024,
+				Model: "Samsung SSD 860", BusPath: "pci-0000:00:17.0-ata-3",
+			})
+			ghwTest.Clean()
+			ghwTest.CreateDevices()
+			selector.LargestEmpty = true
+			selector.Transport = "sata"
+			_, err := utils.SelectDisk(fs, runner, selector)
+			Expect(err).To(HaveOccurred())
+		})
+		It("selects a disk by id", func() {
+			Expect(utils.MkdirAll(fs, constants.DiskByIDPath, constants.DirPerm)).To(Succeed())
+			Expect(fs.Symlink("../../sdc", filepath.Join(constants.DiskByIDPath, "usb-SanDisk_Cruzer_1234-0:0"))).To(Succeed())
+			Expect(fs.Symlink("../../sda", filepath.Join(constants.DiskByIDPath, "ata-ST1000DM010_5678"))).To(Succeed())
+			selector.ByID = "usb-*"
+			Expect(utils.SelectDisk(fs, runner, selector)).To(Equal("/dev/sdc"))
+		})
+		It("fails if no disk matches", func() {
+			selector.MinSize = 2048 * 1024
+			_, err := utils.SelectDisk(fs, runner, selector)
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails if multiple disks match", func() {
+			selector.Transport = "sata"
+			_, err := utils.SelectDisk(fs, runner, selector)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 	Describe("CosignVerify", Label("cosign"), func() {
 		It("runs a keyless verification", func() {
 			_, err := utils.CosignVerify(fs, runner, "some/image:latest", "", true)
@@ -553,6 +664,47 @@ var _ = Describe("Utils", Label("utils"), func() {
 			Expect(utils.Exists(fs, filepath.Join(destDir, "run"))).To(BeFalse())
 		})
 
+		It("Preserves permissions, symlinks, hard links and sparse files", func() {
+			sourceDir, err := os.MkdirTemp("", "elementalsource")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(sourceDir)
+			destDir, err := os.MkdirTemp("", "elementaltarget")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(destDir)
+
+			Expect(os.Mkdir(filepath.Join(sourceDir, "dir"), 0750)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sourceDir, "dir", "file"), []byte("data"), 0640)).To(Succeed())
+			Expect(os.Link(filepath.Join(sourceDir, "dir", "file"), filepath.Join(sourceDir, "hardlink"))).To(Succeed())
+			Expect(os.Symlink("dir/file", filepath.Join(sourceDir, "symlink"))).To(Succeed())
+			sparse, err := os.Create(filepath.Join(sourceDir, "sparse"))
+			Expect(err).To(BeNil())
+			Expect(sparse.Truncate(64 * 1024 * 1024)).To(Succeed())
+			Expect(sparse.Close()).To(Succeed())
+			// Existing files in target are overwritten
+			Expect(os.WriteFile(filepath.Join(destDir, "symlink"), []byte("old"), 0644)).To(Succeed())
+
+			Expect(utils.SyncData(logger, nil, sourceDir, destDir)).To(BeNil())
+
+			info, err := os.Stat(filepath.Join(destDir, "dir"))
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+			info, err = os.Stat(filepath.Join(destDir, "dir", "file"))
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+
+			link, err := os.Readlink(filepath.Join(destDir, "symlink"))
+			Expect(err).To(BeNil())
+			Expect(link).To(Equal("dir/file"))
+
+			hardlink, err := os.Stat(filepath.Join(destDir, "hardlink"))
+			Expect(err).To(BeNil())
+			Expect(os.SameFile(info, hardlink)).To(BeTrue())
+
+			var st unix.Stat_t
+			Expect(unix.Stat(filepath.Join(destDir, "sparse"), &st)).To(Succeed())
+			Expect(st.Size).To(Equal(int64(64 * 1024 * 1024)))
+			Expect(st.Blocks).To(BeNumerically("<", 128))
+		})
 		It("should not fail if dirs are empty", func() {
 			sourceDir, err := utils.TempDir(fs, "", "elementalsource")
 			Expect(err).ShouldNot(HaveOccurred())
@@ -573,6 +725,32 @@ var _ = Describe("Utils", Label("utils"), func() {
 			Expect(utils.SyncData(logger, nil, "/welp", destDir)).NotTo(BeNil())
 		})
 	})
+	Describe("ClampMtimes", Label("ClampMtimes"), func() {
+		It("Sets newer modification times to the given time", func() {
+			root, err := os.MkdirTemp("", "elementalclamp")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(root)
+
+			epoch := time.Unix(1672531200, 0)
+			older := epoch.Add(-time.Hour)
+			Expect(os.Mkdir(filepath.Join(root, "dir"), 0750)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(root, "dir", "new"), []byte("data"), 0640)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(root, "old"), []byte("data"), 0640)).To(Succeed())
+			Expect(os.Chtimes(filepath.Join(root, "old"), older, older)).To(Succeed())
+			Expect(os.Symlink("/nonexistent", filepath.Join(root, "dangling"))).To(Succeed())
+
+			Expect(utils.ClampMtimes(nil, root, epoch)).To(Succeed())
+
+			for _, path := range []string{root, filepath.Join(root, "dir"), filepath.Join(root, "dir", "new"), filepath.Join(root, "dangling")} {
+				info, err := os.Lstat(path)
+				Expect(err).To(BeNil())
+				Expect(info.ModTime().Equal(epoch)).To(BeTrue(), path)
+			}
+			info, err := os.Stat(filepath.Join(root, "old"))
+			Expect(err).To(BeNil())
+			Expect(info.ModTime().Equal(older)).To(BeTrue())
+		})
+	})
 	Describe("IsLocalURI", Label("uri"), func() {
 		It("Detects a local url", func() {
 			local, err := utils.IsLocalURI("file://some/path")
@@ -795,6 +973,10 @@ var _ = Describe("Utils", Label("utils"), func() {
 
 				err = fs.WriteFile(filepath.Join(rootDir, constants.GrubConf), []byte("console=tty1"), 0644)
 				Expect(err).ShouldNot(HaveOccurred())
+
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/share/grub2/i386-pc"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/sbin"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/sbin/grub2-install"), []byte{}, constants.FilePerm)).To(Succeed())
 			})
 			It("installs with default values", func() {
 				grub := utils.NewGrub(config)
@@ -810,6 +992,45 @@ var _ = Describe("Utils", Label("utils"), func() {
 				// Should not be modified at all
 				Expect(targetGrub).To(ContainSubstring("console=tty1"))
 
+				// grub2-install from the image is executed in a chroot
+				Expect(syscall.WasChrootCalledWith(rootDir)).To(BeTrue())
+				Expect(runner.CmdsMatch([][]string{{
+					"/usr/sbin/grub2-install", "--directory=/usr/share/grub2/i386-pc",
+					"--boot-directory=" + bootDir, "--target=i386-pc", target,
+				}})).To(Succeed())
+				// The bootDir mountpoint does not remain in the image
+				Expect(utils.Exists(fs, filepath.Join(rootDir, "/run"))).To(BeFalse())
+			})
+			It("installs with grub-install and modules found in the image", func() {
+				Expect(fs.RemoveAll(filepath.Join(rootDir, "/usr"))).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/lib/grub/i386-pc"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/bin"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/bin/grub-install"), []byte{}, constants.FilePerm)).To(Succeed())
+
+				grub := utils.NewGrub(config)
+				Expect(grub.Install(target, rootDir, bootDir, constants.GrubConf, false, "", true, false)).To(Succeed())
+				Expect(syscall.WasChrootCalledWith(rootDir)).To(BeTrue())
+				Expect(runner.CmdsMatch([][]string{{
+					"/usr/bin/grub-install", "--directory=/usr/lib/grub/i386-pc",
+					"--boot-directory=" + bootDir, "--target=i386-pc", target,
+				}})).To(Succeed())
+			})
+			It("fails if there is no grub-install binary in the image", func() {
+				Expect(fs.RemoveAll(filepath.Join(rootDir, "/usr/sbin"))).To(Succeed())
+				grub := utils.NewGrub(config)
+				Expect(grub.Install(target, rootDir, bootDir, constants.GrubConf, false, "", true, false)).NotTo(Succeed())
+				Expect(buf).To(ContainSubstring("Could not find grub-install binary"))
+				Expect(runner.GetCmds()).To(BeEmpty())
+			})
+			It("installs with host tools if requested", func() {
+				runner.CmdNotFound = "grub2-install"
+				grub := utils.NewGrub(config, utils.WithGrubHostTools(true))
+				Expect(grub.Install(target, rootDir, bootDir, constants.GrubConf, false, "", true, false)).To(Succeed())
+				Expect(syscall.WasChrootCalledWith(rootDir)).To(BeFalse())
+				Expect(runner.CmdsMatch([][]string{{
+					"grub-install", "--root-directory=" + rootDir,
+					"--boot-directory=" + bootDir, "--target=i386-pc", target,
+				}})).To(Succeed())
 			})
 			It("installs with efi firmware", Label("efi"), func() {
 				err := utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/share/efi/x86_64/"), constants.DirPerm)
@@ -822,8 +1043,10 @@ var _ = Describe("Utils", Label("utils"), func() {
 				Expect(err).ShouldNot(HaveOccurred())
 				err = utils.MkdirAll(fs, filepath.Join(rootDir, "/x86_64/"), constants.DirPerm)
 				Expect(err).ShouldNot(HaveOccurred())
-				err = fs.WriteFile(filepath.Join(rootDir, "/x86_64/loopback.mod"), []byte(""), constants.FilePerm)
-				Expect(err).ShouldNot(HaveOccurred())
+				for _, m := range []string{"loopback.mod", "squash4.mod", "xzio.mod"} {
+					err = fs.WriteFile(filepath.Join(rootDir, "/x86_64", m), []byte(""), constants.FilePerm)
+					Expect(err).ShouldNot(HaveOccurred())
+				}
 				err = utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)
 				Expect(err).ShouldNot(HaveOccurred())
 				err = fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=\"suse\""), constants.FilePerm)
@@ -849,7 +1072,30 @@ var _ = Describe("Utils", Label("utils"), func() {
 				Expect(err).To(BeNil())
 
 			})
+			It("installs with efi firmware signing grub without shim", Label("efi", "secureboot"), func() {
+				for _, f := range []string{"/usr/share/efi/x86_64/shim.efi", "/usr/share/efi/x86_64/MokManager.efi", "/usr/share/efi/x86_64/grub.efi", "/x86_64/loopback.mod", "/x86_64/squash4.mod", "/x86_64/xzio.mod"} {
+					Expect(utils.MkdirAll(fs, filepath.Dir(filepath.Join(rootDir, f)), constants.DirPerm)).To(Succeed())
+					Expect(fs.WriteFile(filepath.Join(rootDir, f), []byte(""), constants.FilePerm)).To(Succeed())
+				}
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=\"suse\""), constants.FilePerm)).To(Succeed())
+				config.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt", NoShim: true}
+
+				grub := utils.NewGrub(config)
+				err := grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				Expect(runner.CmdsMatch([][]string{
+					{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", "/run/cos/efi/EFI/boot/grub.efi", "/run/cos/efi/EFI/boot/grub.efi"},
+					{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", "/run/cos/efi/EFI/elemental/grub.efi", "/run/cos/efi/EFI/elemental/grub.efi"},
+				})).To(BeNil())
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/boot/bootx64.efi"))).To(BeTrue())
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/boot/shim.efi"))).To(BeFalse())
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/boot/MokManager.efi"))).To(BeFalse())
+			})
 			It("fails with efi if no modules files exist", Label("efi"), func() {
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=\"suse\""), constants.FilePerm)).To(Succeed())
 				grub := utils.NewGrub(config)
 				err := grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)
 				Expect(err).To(HaveOccurred())
@@ -869,8 +1115,10 @@ var _ = Describe("Utils", Label("utils"), func() {
 			It("fails with efi if no grub files exist", Label("efi"), func() {
 				err := utils.MkdirAll(fs, filepath.Join(rootDir, "/x86_64/"), constants.DirPerm)
 				Expect(err).ShouldNot(HaveOccurred())
-				err = fs.WriteFile(filepath.Join(rootDir, "/x86_64/loopback.mod"), []byte(""), constants.FilePerm)
-				Expect(err).ShouldNot(HaveOccurred())
+				for _, m := range []string{"loopback.mod", "squash4.mod", "xzio.mod"} {
+					err = fs.WriteFile(filepath.Join(rootDir, "/x86_64", m), []byte(""), constants.FilePerm)
+					Expect(err).ShouldNot(HaveOccurred())
+				}
 				err = fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=\"suse\""), constants.FilePerm)
 				Expect(err).ShouldNot(HaveOccurred())
 				grub := utils.NewGrub(config)
@@ -879,6 +1127,89 @@ var _ = Describe("Utils", Label("utils"), func() {
 				Expect(err.Error()).To(ContainSubstring("efi"))
 				Expect(err.Error()).To(ContainSubstring("artifacts"))
 			})
+			It("installs with efi firmware on debian based systems", Label("efi"), func() {
+				for _, f := range []string{
+					"/usr/lib/shim/shimx64.efi.signed", "/usr/lib/shim/shimx64.efi", "/usr/lib/shim/mmx64.efi",
+					"/usr/lib/grub/x86_64-efi-signed/grubx64.efi.signed", "/usr/lib/grub/x86_64-efi/loopback.mod",
+					"/usr/lib/grub/x86_64-efi/squash4.mod", "/usr/lib/grub/x86_64-efi/xzio.mod",
+				} {
+					Expect(utils.MkdirAll(fs, filepath.Dir(filepath.Join(rootDir, f)), constants.DirPerm)).To(Succeed())
+					Expect(fs.WriteFile(filepath.Join(rootDir, f), []byte(f), constants.FilePerm)).To(Succeed())
+				}
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=ubuntu\nID_LIKE=debian"), constants.FilePerm)).To(Succeed())
+
+				grub := utils.NewGrub(config)
+				Expect(grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)).To(Succeed())
+
+				// Signed binaries are preferred and installed without the .signed suffix
+				shim, err := fs.ReadFile(filepath.Join(constants.EfiDir, "EFI/elemental/shimx64.efi"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(shim)).To(Equal("/usr/lib/shim/shimx64.efi.signed"))
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/elemental/mmx64.efi"))).To(BeTrue())
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/elemental/grubx64.efi"))).To(BeTrue())
+				bootShim, err := fs.ReadFile(filepath.Join(constants.EfiDir, "EFI/boot/bootx64.efi"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bootShim).To(Equal(shim))
+			})
+			It("installs with efi firmware building grub on arch based systems", Label("efi"), func() {
+				for _, f := range []string{
+					"/usr/bin/grub-mkimage", "/usr/lib/grub/x86_64-efi/loopback.mod",
+					"/usr/lib/grub/x86_64-efi/squash4.mod", "/usr/lib/grub/x86_64-efi/xzio.mod",
+				} {
+					Expect(utils.MkdirAll(fs, filepath.Dir(filepath.Join(rootDir, f)), constants.DirPerm)).To(Succeed())
+					Expect(fs.WriteFile(filepath.Join(rootDir, f), []byte(""), constants.FilePerm)).To(Succeed())
+				}
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/lib/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/lib/os-release"), []byte("ID=endeavouros\nID_LIKE=arch"), constants.FilePerm)).To(Succeed())
+
+				// Arch does not ship a prebuilt grub EFI binary, it is built by grub-mkimage
+				runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+					if cmd == "/usr/bin/grub-mkimage" {
+						for _, arg := range args {
+							if strings.HasPrefix(arg, "--output=") {
+								return []byte{}, fs.WriteFile(strings.TrimPrefix(arg, "--output="), []byte("grub"), constants.FilePerm)
+							}
+						}
+					}
+					return []byte{}, nil
+				}
+
+				grub := utils.NewGrub(config)
+				Expect(grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)).To(Succeed())
+				Expect(syscall.WasChrootCalledWith(rootDir)).To(BeTrue())
+				Expect(runner.MatchMilestones([][]string{{
+					"/usr/bin/grub-mkimage", "--format=x86_64-efi", "--directory=/usr/lib/grub/x86_64-efi",
+					"--prefix=/EFI/boot", "--output=" + filepath.Join(constants.EfiDir, "EFI/boot/grubx64.efi"),
+				}})).To(Succeed())
+				Expect(utils.Exists(fs, filepath.Join(constants.EfiDir, "EFI/elemental/grubx64.efi"))).To(BeTrue())
+				bootGrub, err := fs.ReadFile(filepath.Join(constants.EfiDir, "EFI/boot/bootx64.efi"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(bootGrub)).To(Equal("grub"))
+				Expect(buf).To(ContainSubstring("booting grub directly"))
+				// The efiDir mountpoint does not remain in the image
+				Expect(utils.Exists(fs, filepath.Join(rootDir, constants.EfiDir))).To(BeFalse())
+			})
+			It("fails with efi on arch based systems without grub-mkimage", Label("efi"), func() {
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/lib/grub/x86_64-efi"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=arch"), constants.FilePerm)).To(Succeed())
+				for _, m := range []string{"loopback", "squash4", "xzio"} {
+					Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/lib/grub/x86_64-efi", m+".mod"), []byte(""), constants.FilePerm)).To(Succeed())
+				}
+				grub := utils.NewGrub(config)
+				err := grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("grub-mkimage"))
+			})
+			It("fails with efi on systems without bootloader profile", Label("efi"), func() {
+				Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/etc/"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(rootDir, "/etc/os-release"), []byte("ID=unknown"), constants.FilePerm)).To(Succeed())
+				grub := utils.NewGrub(config)
+				err := grub.Install(target, rootDir, bootDir, constants.GrubConf, true, "", true, false)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no bootloader profile"))
+			})
 			It("Fails if it can't read grub config file", func() {
 				err := fs.RemoveAll(filepath.Join(rootDir, constants.GrubConf))
 				Expect(err).ShouldNot(HaveOccurred())
@@ -892,22 +1223,27 @@ var _ = Describe("Utils", Label("utils"), func() {
 			It("Sets the grub environment file", func() {
 				grub := utils.NewGrub(config)
 				Expect(grub.SetPersistentVariables(
-					"somefile", map[string]string{"key1": "value1", "key2": "value2"},
+					"/somefile", map[string]string{"key1": "value1", "key2": "value2"},
 				)).To(BeNil())
-				Expect(runner.IncludesCmds([][]string{
-					{"grub2-editenv", "somefile", "set", "key1=value1"},
-					{"grub2-editenv", "somefile", "set", "key2=value2"},
-				})).To(BeNil())
+				env, err := utils.ReadGrubEnv(fs, "/somefile")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(env.Keys()).To(Equal([]string{"key1", "key2"}))
+				Expect(runner.GetCmds()).To(BeEmpty())
+			})
+			It("Keeps the existing variables", func() {
+				grub := utils.NewGrub(config)
+				Expect(grub.SetPersistentVariables("/somefile", map[string]string{"key1": "value1"})).To(BeNil())
+				Expect(grub.SetPersistentVariables("/somefile", map[string]string{"key2": "value2"})).To(BeNil())
+				env, err := utils.ReadGrubEnv(fs, "/somefile")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(env.Keys()).To(Equal([]string{"key1", "key2"}))
 			})
-			It("Fails running grub2-editenv", func() {
-				runner.ReturnError = errors.New("grub error")
+			It("Fails writing the grub environment file", func() {
+				Expect(fs.Mkdir("/somefile", constants.DirPerm)).To(Succeed())
 				grub := utils.NewGrub(config)
 				Expect(grub.SetPersistentVariables(
-					"somefile", map[string]string{"key1": "value1"},
+					"/somefile", map[string]string{"key1": "value1"},
 				)).NotTo(BeNil())
-				Expect(runner.CmdsMatch([][]string{
-					{"grub2-editenv", "somefile", "set", "key1=value1"},
-				})).To(BeNil())
 			})
 		})
 		Describe("CreateBootEntry", Label("bootentry"), func() {
@@ -997,6 +1333,169 @@ var _ = Describe("Utils", Label("utils"), func() {
 				Expect(option.FilePath.String()).To(ContainSubstring(`\EFI\test1.efi`))
 			})
 		})
+		Describe("ClearBootEntry", Label("bootentry"), func() {
+			var efivars *eleefi.MockEFIVariables
+			var ghwTest v1mock.GhwMock
+			const targetGUID = "c12a7328-f81f-11d2-ba4b-00a0c93ec93b"
+			const otherGUID = "6a7d1d5a-ab7e-4b07-9e3c-4ac3d2d9e7c1"
+			const goneGUID = "0fc63daf-8483-4772-8e79-3d69d8477de4"
+
+			addEntry := func(num int, description, guid string) {
+				sig, err := efi.DecodeGUIDString(guid)
+				Expect(err).ToNot(HaveOccurred())
+				option := &efi.LoadOption{
+					Attributes:  efi.LoadOptionActive,
+					Description: description,
+					FilePath: efi.DevicePath{
+						&efi.HardDriveDevicePathNode{
+							PartitionNumber: 1,
+							Signature:       efi.GUIDHardDriveSignature(sig),
+							MBRType:         efi.GPT,
+						},
+						efi.NewFilePathDevicePathNode("/EFI/elemental/shim.efi"),
+					},
+				}
+				data, err := option.Bytes()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(efivars.SetVariable(
+					efi.GlobalVariable, fmt.Sprintf("Boot%04X", num), data,
+					efi.AttributeNonVolatile|efi.AttributeBootserviceAccess|efi.AttributeRuntimeAccess,
+				)).To(Succeed())
+			}
+			BeforeEach(func() {
+				efivars = &eleefi.MockEFIVariables{}
+				ghwTest = v1mock.GhwMock{}
+				ghwTest.AddDisk(block.Disk{Name: "sda", Partitions: []*block.Partition{{Name: "sda1", UUID: targetGUID}}})
+				ghwTest.AddDisk(block.Disk{Name: "sdb", Partitions: []*block.Partition{{Name: "sdb1", UUID: strings.ToUpper(otherGUID)}}})
+				ghwTest.CreateDevices()
+
+				addEntry(0, "elemental-shim", targetGUID)
+				addEntry(1, "elemental-shim", otherGUID)
+				addEntry(2, "elemental-shim", goneGUID)
+				addEntry(3, "other-os", goneGUID)
+				Expect(efivars.SetVariable(
+					efi.GlobalVariable, "BootOrder", []byte{0, 0, 1, 0, 2, 0, 3, 0},
+					efi.AttributeNonVolatile|efi.AttributeBootserviceAccess|efi.AttributeRuntimeAccess,
+				)).To(Succeed())
+			})
+			AfterEach(func() {
+				ghwTest.Clean()
+			})
+			It("removes entries of the target disk and of missing partitions only", func() {
+				grub := utils.NewGrub(config)
+				Expect(grub.ClearBootEntry("/dev/sda", efivars)).To(Succeed())
+
+				for num, exists := range map[int]bool{0: false, 1: true, 2: false, 3: true} {
+					_, _, err := efivars.GetVariable(efi.GlobalVariable, fmt.Sprintf("Boot%04X", num))
+					Expect(err == nil).To(Equal(exists), "Boot%04X", num)
+				}
+				order, _, err := efivars.GetVariable(efi.GlobalVariable, "BootOrder")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(order).To(Equal([]byte{1, 0, 3, 0}))
+			})
+			It("keeps entries of other disks when reinstalling a different disk", func() {
+				grub := utils.NewGrub(config)
+				Expect(grub.ClearBootEntry("/dev/sdb", efivars)).To(Succeed())
+
+				for num, exists := range map[int]bool{0: true, 1: false, 2: false, 3: true} {
+					_, _, err := efivars.GetVariable(efi.GlobalVariable, fmt.Sprintf("Boot%04X", num))
+					Expect(err == nil).To(Equal(exists), "Boot%04X", num)
+				}
+			})
+		})
+	})
+	Describe("GrubEnv", Label("grub", "grubenv"), func() {
+		// Block as written by grub2-editenv, including an escaped backslash and newline
+		editenvBlock := func() []byte {
+			block := "# GRUB Environment Block\nsaved_entry=recovery\nnext_entry=\nmulti=a\\\\b\\\nc\n"
+			return append([]byte(block), bytes.Repeat([]byte("#"), 1024-len(block))...)
+		}
+		It("parses blocks written by grub2-editenv", func() {
+			Expect(fs.WriteFile("/grubenv", editenvBlock(), constants.FilePerm)).To(Succeed())
+			env, err := utils.ReadGrubEnv(fs, "/grubenv")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Keys()).To(Equal([]string{"saved_entry", "next_entry", "multi"}))
+			value, ok := env.Get("saved_entry")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("recovery"))
+			value, ok = env.Get("next_entry")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(BeEmpty())
+			value, _ = env.Get("multi")
+			Expect(value).To(Equal("a\\b\nc"))
+			_, ok = env.Get("missing")
+			Expect(ok).To(BeFalse())
+		})
+		It("writes the same block grub2-editenv writes", func() {
+			env := utils.NewGrubEnv()
+			env.Set("saved_entry", "recovery")
+			env.Set("next_entry", "")
+			env.Set("multi", "a\\b\nc")
+			Expect(utils.MkdirAll(fs, "/boot", constants.DirPerm)).To(Succeed())
+			Expect(utils.WriteGrubEnv(fs, "/boot/grubenv", env)).To(Succeed())
+			data, err := fs.ReadFile("/boot/grubenv")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(editenvBlock()))
+			// No temporary files are left behind
+			files, err := fs.ReadDir("/boot")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+		})
+		It("unsets variables keeping the order of the others", func() {
+			Expect(fs.WriteFile("/grubenv", editenvBlock(), constants.FilePerm)).To(Succeed())
+			env, err := utils.ReadGrubEnv(fs, "/grubenv")
+			Expect(err).ToNot(HaveOccurred())
+			env.Unset("next_entry")
+			env.Unset("missing")
+			env.Set("saved_entry", "active")
+			Expect(utils.WriteGrubEnv(fs, "/grubenv", env)).To(Succeed())
+
+			env, err = utils.ReadGrubEnv(fs, "/grubenv")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Keys()).To(Equal([]string{"saved_entry", "multi"}))
+			value, _ := env.Get("saved_entry")
+			Expect(value).To(Equal("active"))
+		})
+		It("returns an empty block for missing files", func() {
+			env, err := utils.ReadGrubEnv(fs, "/grubenv")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Keys()).To(BeEmpty())
+		})
+		It("fails to parse files without the grub environment header", func() {
+			Expect(fs.WriteFile("/grubenv", []byte("key=value\n"), constants.FilePerm)).To(Succeed())
+			_, err := utils.ReadGrubEnv(fs, "/grubenv")
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails to write blocks exceeding 1024 bytes", func() {
+			env := utils.NewGrubEnv()
+			env.Set("big", strings.Repeat("x", 1024))
+			Expect(utils.WriteGrubEnv(fs, "/grubenv", env)).NotTo(Succeed())
+			Expect(utils.Exists(fs, "/grubenv")).To(BeFalse())
+		})
+		It("fails to write invalid variable names", func() {
+			env := utils.NewGrubEnv()
+			env.Set("in=valid", "value")
+			Expect(utils.WriteGrubEnv(fs, "/grubenv", env)).NotTo(Succeed())
+		})
+	})
+	Describe("SignEFI", Label("SignEFI", "secureboot"), func() {
+		It("does nothing without signing keys", func() {
+			Expect(utils.SignEFI(config, "/some/file.efi")).To(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("signs the given files in place", func() {
+			config.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt"}
+			Expect(utils.SignEFI(config, "/some/file.efi", "/some/vmlinuz")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{
+				{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", "/some/file.efi", "/some/file.efi"},
+				{"sbsign", "--key", "/keys/db.key", "--cert", "/keys/db.crt", "--output", "/some/vmlinuz", "/some/vmlinuz"},
+			})).To(BeNil())
+		})
+		It("fails if sbsign fails", func() {
+			config.SecureBoot = v1.SecureBoot{Key: "/keys/db.key", Cert: "/keys/db.crt"}
+			runner.ReturnError = errors.New("sbsign failed")
+			Expect(utils.SignEFI(config, "/some/file.efi")).NotTo(Succeed())
+		})
 	})
 	Describe("CreateSquashFS", Label("CreateSquashFS"), func() {
 		It("runs with no options if none given", func() {
@@ -1024,6 +1523,59 @@ var _ = Describe("Utils", Label("utils"), func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Describe("SquashfsOptions", Label("SquashfsOptions"), func() {
+		var help string
+		BeforeEach(func() {
+			help = "SYNTAX:mksquashfs source1 source2 ...  dest [options]\n\n" +
+				"Compressors available and compressor specific options:\n" +
+				"\tgzip (default)\n" +
+				"\t  -Xcompression-level <compression-level>\n" +
+				"\txz\n" +
+				"\t  -Xbcj filter1,filter2,...,filterN\n" +
+				"\tzstd\n" +
+				"\t  -Xcompression-level <compression-level>\n"
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "mksquashfs" && args[0] == "-help" {
+					return []byte(help), errors.New("exit status 1")
+				}
+				return []byte{}, nil
+			}
+		})
+		It("returns the configured compression options if no profile is given", func() {
+			config.SquashFsCompressionConfig = []string{"-comp", "gzip"}
+			opts, err := utils.SquashfsOptions(config, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(Equal(append(constants.GetDefaultSquashfsOptions(), "-comp", "gzip")))
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("returns the options of a supported profile", func() {
+			opts, err := utils.SquashfsOptions(config, constants.SquashCompressionZstd19)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(ContainElements("-comp", "zstd", "-Xcompression-level", "19"))
+		})
+		It("fails for profiles not supported by mksquashfs", func() {
+			_, err := utils.SquashfsOptions(config, constants.SquashCompressionLz4Fast)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("lz4"))
+		})
+		It("does not check the compressors without compression", func() {
+			opts, err := utils.SquashfsOptions(config, constants.SquashCompressionNone)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(ContainElement("-noD"))
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("fails for unknown profiles", func() {
+			_, err := utils.SquashfsOptions(config, "brotli")
+			Expect(err).To(HaveOccurred())
+		})
+		It("fixes all timestamps if SOURCE_DATE_EPOCH is set", func() {
+			epoch := time.Unix(1672531200, 0)
+			config.SourceDateEpoch = &epoch
+			opts, err := utils.SquashfsOptions(config, constants.SquashCompressionZstd19)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(ContainElements("-mkfs-time", "1672531200", "-all-time", "-comp", "zstd"))
+		})
+	})
 	Describe("LoadEnvFile", Label("LoadEnvFile"), func() {
 		BeforeEach(func() {
 			fs.Mkdir("/etc", constants.DirPerm)
@@ -1302,6 +1854,67 @@ var _ = Describe("Utils", Label("utils"), func() {
 		})
 
 	})
+	Describe("BootloaderProfiles", Label("grub", "efi"), func() {
+		It("matches built-in profiles by ID", func() {
+			for id, name := range map[string]string{
+				"opensuse-leap": "suse", "sle-micro": "suse", "fedora": "fedora", "rocky": "fedora",
+				"rhel": "fedora", "debian": "debian", "ubuntu": "debian", "arch": "arch",
+			} {
+				profile, err := utils.GetBootloaderProfile(config, map[string]string{"ID": id})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(profile.Name).To(Equal(name))
+			}
+		})
+		It("matches built-in profiles by ID_LIKE", func() {
+			profile, err := utils.GetBootloaderProfile(config, map[string]string{"ID": "linuxmint", "ID_LIKE": "ubuntu debian"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Name).To(Equal("debian"))
+		})
+		It("prefers custom profiles", func() {
+			config.BootloaderProfiles = []v1.BootloaderProfile{{
+				Name: "custom", IDs: []string{"ubuntu"},
+				EFI: map[string]v1.EFIBinaries{"x86_64": {Grub: v1.EFIBinary{Name: "grubx64.efi"}}},
+			}}
+			profile, err := utils.GetBootloaderProfile(config, map[string]string{"ID": "ubuntu", "ID_LIKE": "debian"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Name).To(Equal("custom"))
+		})
+		It("fails for unknown systems", func() {
+			_, err := utils.GetBootloaderProfile(config, map[string]string{"ID": "unknown"})
+			Expect(err).To(HaveOccurred())
+		})
+		It("loads profiles from a directory", func() {
+			Expect(utils.MkdirAll(fs, "/profiles", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/profiles/b.yaml", []byte("name: b\nids: [b]\nefi:\n  x86_64:\n    grub:\n      name: grubx64.efi\n      unsigned: true\n"), constants.FilePerm)).To(Succeed())
+			Expect(fs.WriteFile("/profiles/a.yaml", []byte("name: a\nids: [a, aa]\nmodules: [loopback]\n"), constants.FilePerm)).To(Succeed())
+			Expect(fs.WriteFile("/profiles/README", []byte("ignored"), constants.FilePerm)).To(Succeed())
+
+			profiles, err := utils.LoadBootloaderProfiles(fs, "/profiles")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(profiles)).To(Equal(2))
+			Expect(profiles[0].Name).To(Equal("a"))
+			Expect(profiles[0].Modules).To(Equal([]string{"loopback"}))
+			Expect(profiles[1].EFI["x86_64"].Grub.Name).To(Equal("grubx64.efi"))
+			Expect(profiles[1].EFI["x86_64"].Grub.Unsigned).To(BeTrue())
+		})
+		It("loads no profiles from a non existing directory", func() {
+			profiles, err := utils.LoadBootloaderProfiles(fs, "/profiles")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profiles).To(BeEmpty())
+		})
+		It("fails loading invalid profiles", func() {
+			Expect(utils.MkdirAll(fs, "/profiles", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/profiles/a.yaml", []byte("name: a\n"), constants.FilePerm)).To(Succeed())
+			_, err := utils.LoadBootloaderProfiles(fs, "/profiles")
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails loading profiles with an unsigned shim", func() {
+			Expect(utils.MkdirAll(fs, "/profiles", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/profiles/a.yaml", []byte("name: a\nids: [a]\nefi:\n  x86_64:\n    shim:\n      name: shimx64.efi\n      unsigned: true\n    grub:\n      name: grubx64.efi\n"), constants.FilePerm)).To(Succeed())
+			_, err := utils.LoadBootloaderProfiles(fs, "/profiles")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 	Describe("IdentifySourceSystem", Label("fs", "IdentifySourceSystem"), func() {
 		var rootDir string
 		var buf *bytes.Buffer
@@ -80,6 +80,27 @@ func (g *GhwMock) CreateDevices() {
 		// For each dir we create the /sys/block/DISK_NAME
 		diskPath := filepath.Join(g.paths.SysBlock, disk.Name)
 		_ = os.Mkdir(diskPath, 0755)
+		// Create the /sys/block/DISK_NAME/size file which contains the disk size in 512 bytes sectors
+		if disk.SizeBytes > 0 {
+			_ = os.WriteFile(filepath.Join(diskPath, "size"), []byte(fmt.Sprintf("%d\n", disk.SizeBytes/512)), 0644)
+		}
+		// Create the /sys/block/DISK_NAME/queue/rotational file for hard disk drives
+		if disk.DriveType == block.DRIVE_TYPE_HDD {
+			_ = os.MkdirAll(filepath.Join(diskPath, "queue"), 0755)
+			_ = os.WriteFile(filepath.Join(diskPath, "queue", "rotational"), []byte("1\n"), 0644)
+		}
+		// Create the /sys/block/DISK_NAME/dev file and its udev database entry to include the disk model and bus path
+		if disk.Model != "" || disk.BusPath != "" {
+			data := []string{}
+			if disk.Model != "" {
+				data = append(data, fmt.Sprintf("E:ID_MODEL=%s\n", disk.Model))
+			}
+			if disk.BusPath != "" {
+				data = append(data, fmt.Sprintf("E:ID_PATH=%s\n", disk.BusPath))
+			}
+			_ = os.WriteFile(filepath.Join(diskPath, "dev"), []byte(fmt.Sprintf("%d:0\n", indexDisk)), 0644)
+			_ = os.WriteFile(filepath.Join(g.paths.RunUdevData, fmt.Sprintf("b%d:0", indexDisk)), []byte(strings.Join(data, "")), 0644)
+		}
 		for indexPart, partition := range disk.Partitions {
 			// For each partition we create the /sys/block/DISK_NAME/PARTITION_NAME
 			_ = os.Mkdir(filepath.Join(diskPath, partition.Name), 0755)
@@ -90,6 +111,12 @@ func (g *GhwMock) CreateDevices() {
 			if partition.Type != "" {
 				data = append(data, fmt.Sprintf("E:ID_FS_TYPE=%s\n", partition.Type))
 			}
+			if partition.Label != "" {
+				data = append(data, fmt.Sprintf("E:ID_PART_ENTRY_NAME=%s\n", partition.Label))
+			}
+			if partition.UUID != "" {
+				data = append(data, fmt.Sprintf("E:ID_PART_ENTRY_UUID=%s\n", partition.UUID))
+			}
 			_ = os.WriteFile(filepath.Join(g.paths.RunUdevData, fmt.Sprintf("b%d:6%d", indexDisk, indexPart)), []byte(strings.Join(data, "")), 0644)
 			// If we got a mountpoint, add it to our fake /proc/self/mounts
 			if partition.MountPoint != "" {
@@ -17,6 +17,7 @@ limitations under the License.
 package mocks
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -31,6 +32,7 @@ type FakeRunner struct {
 	ReturnError error
 	Logger      v1.Logger
 	CmdNotFound string
+	Context     context.Context
 }
 
 func NewFakeRunner() *FakeRunner {
@@ -42,16 +44,28 @@ func (r *FakeRunner) CommandExists(command string) bool {
 }
 
 func (r *FakeRunner) Run(command string, args ...string) ([]byte, error) {
+	return r.RunContext(r.context(), command, args...)
+}
+
+// RunContext records the command and fails without running it if the given context is done
+func (r *FakeRunner) RunContext(ctx context.Context, command string, args ...string) ([]byte, error) {
 	r.debug(fmt.Sprintf("Running cmd: '%s %s'", command, strings.Join(args, " ")))
 	r.InitCmd(command, args...)
-	out, err := r.RunCmd(nil)
+	out, err := r.RunCmdContext(ctx, nil)
 	if err != nil {
 		r.error(fmt.Sprintf("Error running command: %s", err.Error()))
 	}
 	return out, err
 }
 
-func (r *FakeRunner) RunCmd(_ *exec.Cmd) ([]byte, error) {
+func (r *FakeRunner) RunCmd(cmd *exec.Cmd) ([]byte, error) {
+	return r.RunCmdContext(r.context(), cmd)
+}
+
+func (r *FakeRunner) RunCmdContext(ctx context.Context, _ *exec.Cmd) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if r.SideEffect != nil {
 		if len(r.cmds) > 0 {
 			lastCmd := len(r.cmds) - 1
@@ -61,9 +75,18 @@ func (r *FakeRunner) RunCmd(_ *exec.Cmd) ([]byte, error) {
 	return r.ReturnValue, r.ReturnError
 }
 
+// InitCmd records the command, the returned command is never started
 func (r *FakeRunner) InitCmd(command string, args ...string) *exec.Cmd {
 	r.cmds = append(r.cmds, append([]string{command}, args...))
-	return nil
+	return exec.Command(command, args...)
+}
+
+// LastCommand returns the last command run, the output is not tracked
+func (r FakeRunner) LastCommand() (string, []byte) {
+	if len(r.cmds) == 0 {
+		return "", nil
+	}
+	return strings.Join(r.cmds[len(r.cmds)-1], " "), nil
 }
 
 func (r *FakeRunner) ClearCmds() {
@@ -145,6 +168,17 @@ func (r *FakeRunner) SetLogger(logger v1.Logger) {
 	r.Logger = logger
 }
 
+func (r *FakeRunner) SetContext(ctx context.Context) {
+	r.Context = ctx
+}
+
+func (r FakeRunner) context() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
+}
+
 func (r FakeRunner) error(msg string) {
 	if r.Logger != nil {
 		r.Logger.Error(msg)